@@ -0,0 +1,98 @@
+// Command dns-executor is gnat with an extra "dns" executor, which resolves a
+// host name on every iteration. It shows how executors are added without
+// changing gnat: register them, then call gnat.Main.
+//
+//	go run ./examples/dns-executor
+//
+// A setup using it:
+//
+//	{"name": "resolve", "executor": "dns", "rps": 10, "duration": "30s",
+//	 "executor_config": {"host": "example.com", "server": "1.1.1.1:53"}}
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/bdtfs/gnat/pkg/executor"
+	"github.com/bdtfs/gnat/pkg/gnat"
+)
+
+type config struct {
+	Host string `json:"host"`
+	// Server is the DNS server to ask; the system resolver is used if it is
+	// empty.
+	Server string `json:"server"`
+}
+
+func init() {
+	executor.Register("dns", executor.Definition{
+		New: func() executor.Executor { return &dnsExecutor{} },
+		Validate: func(setup *executor.Setup) error {
+			_, err := decodeConfig(setup)
+			return err
+		},
+	})
+}
+
+func main() {
+	gnat.Main()
+}
+
+func decodeConfig(setup *executor.Setup) (config, error) {
+	var cfg config
+	if err := executor.DecodeConfig(setup, &cfg); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Host == "" {
+		return cfg, fmt.Errorf("executor_config.host is required")
+	}
+
+	return cfg, nil
+}
+
+type dnsExecutor struct {
+	host     string
+	resolver *net.Resolver
+}
+
+func (e *dnsExecutor) Prepare(_ context.Context, setup *executor.Setup) error {
+	cfg, err := decodeConfig(setup)
+	if err != nil {
+		return err
+	}
+
+	e.host = cfg.Host
+	e.resolver = net.DefaultResolver
+
+	if cfg.Server != "" {
+		e.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, cfg.Server)
+			},
+		}
+	}
+
+	return nil
+}
+
+func (e *dnsExecutor) Execute(ctx context.Context) *executor.Result {
+	start := time.Now()
+	addrs, err := e.resolver.LookupHost(ctx, e.host)
+
+	res := &executor.Result{Timestamp: start, Latency: time.Since(start), Error: err}
+	if len(addrs) > 0 {
+		res.RemoteIP = addrs[0]
+	}
+
+	return res
+}
+
+func (e *dnsExecutor) Close() error {
+	return nil
+}
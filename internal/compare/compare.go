@@ -0,0 +1,258 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/histogram"
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+const (
+	DefaultTolerance = 0.05
+	DefaultAlpha     = 0.05
+)
+
+var percentiles = []float64{50, 75, 90, 95, 99, 99.9}
+
+type Options struct {
+	// Tolerance is the relative change, as a fraction, a metric may move in
+	// the worse direction before it is flagged as a regression.
+	Tolerance float64
+	// Alpha is the significance level of the latency distribution test.
+	Alpha float64
+}
+
+type metric struct {
+	name      string
+	direction models.Direction
+	latency   bool
+	value     func(s *snapshot) float64
+}
+
+// metrics lists every compared stat. Latency metrics are only flagged when the
+// distributions differ significantly, so noise in a single percentile is not
+// reported as a regression. The achieved rps follows the target rate of each
+// run, so it is reported but never flagged.
+var metrics = []metric{
+	{name: "total", direction: models.DirectionNeutral, value: func(s *snapshot) float64 { return float64(s.total) }},
+	{name: "success", direction: models.DirectionNeutral, value: func(s *snapshot) float64 { return float64(s.success) }},
+	{name: "failed", direction: models.DirectionLowerIsBetter, value: func(s *snapshot) float64 { return float64(s.failed) }},
+	{name: "cancelled", direction: models.DirectionNeutral, value: func(s *snapshot) float64 { return float64(s.cancelled) }},
+	{name: "success_rate", direction: models.DirectionHigherIsBetter, value: func(s *snapshot) float64 { return s.successRate }},
+	{name: "first_attempt_success_rate", direction: models.DirectionHigherIsBetter, value: func(s *snapshot) float64 { return s.firstAttemptRate }},
+	{name: "retries", direction: models.DirectionLowerIsBetter, value: func(s *snapshot) float64 { return float64(s.retries) }},
+	{name: "rps", direction: models.DirectionNeutral, value: func(s *snapshot) float64 { return s.rps }},
+	{name: "avg_latency_ms", direction: models.DirectionLowerIsBetter, latency: true, value: func(s *snapshot) float64 { return milliseconds(s.latencies.Mean()) }},
+	{name: "min_latency_ms", direction: models.DirectionLowerIsBetter, latency: true, value: func(s *snapshot) float64 { return milliseconds(s.latencies.Min) }},
+	{name: "max_latency_ms", direction: models.DirectionLowerIsBetter, latency: true, value: func(s *snapshot) float64 { return milliseconds(s.latencies.Max) }},
+	{name: "bytes_read", direction: models.DirectionNeutral, value: func(s *snapshot) float64 { return float64(s.bytesRead) }},
+	{name: "connections_opened", direction: models.DirectionNeutral, value: func(s *snapshot) float64 { return float64(s.connections) }},
+	{name: "connection_errors", direction: models.DirectionLowerIsBetter, value: func(s *snapshot) float64 { return float64(s.connectionErrors) }},
+	{name: "avg_connect_latency_ms", direction: models.DirectionLowerIsBetter, value: func(s *snapshot) float64 { return s.avgConnect }},
+	{name: "avg_tls_handshake_ms", direction: models.DirectionLowerIsBetter, value: func(s *snapshot) float64 { return s.avgHandshake }},
+}
+
+// Runs compares the stats of candidate against base. Latency comparisons use
+// the stored latency histograms of both runs.
+func Runs(base, candidate *models.Run, opts Options) (*models.Comparison, error) {
+	if opts.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+
+	if opts.Alpha <= 0 || opts.Alpha >= 1 {
+		return nil, fmt.Errorf("alpha must be between 0 and 1")
+	}
+
+	b, err := snapshotOf(base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	c, err := snapshotOf(candidate)
+	if err != nil {
+		return nil, fmt.Errorf("candidate: %w", err)
+	}
+
+	statistic, pValue := histogram.KolmogorovSmirnov(b.latencies, c.latencies)
+	significant := pValue < opts.Alpha
+
+	out := &models.Comparison{
+		Base:      base,
+		Candidate: candidate,
+		Tolerance: opts.Tolerance,
+		Alpha:     opts.Alpha,
+		Significance: models.Significance{
+			Test:        "kolmogorov-smirnov",
+			Statistic:   statistic,
+			PValue:      pValue,
+			Significant: significant,
+		},
+	}
+
+	for _, m := range metrics {
+		d := models.MetricDelta{
+			Name:      m.name,
+			Direction: m.direction,
+			Base:      m.value(b),
+			Candidate: m.value(c),
+		}
+		d.Delta, d.DeltaPct = delta(d.Base, d.Candidate)
+
+		if !m.latency || significant {
+			d.Regression, d.Improvement = judge(m.direction, d.Base, d.Candidate, opts.Tolerance)
+		}
+
+		if d.Regression {
+			out.Regressions = append(out.Regressions, m.name)
+		}
+
+		out.Metrics = append(out.Metrics, d)
+	}
+
+	for _, p := range percentiles {
+		d := models.PercentileDelta{
+			Percentile: p,
+			Base:       milliseconds(b.latencies.Quantile(p / 100)),
+			Candidate:  milliseconds(c.latencies.Quantile(p / 100)),
+		}
+		d.Delta, d.DeltaPct = delta(d.Base, d.Candidate)
+
+		if significant {
+			d.Regression, d.Improvement = judge(models.DirectionLowerIsBetter, d.Base, d.Candidate, opts.Tolerance)
+		}
+
+		if d.Regression {
+			out.Regressions = append(out.Regressions, fmt.Sprintf("p%g_latency_ms", p))
+		}
+
+		out.Percentiles = append(out.Percentiles, d)
+	}
+
+	out.Verdict = verdict(out)
+
+	return out, nil
+}
+
+func verdict(c *models.Comparison) models.Verdict {
+	if len(c.Regressions) > 0 {
+		return models.VerdictRegression
+	}
+
+	for _, m := range c.Metrics {
+		if m.Improvement {
+			return models.VerdictImprovement
+		}
+	}
+
+	for _, p := range c.Percentiles {
+		if p.Improvement {
+			return models.VerdictImprovement
+		}
+	}
+
+	return models.VerdictUnchanged
+}
+
+func delta(base, candidate float64) (float64, *float64) {
+	d := candidate - base
+	if base == 0 {
+		return d, nil
+	}
+
+	pct := d / math.Abs(base) * 100
+	return d, &pct
+}
+
+// judge reports whether candidate is worse or better than base by more than
+// the tolerance. A change away from zero always exceeds it.
+func judge(direction models.Direction, base, candidate, tolerance float64) (regression, improvement bool) {
+	var worse, better bool
+	switch direction {
+	case models.DirectionLowerIsBetter:
+		worse, better = candidate > base, candidate < base
+	case models.DirectionHigherIsBetter:
+		worse, better = candidate < base, candidate > base
+	default:
+		return false, false
+	}
+
+	beyond := base == 0 && candidate != 0 || base != 0 && math.Abs(candidate-base)/math.Abs(base) > tolerance
+
+	return worse && beyond, better && beyond
+}
+
+type snapshot struct {
+	total, success, failed, cancelled uint64
+	retries                           uint64
+	bytesRead                         uint64
+	connections, connectionErrors     uint64
+	successRate, firstAttemptRate     float64
+	rps                               float64
+	avgConnect, avgHandshake          float64
+	latencies                         *histogram.Histogram
+}
+
+func snapshotOf(run *models.Run) (*snapshot, error) {
+	run.StateMu.RLock()
+	s, startedAt, endedAt := run.Stats, run.StartedAt, run.EndedAt
+	run.StateMu.RUnlock()
+
+	if s == nil {
+		return nil, fmt.Errorf("run %s has no stats", run.ID)
+	}
+
+	if !run.CompactedAt.IsZero() {
+		return nil, fmt.Errorf("run %s was compacted and has no latency distribution", run.ID)
+	}
+
+	// The counters of a live run are still being added to.
+	out := &snapshot{
+		total:            atomic.LoadUint64(&s.TotalRequests),
+		success:          atomic.LoadUint64(&s.SuccessRequests),
+		failed:           atomic.LoadUint64(&s.FailedRequests),
+		cancelled:        atomic.LoadUint64(&s.CancelledRequests),
+		bytesRead:        atomic.LoadUint64(&s.TotalBytesRead),
+		connectionErrors: atomic.LoadUint64(&s.ConnectionErrors),
+	}
+
+	completed := out.success + out.failed
+	if completed > 0 {
+		out.successRate = float64(out.success) / float64(completed)
+		out.firstAttemptRate = float64(atomic.LoadUint64(&s.FirstAttemptSuccess)) / float64(completed)
+	}
+
+	if attempts := atomic.LoadUint64(&s.Attempts); attempts > completed {
+		out.retries = attempts - completed
+	}
+
+	end := endedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if elapsed := end.Sub(startedAt).Seconds(); elapsed > 0 {
+		out.rps = float64(out.total) / elapsed
+	}
+
+	s.ConnectLatencyMu.Lock()
+	out.connections = atomic.LoadUint64(&s.ConnectionsOpened)
+	if out.connections > 0 {
+		out.avgConnect = milliseconds(s.TotalConnectLatency) / float64(out.connections)
+	}
+	if handshakes := atomic.LoadUint64(&s.Handshakes); handshakes > 0 {
+		out.avgHandshake = milliseconds(s.TotalHandshakeLatency) / float64(handshakes)
+	}
+	s.ConnectLatencyMu.Unlock()
+
+	s.LatencyMu.Lock()
+	out.latencies = s.Latencies.Clone()
+	s.LatencyMu.Unlock()
+
+	return out, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
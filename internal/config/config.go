@@ -9,10 +9,45 @@ import (
 type Config struct {
 	Application      *Application
 	HTTPClientConfig *HTTPClientConfig
+	Runner           *Runner
+	Storage          *Storage
+	Retention        *Retention
 }
 
+// Application configures the API server. EventsInterval is how often clients
+// streaming the events of a run get its stats.
 type Application struct {
-	Port int
+	Port           int
+	EventsInterval time.Duration
+}
+
+type Runner struct {
+	MaxConcurrentRuns  int
+	MaxTotalRPS        int
+	CheckpointInterval time.Duration
+	ShutdownTimeout    time.Duration
+}
+
+// Storage selects the repository backend: "memory", "bolt" or "postgres".
+// Path is the database file of the bolt backend; the Postgres fields configure
+// the postgres backend.
+type Storage struct {
+	Backend string
+	Path    string
+
+	PostgresDSN      string
+	PostgresMaxConns int
+	PostgresTimeout  time.Duration
+}
+
+// Retention compacts and deletes finished runs. KeepRuns is the number of
+// runs per setup that keep their full stats; CompactAfter and DeleteAfter are
+// ages after which runs are compacted and deleted. Zero values disable them.
+type Retention struct {
+	KeepRuns     int
+	CompactAfter time.Duration
+	DeleteAfter  time.Duration
+	Interval     time.Duration
 }
 
 type HTTPClientConfig struct {
@@ -30,7 +65,8 @@ type HTTPClientConfig struct {
 func MustLoad() Config {
 	return Config{
 		Application: &Application{
-			Port: getEnv("APPLICATION_PORT", 8778),
+			Port:           getEnv("APPLICATION_PORT", 8778),
+			EventsInterval: getEnv("APPLICATION_EVENTS_INTERVAL", time.Second),
 		},
 		HTTPClientConfig: &HTTPClientConfig{
 			MaxIdleConns:        getEnv("HTTP_MAX_IDLE_CONNS", 10000),
@@ -43,10 +79,31 @@ func MustLoad() Config {
 			ExpectTimeout:       getEnv("HTTP_EXPECT_TIMEOUT", 1*time.Second),
 			RequestTimeout:      getEnv("HTTP_REQUEST_TIMEOUT", 10*time.Second),
 		},
+		Runner: &Runner{
+			MaxConcurrentRuns: getEnv("RUNNER_MAX_CONCURRENT_RUNS", 0),
+			MaxTotalRPS:       getEnv("RUNNER_MAX_TOTAL_RPS", 0),
+
+			CheckpointInterval: getEnv("RUNNER_CHECKPOINT_INTERVAL", 10*time.Second),
+			ShutdownTimeout:    getEnv("RUNNER_SHUTDOWN_TIMEOUT", 15*time.Second),
+		},
+		Storage: &Storage{
+			Backend: getEnv("STORAGE_BACKEND", "memory"),
+			Path:    getEnv("STORAGE_PATH", "gnat.db"),
+
+			PostgresDSN:      getEnv("STORAGE_POSTGRES_DSN", ""),
+			PostgresMaxConns: getEnv("STORAGE_POSTGRES_MAX_CONNS", 0),
+			PostgresTimeout:  getEnv("STORAGE_POSTGRES_TIMEOUT", 5*time.Second),
+		},
+		Retention: &Retention{
+			KeepRuns:     getEnv("RETENTION_KEEP_RUNS", 0),
+			CompactAfter: getEnv("RETENTION_COMPACT_AFTER", time.Duration(0)),
+			DeleteAfter:  getEnv("RETENTION_DELETE_AFTER", time.Duration(0)),
+			Interval:     getEnv("RETENTION_INTERVAL", time.Minute),
+		},
 	}
 }
 
-func mustGetEnv[T int | bool | time.Duration](key string) T {
+func mustGetEnv[T int | bool | string | time.Duration](key string) T {
 	val := os.Getenv(key)
 	if val == "" {
 		panic("missing required environment variable: " + key)
@@ -61,7 +118,7 @@ func mustGetEnv[T int | bool | time.Duration](key string) T {
 	return result
 }
 
-func getEnv[T int | bool | time.Duration](key string, defaultVal T) T {
+func getEnv[T int | bool | string | time.Duration](key string, defaultVal T) T {
 	val := os.Getenv(key)
 	if val == "" {
 		return defaultVal
@@ -75,7 +132,7 @@ func getEnv[T int | bool | time.Duration](key string, defaultVal T) T {
 	return result
 }
 
-func parse[T int | bool | time.Duration](val string, defaultVal T) (T, error) {
+func parse[T int | bool | string | time.Duration](val string, defaultVal T) (T, error) {
 	var result any
 	var err error
 
@@ -84,6 +141,8 @@ func parse[T int | bool | time.Duration](val string, defaultVal T) (T, error) {
 		result, err = strconv.Atoi(val)
 	case bool:
 		result, err = strconv.ParseBool(val)
+	case string:
+		result = val
 	case time.Duration:
 		result, err = time.ParseDuration(val)
 	}
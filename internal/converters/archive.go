@@ -0,0 +1,138 @@
+package converters
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/server/dto"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+// ArchiveVersion is the version of the archive format. Archives of other
+// versions are refused.
+const ArchiveVersion = 1
+
+func ArchiveToDTO(m *models.Archive) (*dto.Archive, error) {
+	out := &dto.Archive{
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now(),
+		Setups:     make([]*dto.Setup, len(m.Setups)),
+		Schedules:  make([]*dto.ArchiveSchedule, len(m.Schedules)),
+	}
+
+	for i, setup := range m.Setups {
+		out.Setups[i] = SetupToDTO(setup)
+	}
+
+	for _, setup := range m.SetupVersions {
+		out.SetupVersions = append(out.SetupVersions, SetupToDTO(setup))
+	}
+
+	for i, schedule := range m.Schedules {
+		out.Schedules[i] = &dto.ArchiveSchedule{
+			ID:       schedule.ID,
+			Name:     schedule.Name,
+			SetupID:  schedule.SetupID,
+			Cron:     schedule.Cron,
+			Timezone: schedule.Timezone,
+			Overlap:  string(schedule.Overlap),
+			Enabled:  schedule.Enabled,
+		}
+		if !schedule.At.IsZero() {
+			out.Schedules[i].At = &schedule.At
+		}
+	}
+
+	for _, run := range m.Runs {
+		data, err := storage.EncodeRun(run)
+		if err != nil {
+			return nil, fmt.Errorf("run %s: %w", run.ID, err)
+		}
+		out.Runs = append(out.Runs, data)
+	}
+
+	return out, nil
+}
+
+func ArchiveFromDTO(d *dto.Archive) (*models.Archive, error) {
+	if d.Version != ArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d, want %d", d.Version, ArchiveVersion)
+	}
+
+	out := &models.Archive{}
+
+	for i, setup := range d.Setups {
+		if setup == nil || setup.ID == "" {
+			return nil, fmt.Errorf("setups[%d]: id is required", i)
+		}
+
+		m, err := SetupFromDTO(setup)
+		if err != nil {
+			return nil, fmt.Errorf("setups[%d]: %w", i, err)
+		}
+		out.Setups = append(out.Setups, m)
+	}
+
+	for i, setup := range d.SetupVersions {
+		if setup == nil || setup.ID == "" {
+			return nil, fmt.Errorf("setup_versions[%d]: id is required", i)
+		}
+
+		m, err := SetupFromDTO(setup)
+		if err != nil {
+			return nil, fmt.Errorf("setup_versions[%d]: %w", i, err)
+		}
+		out.SetupVersions = append(out.SetupVersions, m)
+	}
+
+	for i, schedule := range d.Schedules {
+		if schedule == nil || schedule.ID == "" {
+			return nil, fmt.Errorf("schedules[%d]: id is required", i)
+		}
+
+		var at time.Time
+		if schedule.At != nil {
+			at = *schedule.At
+		}
+
+		m := models.NewSchedule(schedule.Name, schedule.SetupID, at, schedule.Cron, schedule.Timezone, models.OverlapPolicy(schedule.Overlap))
+		m.ID = schedule.ID
+		m.Enabled = schedule.Enabled
+		out.Schedules = append(out.Schedules, m)
+	}
+
+	for i, data := range d.Runs {
+		run, err := storage.DecodeRun(data)
+		if err != nil {
+			return nil, fmt.Errorf("runs[%d]: %w", i, err)
+		}
+		if run.ID == "" {
+			return nil, fmt.Errorf("runs[%d]: id is required", i)
+		}
+		out.Runs = append(out.Runs, run)
+	}
+
+	return out, nil
+}
+
+func ImportReportToDTO(m *models.ImportReport) *dto.ImportReport {
+	return &dto.ImportReport{
+		Setups:    importResultsToDTO(m.Setups),
+		Schedules: importResultsToDTO(m.Schedules),
+		Runs:      importResultsToDTO(m.Runs),
+	}
+}
+
+func importResultsToDTO(m []models.ImportResult) []dto.ImportResult {
+	out := make([]dto.ImportResult, len(m))
+	for i, r := range m {
+		out[i] = dto.ImportResult{
+			ID:     r.ID,
+			NewID:  r.NewID,
+			Action: string(r.Action),
+			Error:  r.Error,
+		}
+	}
+	return out
+}
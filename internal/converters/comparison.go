@@ -0,0 +1,52 @@
+package converters
+
+import (
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/server/dto"
+)
+
+func ComparisonToDTO(m *models.Comparison) *dto.Comparison {
+	out := &dto.Comparison{
+		Base:        RunToDTO(m.Base),
+		Candidate:   RunToDTO(m.Candidate),
+		Tolerance:   m.Tolerance,
+		Alpha:       m.Alpha,
+		Verdict:     string(m.Verdict),
+		Regressions: append([]string{}, m.Regressions...),
+		Significance: dto.Significance{
+			Test:        m.Significance.Test,
+			Statistic:   m.Significance.Statistic,
+			PValue:      m.Significance.PValue,
+			Significant: m.Significance.Significant,
+		},
+		Metrics:     make([]dto.MetricDelta, len(m.Metrics)),
+		Percentiles: make([]dto.PercentileDelta, len(m.Percentiles)),
+	}
+
+	for i, d := range m.Metrics {
+		out.Metrics[i] = dto.MetricDelta{
+			Name:        d.Name,
+			Direction:   string(d.Direction),
+			Base:        d.Base,
+			Candidate:   d.Candidate,
+			Delta:       d.Delta,
+			DeltaPct:    d.DeltaPct,
+			Regression:  d.Regression,
+			Improvement: d.Improvement,
+		}
+	}
+
+	for i, d := range m.Percentiles {
+		out.Percentiles[i] = dto.PercentileDelta{
+			Percentile:  d.Percentile,
+			Base:        d.Base,
+			Candidate:   d.Candidate,
+			Delta:       d.Delta,
+			DeltaPct:    d.DeltaPct,
+			Regression:  d.Regression,
+			Improvement: d.Improvement,
+		}
+	}
+
+	return out
+}
@@ -1,7 +1,7 @@
 package converters
 
 import (
-	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/bdtfs/gnat/internal/models"
@@ -9,24 +9,57 @@ import (
 )
 
 func RunToDTO(m *models.Run) *dto.Run {
+	m.StateMu.RLock()
+	status, rps, startedAt, endedAt, runErr, runStats := m.Status, m.RPS, m.StartedAt, m.EndedAt, m.Error, m.Stats
+	m.StateMu.RUnlock()
+
 	var stats *dto.Stats
-	if m.Stats != nil {
-		stats = StatsToDTO(m.Stats, m.StartedAt, m.EndedAt)
+	if runStats != nil {
+		stats = StatsToDTO(runStats, startedAt, endedAt)
+	}
+
+	m.EventsMu.RLock()
+	var events []dto.RunEvent
+	for _, e := range m.Events {
+		events = append(events, dto.RunEvent{
+			Type:      string(e.Type),
+			Timestamp: e.Timestamp,
+			RPS:       e.RPS,
+			Message:   e.Message,
+		})
 	}
+	m.EventsMu.RUnlock()
 
 	out := &dto.Run{
-		ID:        m.ID,
-		SetupID:   m.SetupID,
-		Status:    string(m.Status),
-		StartedAt: m.StartedAt,
-		Elapsed:   time.Since(m.StartedAt).String(),
-		Error:     m.Error,
-		Stats:     stats,
+		ID:           m.ID,
+		SetupID:      m.SetupID,
+		SetupVersion: m.SetupVersion,
+		SetupName:    m.SetupName,
+		Status:       string(status),
+		RPS:          rps,
+		Priority:     m.Priority,
+		Labels:       m.Labels,
+		Notes:        m.Notes,
+		QueuedAt:     m.QueuedAt,
+		StartedAt:    startedAt,
+		Elapsed:      time.Since(startedAt).String(),
+		Error:        runErr,
+		RestartOf:    m.RestartOf,
+		Events:       events,
+		Stats:        stats,
+	}
+
+	if status == models.RunStatusPending {
+		out.Elapsed = time.Duration(0).String()
+	}
+
+	if !endedAt.IsZero() {
+		out.EndedAt = &endedAt
+		out.Elapsed = endedAt.Sub(startedAt).String()
 	}
 
-	if !m.EndedAt.IsZero() {
-		out.EndedAt = &m.EndedAt
-		out.Elapsed = m.EndedAt.Sub(m.StartedAt).String()
+	if !m.CompactedAt.IsZero() {
+		out.CompactedAt = &m.CompactedAt
 	}
 
 	return out
@@ -41,7 +74,7 @@ func StatsToDTO(m *models.Stats, startedAt, endedAt time.Time) *dto.Stats {
 	statusCodes := make(map[int]uint64, len(m.StatusCodes))
 	for code, ptr := range m.StatusCodes {
 		if ptr != nil {
-			statusCodes[code] = *ptr
+			statusCodes[code] = atomic.LoadUint64(ptr)
 		}
 	}
 	m.StatusMu.RUnlock()
@@ -50,61 +83,127 @@ func StatsToDTO(m *models.Stats, startedAt, endedAt time.Time) *dto.Stats {
 	errorsCopy := append([]string(nil), m.Errors...)
 	m.ErrorsMu.RUnlock()
 
-	m.LatenciesMu.Lock()
-	lat := append([]time.Duration(nil), m.Latencies...)
-	m.LatenciesMu.Unlock()
-
-	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
-
-	var lowest, highest float64
-	if len(lat) > 0 {
-		lowest = float64(lat[0].Milliseconds())
-		highest = float64(lat[len(lat)-1].Milliseconds())
+	m.OperationsMu.RLock()
+	var operations map[string]*dto.OperationStats
+	if len(m.Operations) > 0 {
+		operations = make(map[string]*dto.OperationStats, len(m.Operations))
+		for name, op := range m.Operations {
+			operations[name] = operationStatsToDTO(op)
+		}
 	}
-
-	var average float64
-	if len(lat) > 0 {
-		var total time.Duration
-		for _, v := range lat {
-			total += v
+	m.OperationsMu.RUnlock()
+
+	m.SourcesMu.RLock()
+	var sources map[string]*dto.SourceStats
+	if len(m.Sources) > 0 {
+		sources = make(map[string]*dto.SourceStats, len(m.Sources))
+		for ip, src := range m.Sources {
+			sources[ip] = &dto.SourceStats{Requests: src.Requests, Errors: src.Errors}
+		}
+	}
+	m.SourcesMu.RUnlock()
+
+	m.RemoteIPsMu.RLock()
+	var remoteIPs map[string]uint64
+	if len(m.RemoteIPs) > 0 {
+		remoteIPs = make(map[string]uint64, len(m.RemoteIPs))
+		for ip, ptr := range m.RemoteIPs {
+			remoteIPs[ip] = atomic.LoadUint64(ptr)
 		}
-		average = float64(total.Milliseconds()) / float64(len(lat))
 	}
+	m.RemoteIPsMu.RUnlock()
+
+	m.LatencyMu.Lock()
+	lat := m.LatencySummary
+	if lat == nil {
+		lat = models.NewLatencySummary(m.Latencies)
+	}
+	m.LatencyMu.Unlock()
+
+	total := atomic.LoadUint64(&m.TotalRequests)
+	success := atomic.LoadUint64(&m.SuccessRequests)
+	failed := atomic.LoadUint64(&m.FailedRequests)
+	attempts := atomic.LoadUint64(&m.Attempts)
 
 	elapsed := endedAt.Sub(startedAt).Seconds()
 	var rps float64
 	if elapsed > 0 {
-		rps = float64(m.TotalRequests) / elapsed
+		rps = float64(total) / elapsed
 	}
 
-	var successRate float64
-	if m.TotalRequests > 0 {
-		successRate = float64(m.SuccessRequests) / float64(m.TotalRequests)
+	m.ConnectLatencyMu.Lock()
+	connections := atomic.LoadUint64(&m.ConnectionsOpened)
+	var avgConnect float64
+	if connections > 0 {
+		avgConnect = float64(m.TotalConnectLatency.Microseconds()) / 1000 / float64(connections)
+	}
+	var avgHandshake float64
+	if handshakes := atomic.LoadUint64(&m.Handshakes); handshakes > 0 {
+		avgHandshake = float64(m.TotalHandshakeLatency.Microseconds()) / 1000 / float64(handshakes)
+	}
+	m.ConnectLatencyMu.Unlock()
+
+	completed := success + failed
+
+	var successRate, firstAttemptRate float64
+	if completed > 0 {
+		successRate = float64(success) / float64(completed)
+		firstAttemptRate = float64(atomic.LoadUint64(&m.FirstAttemptSuccess)) / float64(completed)
+	}
+
+	var retries uint64
+	if attempts > completed {
+		retries = attempts - completed
 	}
 
 	return &dto.Stats{
-		Total:       m.TotalRequests,
-		Success:     m.SuccessRequests,
-		Failed:      m.FailedRequests,
-		AvgLatency:  average,
-		MinLatency:  lowest,
-		MaxLatency:  highest,
-		P50Latency:  percentile(lat, 0.50),
-		P90Latency:  percentile(lat, 0.90),
-		P95Latency:  percentile(lat, 0.95),
-		P99Latency:  percentile(lat, 0.99),
-		SuccessRate: successRate,
-		RPS:         rps,
-		BytesRead:   m.TotalBytesRead,
-		StatusCodes: statusCodes,
-		Errors:      errorsCopy,
+		Total:             total,
+		Success:           success,
+		Failed:            failed,
+		Cancelled:         atomic.LoadUint64(&m.CancelledRequests),
+		AvgLatency:        milliseconds(lat.Mean),
+		MinLatency:        milliseconds(lat.Min),
+		MaxLatency:        milliseconds(lat.Max),
+		P50Latency:        milliseconds(lat.P50),
+		P90Latency:        milliseconds(lat.P90),
+		P95Latency:        milliseconds(lat.P95),
+		P99Latency:        milliseconds(lat.P99),
+		SuccessRate:       successRate,
+		FirstAttemptRate:  firstAttemptRate,
+		Attempts:          attempts,
+		Retries:           retries,
+		RPS:               rps,
+		BytesRead:         atomic.LoadUint64(&m.TotalBytesRead),
+		ConnectionsOpened: connections,
+		ConnectionErrors:  atomic.LoadUint64(&m.ConnectionErrors),
+		AvgConnectLatency: avgConnect,
+		AvgHandshake:      avgHandshake,
+		StatusCodes:       statusCodes,
+		Errors:            errorsCopy,
+		Operations:        operations,
+		Sources:           sources,
+		RemoteIPs:         remoteIPs,
 	}
 }
 
-func percentile(sorted []time.Duration, p float64) float64 {
-	if len(sorted) == 0 {
-		return 0
+func operationStatsToDTO(m *models.OperationStats) *dto.OperationStats {
+	out := &dto.OperationStats{
+		Total:   m.TotalRequests,
+		Success: m.SuccessRequests,
+		Failed:  m.FailedRequests,
+	}
+
+	if m.TotalRequests > 0 {
+		out.SuccessRate = float64(m.SuccessRequests) / float64(m.TotalRequests)
+	}
+
+	if m.Responses > 0 {
+		out.AvgLatency = milliseconds(m.TotalLatency) / float64(m.Responses)
 	}
-	idx := int(float64(len(sorted)-1) * p)
-	return float64(sorted[idx].Milliseconds())
+
+	return out
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
 }
@@ -0,0 +1,26 @@
+package converters
+
+import (
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/server/dto"
+)
+
+func SampleToDTO(m *models.Sample) *dto.Sample {
+	return &dto.Sample{
+		Reason:        string(m.Reason),
+		Timestamp:     m.Timestamp,
+		LatencyMs:     float64(m.Latency.Microseconds()) / 1000,
+		StatusCode:    m.StatusCode,
+		Headers:       m.Headers,
+		Body:          string(m.Body),
+		BodyTruncated: m.BodyTruncated,
+		Error:         m.Error,
+		Request: dto.SampleRequest{
+			Method:        m.Request.Method,
+			URL:           m.Request.URL,
+			Headers:       m.Request.Headers,
+			Body:          string(m.Request.Body),
+			BodyTruncated: m.Request.BodyTruncated,
+		},
+	}
+}
@@ -0,0 +1,42 @@
+package converters
+
+import (
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/server/dto"
+)
+
+func ScheduleToDTO(m *models.Schedule) *dto.Schedule {
+	out := &dto.Schedule{
+		ID:        m.ID,
+		Name:      m.Name,
+		SetupID:   m.SetupID,
+		Cron:      m.Cron,
+		Timezone:  m.Timezone,
+		Overlap:   string(m.Overlap),
+		Enabled:   m.Enabled,
+		Queued:    m.Queued,
+		LastRunID: m.LastRunID,
+		History:   make([]dto.ScheduleEntry, len(m.History)),
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+	}
+
+	if !m.At.IsZero() {
+		out.At = &m.At
+	}
+
+	if !m.NextRunAt.IsZero() && m.Enabled {
+		out.NextRunAt = &m.NextRunAt
+	}
+
+	for i, e := range m.History {
+		out.History[i] = dto.ScheduleEntry{
+			Time:    e.Time,
+			Outcome: string(e.Outcome),
+			RunID:   e.RunID,
+			Error:   e.Error,
+		}
+	}
+
+	return out
+}
@@ -1,42 +1,272 @@
 package converters
 
 import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
 	"github.com/bdtfs/gnat/internal/models"
 	"github.com/bdtfs/gnat/internal/server/dto"
 )
 
 func SetupToDTO(m *models.Setup) *dto.Setup {
 	return &dto.Setup{
-		ID:          m.ID,
-		Name:        m.Name,
-		Description: m.Description,
-		Method:      m.Method,
-		URL:         m.URL,
-		Body:        m.Body,
-		Headers:     m.Headers,
-		RPS:         m.RPS,
-		Duration:    m.Duration,
-		Status:      string(m.Status),
-		HTTPConfig:  m.HTTPConfig,
-		CreatedAt:   m.CreatedAt,
-		UpdatedAt:   m.UpdatedAt,
-	}
-}
-
-func SetupFromDTO(d *dto.Setup) *models.Setup {
+		ID:                 m.ID,
+		Version:            m.Version,
+		Name:               m.Name,
+		Description:        m.Description,
+		Executor:           m.Executor,
+		ExecutorConfig:     m.ExecutorConfig,
+		Method:             m.Method,
+		URL:                m.URL,
+		Body:               m.Body,
+		Payload:            PayloadToDTO(m.Payload),
+		Headers:            m.Headers,
+		RPS:                m.RPS,
+		Duration:           m.Duration,
+		Status:             string(m.Status),
+		HTTPConfig:         m.HTTPConfig,
+		Expect:             m.Expect,
+		Connection:         string(m.Connection),
+		MaxRequestsPerConn: m.MaxRequestsPerConn,
+		SourceAddrs:        m.SourceAddrs,
+		Resolve:            m.Resolve,
+		DNSServer:          m.DNSServer,
+		DNSCacheTTL:        m.DNSCacheTTL,
+		IPFamily:           m.IPFamily,
+		Retry:              RetryPolicyToDTO(m.Retry),
+		RestartInterrupted: m.RestartInterrupted,
+		CreatedAt:          m.CreatedAt,
+		UpdatedAt:          m.UpdatedAt,
+	}
+}
+
+func SetupFromDTO(d *dto.Setup) (*models.Setup, error) {
+	retry, err := RetryPolicyFromDTO(d.Retry)
+	if err != nil {
+		return nil, err
+	}
+
 	return &models.Setup{
-		ID:          d.ID,
-		Name:        d.Name,
-		Description: d.Description,
-		Method:      d.Method,
-		URL:         d.URL,
-		Body:        d.Body,
-		Headers:     d.Headers,
-		RPS:         d.RPS,
-		Duration:    d.Duration,
-		Status:      models.SetupStatus(d.Status),
-		HTTPConfig:  d.HTTPConfig,
-		CreatedAt:   d.CreatedAt,
-		UpdatedAt:   d.UpdatedAt,
+		ID:                 d.ID,
+		Version:            d.Version,
+		Name:               d.Name,
+		Description:        d.Description,
+		Executor:           d.Executor,
+		ExecutorConfig:     d.ExecutorConfig,
+		Method:             d.Method,
+		URL:                d.URL,
+		Body:               d.Body,
+		Payload:            PayloadFromDTO(d.Payload),
+		Headers:            d.Headers,
+		RPS:                d.RPS,
+		Duration:           d.Duration,
+		Status:             models.SetupStatus(d.Status),
+		HTTPConfig:         d.HTTPConfig,
+		Expect:             d.Expect,
+		Connection:         models.ConnectionMode(d.Connection),
+		MaxRequestsPerConn: d.MaxRequestsPerConn,
+		SourceAddrs:        d.SourceAddrs,
+		Resolve:            d.Resolve,
+		DNSServer:          d.DNSServer,
+		DNSCacheTTL:        d.DNSCacheTTL,
+		IPFamily:           d.IPFamily,
+		Retry:              retry,
+		RestartInterrupted: d.RestartInterrupted,
+		CreatedAt:          d.CreatedAt,
+		UpdatedAt:          d.UpdatedAt,
+	}, nil
+}
+
+// SetupVersionToDTO converts a version of a setup, listing its changes since
+// previous, which is nil for the first version.
+func SetupVersionToDTO(m, previous *models.Setup) *dto.SetupVersion {
+	out := &dto.SetupVersion{
+		Version:   m.Version,
+		CreatedAt: m.UpdatedAt,
+		Changes:   []dto.FieldChange{},
+		Setup:     SetupToDTO(m),
+	}
+
+	if previous != nil {
+		out.Changes = SetupChanges(previous, m)
+	}
+
+	return out
+}
+
+func SetupDiffToDTO(from, to *models.Setup) *dto.SetupDiff {
+	return &dto.SetupDiff{
+		SetupID: to.ID,
+		From:    from.Version,
+		To:      to.Version,
+		Changes: SetupChanges(from, to),
+	}
+}
+
+// SetupChanges lists the fields that differ between two versions of a setup,
+// sorted by name. Fields that do not change what runs execute, such as the
+// status and timestamps, are left out.
+func SetupChanges(from, to *models.Setup) []dto.FieldChange {
+	changes := []dto.FieldChange{}
+	diffJSON("", setupFields(from), setupFields(to), &changes)
+	return changes
+}
+
+func setupFields(m *models.Setup) map[string]any {
+	var fields map[string]any
+
+	data, _ := json.Marshal(SetupToDTO(m))
+	_ = json.Unmarshal(data, &fields)
+
+	for _, name := range []string{"id", "version", "status", "created_at", "updated_at"} {
+		delete(fields, name)
+	}
+
+	return fields
+}
+
+// diffJSON appends the differences between two decoded JSON values. Objects
+// are compared field by field, anything else as a whole.
+func diffJSON(path string, from, to any, changes *[]dto.FieldChange) {
+	fromObj, fromOK := from.(map[string]any)
+	toObj, toOK := to.(map[string]any)
+
+	if !fromOK || !toOK {
+		if !reflect.DeepEqual(from, to) {
+			*changes = append(*changes, dto.FieldChange{Field: path, From: from, To: to})
+		}
+		return
+	}
+
+	keys := slices.Collect(maps.Keys(fromObj))
+	for key := range toObj {
+		if _, ok := fromObj[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		field := key
+		if path != "" {
+			field = path + "." + key
+		}
+		diffJSON(field, fromObj[key], toObj[key], changes)
+	}
+}
+
+func PayloadToDTO(m *models.Payload) *dto.Payload {
+	if m == nil {
+		return nil
+	}
+
+	out := &dto.Payload{
+		Mode:        string(m.Mode),
+		ContentType: m.ContentType,
+		Text:        m.Text,
+		JSON:        m.JSON,
+		Fields:      m.Fields,
+		Size:        m.Size,
 	}
+
+	for _, f := range m.Files {
+		out.Files = append(out.Files, &dto.PayloadFile{
+			Field:       f.Field,
+			Filename:    f.Filename,
+			ContentType: f.ContentType,
+			Data:        f.Data,
+		})
+	}
+
+	return out
+}
+
+func PayloadFromDTO(d *dto.Payload) *models.Payload {
+	if d == nil {
+		return nil
+	}
+
+	out := &models.Payload{
+		Mode:        models.PayloadMode(d.Mode),
+		ContentType: d.ContentType,
+		Text:        d.Text,
+		JSON:        d.JSON,
+		Fields:      d.Fields,
+		Size:        d.Size,
+	}
+
+	for _, f := range d.Files {
+		if f == nil {
+			continue
+		}
+		out.Files = append(out.Files, models.PayloadFile{
+			Field:       f.Field,
+			Filename:    f.Filename,
+			ContentType: f.ContentType,
+			Data:        f.Data,
+		})
+	}
+
+	return out
+}
+
+func RetryPolicyToDTO(m *models.RetryPolicy) *dto.RetryPolicy {
+	if m == nil {
+		return nil
+	}
+
+	out := &dto.RetryPolicy{
+		MaxAttempts: m.MaxAttempts,
+		Statuses:    m.Statuses,
+		Jitter:      m.Jitter,
+	}
+
+	for _, e := range m.Errors {
+		out.Errors = append(out.Errors, string(e))
+	}
+
+	if m.Backoff > 0 {
+		out.Backoff = m.Backoff.String()
+	}
+
+	if m.MaxBackoff > 0 {
+		out.MaxBackoff = m.MaxBackoff.String()
+	}
+
+	return out
+}
+
+func RetryPolicyFromDTO(d *dto.RetryPolicy) (*models.RetryPolicy, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	out := &models.RetryPolicy{
+		MaxAttempts: d.MaxAttempts,
+		Statuses:    d.Statuses,
+		Jitter:      d.Jitter,
+	}
+
+	for _, e := range d.Errors {
+		out.Errors = append(out.Errors, models.RetryError(e))
+	}
+
+	var err error
+	if d.Backoff != "" {
+		if out.Backoff, err = time.ParseDuration(d.Backoff); err != nil {
+			return nil, fmt.Errorf("invalid retry backoff")
+		}
+	}
+
+	if d.MaxBackoff != "" {
+		if out.MaxBackoff, err = time.ParseDuration(d.MaxBackoff); err != nil {
+			return nil, fmt.Errorf("invalid retry max_backoff")
+		}
+	}
+
+	return out, nil
 }
@@ -0,0 +1,72 @@
+package converters
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+func TestSetupRoundTrip(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	setup := &models.Setup{
+		ID:             "setup-1",
+		Name:           "checkout",
+		Description:    "checkout under load",
+		Executor:       "http",
+		ExecutorConfig: map[string]interface{}{"key": "value"},
+		Method:         "POST",
+		URL:            "https://example.com/api",
+		Body:           []byte("body"),
+		Payload: &models.Payload{
+			Mode:   models.PayloadModeMultipart,
+			Fields: map[string]string{"name": "value"},
+			Files:  []models.PayloadFile{{Field: "file", Filename: "a.txt", ContentType: "text/plain", Data: []byte("a")}},
+		},
+		Headers:            map[string]string{"X-Test": "1"},
+		RPS:                100,
+		Duration:           time.Minute,
+		Status:             models.SetupStatusActive,
+		HTTPConfig:         map[string]interface{}{"timeout": "5s"},
+		Expect:             "OK",
+		Connection:         models.ConnectionModeReuse,
+		MaxRequestsPerConn: 10,
+		SourceAddrs:        []string{"10.0.0.10", "10.0.1.0/28"},
+		Resolve:            map[string]string{"example.com:443": "10.0.0.7"},
+		DNSServer:          "10.0.0.53:53",
+		DNSCacheTTL:        30 * time.Second,
+		IPFamily:           "ipv4",
+		Retry: &models.RetryPolicy{
+			MaxAttempts: 3,
+			Statuses:    []int{503},
+			Errors:      []models.RetryError{models.RetryErrorTimeout},
+			Backoff:     100 * time.Millisecond,
+			MaxBackoff:  time.Second,
+			Jitter:      0.2,
+		},
+		RestartInterrupted: true,
+		Version:            2,
+		CreatedAt:          now,
+		UpdatedAt:          now.Add(time.Hour),
+	}
+
+	// Every field is set, so a field the converters leave out shows up as a
+	// difference below.
+	v := reflect.ValueOf(setup).Elem()
+	for i := range v.NumField() {
+		if v.Field(i).IsZero() {
+			t.Fatalf("field %s is not set", v.Type().Field(i).Name)
+		}
+	}
+
+	got, err := SetupFromDTO(SetupToDTO(setup))
+	if err != nil {
+		t.Fatalf("SetupFromDTO: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, setup) {
+		t.Errorf("round trip changed the setup:\n got %+v\nwant %+v", got, setup)
+	}
+}
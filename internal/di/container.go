@@ -10,10 +10,15 @@ import (
 	"sync"
 
 	"github.com/bdtfs/gnat/internal/config"
+	"github.com/bdtfs/gnat/internal/retention"
 	"github.com/bdtfs/gnat/internal/runner"
+	"github.com/bdtfs/gnat/internal/scheduler"
 	"github.com/bdtfs/gnat/internal/server"
 	"github.com/bdtfs/gnat/internal/service"
-	repository "github.com/bdtfs/gnat/internal/storage/memory"
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/bdtfs/gnat/internal/storage/bolt"
+	"github.com/bdtfs/gnat/internal/storage/memory"
+	"github.com/bdtfs/gnat/internal/storage/postgres"
 	httpclient "github.com/bdtfs/gnat/pkg/clients/http"
 )
 
@@ -24,7 +29,7 @@ type Container struct {
 	httpClient     *http.Client
 	httpClientOnce sync.Once
 
-	repo     *repository.Repository
+	repo     storage.Repository
 	repoOnce sync.Once
 
 	collector     *runner.Collector
@@ -33,6 +38,12 @@ type Container struct {
 	runner     *runner.Runner
 	runnerOnce sync.Once
 
+	scheduler     *scheduler.Scheduler
+	schedulerOnce sync.Once
+
+	retention     *retention.Retention
+	retentionOnce sync.Once
+
 	service     *service.Service
 	serviceOnce sync.Once
 
@@ -79,9 +90,29 @@ func (c *Container) GetLogger() *slog.Logger {
 	return c.logger
 }
 
-func (c *Container) GetRepository() *repository.Repository {
+func (c *Container) GetRepository() storage.Repository {
 	c.repoOnce.Do(func() {
-		c.repo = repository.New()
+		switch backend := c.cfg.Storage.Backend; backend {
+		case "memory":
+			c.repo = memory.New()
+		case "bolt":
+			repo, err := bolt.Open(c.cfg.Storage.Path)
+			if err != nil {
+				panic("failed to open storage: " + err.Error())
+			}
+			c.repo = repo
+		case "postgres":
+			repo, err := postgres.Open(c.cfg.Storage.PostgresDSN, postgres.Options{
+				MaxConns: c.cfg.Storage.PostgresMaxConns,
+				Timeout:  c.cfg.Storage.PostgresTimeout,
+			})
+			if err != nil {
+				panic("failed to open storage: " + err.Error())
+			}
+			c.repo = repo
+		default:
+			panic("unknown storage backend: " + backend)
+		}
 	})
 	return c.repo
 }
@@ -95,14 +126,38 @@ func (c *Container) GetCollector() *runner.Collector {
 
 func (c *Container) GetRunner() *runner.Runner {
 	c.runnerOnce.Do(func() {
-		c.runner = runner.New(c.GetRepository(), c.GetLogger(), c.GetCollector())
+		limits := runner.Limits{
+			MaxConcurrentRuns: c.cfg.Runner.MaxConcurrentRuns,
+			MaxTotalRPS:       c.cfg.Runner.MaxTotalRPS,
+		}
+		c.runner = runner.New(c.GetRepository(), c.GetLogger(), c.GetCollector(), limits, c.cfg.Runner.CheckpointInterval)
 	})
 	return c.runner
 }
 
+func (c *Container) GetScheduler() *scheduler.Scheduler {
+	c.schedulerOnce.Do(func() {
+		c.scheduler = scheduler.New(c.GetRepository(), c.GetRunner(), c.GetLogger())
+	})
+	return c.scheduler
+}
+
+func (c *Container) GetRetention() *retention.Retention {
+	c.retentionOnce.Do(func() {
+		policy := retention.Policy{
+			KeepRuns:     c.cfg.Retention.KeepRuns,
+			CompactAfter: c.cfg.Retention.CompactAfter,
+			DeleteAfter:  c.cfg.Retention.DeleteAfter,
+			Interval:     c.cfg.Retention.Interval,
+		}
+		c.retention = retention.New(c.GetRepository(), c.GetLogger(), policy)
+	})
+	return c.retention
+}
+
 func (c *Container) GetService() *service.Service {
 	c.serviceOnce.Do(func() {
-		c.service = service.New(c.GetRepository(), c.GetRunner())
+		c.service = service.New(c.GetRepository(), c.GetRunner(), c.GetScheduler())
 	})
 	return c.service
 }
@@ -110,7 +165,7 @@ func (c *Container) GetService() *service.Service {
 func (c *Container) GetServer() *server.Server {
 	c.serverOnce.Do(func() {
 		addr := net.JoinHostPort("", strconv.Itoa(c.GetConfig().Application.Port))
-		c.server = server.New(addr, c.GetService(), c.GetLogger())
+		c.server = server.New(addr, c.GetService(), c.GetLogger(), c.GetConfig().Application.EventsInterval)
 	})
 	return c.server
 }
@@ -123,5 +178,18 @@ func (c *Container) GetContext() context.Context {
 	return c.ctx
 }
 
+// Shutdown stops the runs of this instance, storing their partial stats, and
+// closes the storage.
 func (c *Container) Shutdown() {
+	if c.runner != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Runner.ShutdownTimeout)
+		c.runner.Shutdown(ctx)
+		cancel()
+	}
+
+	if c.repo != nil {
+		if err := c.repo.Close(); err != nil {
+			c.GetLogger().Error("close storage failed", "error", err)
+		}
+	}
 }
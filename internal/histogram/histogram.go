@@ -0,0 +1,156 @@
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of linear buckets per power of two. Every bucket is
+// at most 1/subBuckets of its lower bound wide, so values are recorded with a
+// relative error below 1.6%.
+const (
+	subBucketBits = 6
+	subBuckets    = 1 << subBucketBits
+)
+
+// Histogram records latencies in microseconds into log-linear buckets. Its
+// size depends only on the largest value recorded, not on the number of
+// values. It is not safe for concurrent use.
+type Histogram struct {
+	Counts []uint64
+	Total  uint64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+func New() *Histogram {
+	return &Histogram{}
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	d = max(d, 0)
+
+	idx := index(uint64(d.Microseconds()))
+	if idx >= len(h.Counts) {
+		h.Counts = append(h.Counts, make([]uint64, idx+1-len(h.Counts))...)
+	}
+	h.Counts[idx]++
+
+	if h.Total == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+
+	h.Total++
+	h.Sum += d
+}
+
+func (h *Histogram) Clone() *Histogram {
+	out := *h
+	out.Counts = append([]uint64(nil), h.Counts...)
+	return &out
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Total)
+}
+
+// Quantile returns the value below which the fraction q of the recorded values
+// fall, using the midpoint of the bucket it lands in. The result is clamped to
+// the exact minimum and maximum.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.Total)))
+	rank = min(max(rank, 1), h.Total)
+
+	var seen uint64
+	for idx, n := range h.Counts {
+		seen += n
+		if seen >= rank {
+			lo, hi := bounds(idx)
+			mid := time.Duration((lo+hi)/2) * time.Microsecond
+			return min(max(mid, h.Min), h.Max)
+		}
+	}
+
+	return h.Max
+}
+
+// KolmogorovSmirnov runs a two-sample Kolmogorov-Smirnov test on the bucketed
+// distributions of a and b. It returns the largest distance between their
+// cumulative distributions and the asymptotic p-value of the hypothesis that
+// both were drawn from the same distribution.
+func KolmogorovSmirnov(a, b *Histogram) (statistic, pValue float64) {
+	if a.Total == 0 || b.Total == 0 {
+		return 0, 1
+	}
+
+	var ca, cb uint64
+	for idx := range max(len(a.Counts), len(b.Counts)) {
+		if idx < len(a.Counts) {
+			ca += a.Counts[idx]
+		}
+		if idx < len(b.Counts) {
+			cb += b.Counts[idx]
+		}
+
+		d := math.Abs(float64(ca)/float64(a.Total) - float64(cb)/float64(b.Total))
+		statistic = max(statistic, d)
+	}
+
+	n := float64(a.Total) * float64(b.Total) / float64(a.Total+b.Total)
+	sqrtN := math.Sqrt(n)
+	lambda := (sqrtN + 0.12 + 0.11/sqrtN) * statistic
+
+	return statistic, kolmogorovQ(lambda)
+}
+
+// kolmogorovQ is the survival function of the Kolmogorov distribution.
+func kolmogorovQ(lambda float64) float64 {
+	if lambda < 0.2 {
+		return 1
+	}
+
+	var sum float64
+	sign := 1.0
+	for k := 1; k <= 100; k++ {
+		term := sign * math.Exp(-2*float64(k*k)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-10 {
+			break
+		}
+		sign = -sign
+	}
+
+	return min(max(2*sum, 0), 1)
+}
+
+func index(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+
+	shift := bits.Len64(v) - subBucketBits - 1
+	return (shift+1)*subBuckets + int(v>>shift) - subBuckets
+}
+
+// bounds returns the range [lo, hi) of microsecond values in bucket idx.
+func bounds(idx int) (lo, hi uint64) {
+	if idx < subBuckets {
+		return uint64(idx), uint64(idx) + 1
+	}
+
+	shift := idx/subBuckets - 1
+	lo = uint64(idx%subBuckets+subBuckets) << shift
+	return lo, lo + 1<<shift
+}
@@ -0,0 +1,133 @@
+package histogram
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func record(values ...time.Duration) *Histogram {
+	h := New()
+	for _, v := range values {
+		h.Record(v)
+	}
+	return h
+}
+
+// span returns the microsecond values from lo to hi.
+func span(lo, hi int) []time.Duration {
+	var out []time.Duration
+	for v := lo; v <= hi; v++ {
+		out = append(out, time.Duration(v)*time.Microsecond)
+	}
+	return out
+}
+
+func TestQuantile(t *testing.T) {
+	var millis []time.Duration
+	for v := 1; v <= 1000; v++ {
+		millis = append(millis, time.Duration(v)*time.Millisecond)
+	}
+
+	tests := []struct {
+		name   string
+		values []time.Duration
+		q      float64
+		want   time.Duration
+		// relErr is the relative error allowed by the bucket width.
+		relErr float64
+	}{
+		{name: "empty", q: 0.5, want: 0},
+		{name: "single value", values: []time.Duration{5 * time.Millisecond}, q: 0.99, want: 5 * time.Millisecond},
+		{name: "zero quantile is the minimum", values: span(1, 50), q: 0, want: time.Microsecond},
+		{name: "median of exact buckets", values: span(1, 50), q: 0.5, want: 25 * time.Microsecond},
+		{name: "p90 of exact buckets", values: span(1, 50), q: 0.9, want: 45 * time.Microsecond},
+		{name: "full quantile is the maximum", values: span(1, 50), q: 1, want: 50 * time.Microsecond},
+		{name: "negative values count as zero", values: []time.Duration{-time.Second, 0}, q: 1, want: 0},
+		{name: "median of wide buckets", values: millis, q: 0.5, want: 500 * time.Millisecond, relErr: 0.016},
+		{name: "p99 of wide buckets", values: millis, q: 0.99, want: 990 * time.Millisecond, relErr: 0.016},
+		{name: "p99.9 clamped to the maximum", values: millis, q: 0.999, want: 999 * time.Millisecond, relErr: 0.016},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := record(tt.values...).Quantile(tt.q)
+
+			if diff := math.Abs(float64(got - tt.want)); diff > tt.relErr*float64(tt.want) {
+				t.Fatalf("Quantile(%g) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordAndClone(t *testing.T) {
+	h := record(3*time.Millisecond, time.Millisecond, 2*time.Millisecond)
+
+	if h.Total != 3 || h.Min != time.Millisecond || h.Max != 3*time.Millisecond || h.Mean() != 2*time.Millisecond {
+		t.Fatalf("histogram is %+v, want 3 values from 1ms to 3ms", h)
+	}
+
+	clone := h.Clone()
+	clone.Record(time.Second)
+
+	var counted uint64
+	for _, n := range h.Counts {
+		counted += n
+	}
+
+	if counted != 3 || h.Total != 3 || h.Max != 3*time.Millisecond {
+		t.Fatalf("recording into a clone changed the original: %+v", h)
+	}
+
+	if clone.Total != 4 || clone.Max != time.Second {
+		t.Fatalf("clone is %+v, want 4 values up to 1s", clone)
+	}
+}
+
+func TestKolmogorovSmirnov(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      *Histogram
+		statistic float64
+		pValue    float64
+	}{
+		{name: "empty", a: New(), b: record(time.Millisecond), statistic: 0, pValue: 1},
+		{name: "identical", a: record(span(1, 40)...), b: record(span(1, 40)...), statistic: 0, pValue: 1},
+		// Shifted by a quarter of the range: D = 0.25, n = 20, lambda = 1.1542.
+		{name: "shifted", a: record(span(1, 40)...), b: record(span(11, 50)...), statistic: 0.25, pValue: 0.1392522},
+		{name: "disjoint", a: record(span(1, 100)...), b: record(span(101, 200)...), statistic: 1, pValue: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statistic, pValue := KolmogorovSmirnov(tt.a, tt.b)
+
+			if math.Abs(statistic-tt.statistic) > 1e-9 {
+				t.Fatalf("statistic = %g, want %g", statistic, tt.statistic)
+			}
+			if math.Abs(pValue-tt.pValue) > 1e-6 {
+				t.Fatalf("p-value = %g, want %g", pValue, tt.pValue)
+			}
+		})
+	}
+}
+
+func TestKolmogorovQ(t *testing.T) {
+	// Survival function values of the Kolmogorov distribution.
+	tests := []struct {
+		lambda float64
+		want   float64
+	}{
+		{lambda: 0.1, want: 1},
+		{lambda: 0.5, want: 0.9639452},
+		{lambda: 1, want: 0.2699997},
+		{lambda: 1.36, want: 0.0494859},
+		{lambda: 2, want: 0.0006709},
+	}
+
+	for _, tt := range tests {
+		if got := kolmogorovQ(tt.lambda); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("kolmogorovQ(%g) = %.7f, want %.7f", tt.lambda, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	maxLabels      = 32
+	maxKeyLength   = 63
+	maxValueLength = 63
+)
+
+var (
+	keyPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	valuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+)
+
+// Validate checks that label keys and values are short identifiers that can
+// be used in selectors.
+func Validate(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+
+	for key, value := range labels {
+		if len(key) > maxKeyLength || !keyPattern.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if len(value) > maxValueLength || !valuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value %q for label %q", value, key)
+		}
+	}
+
+	return nil
+}
+
+type Operator string
+
+const (
+	OpEquals    Operator = "="
+	OpNotEquals Operator = "!="
+	OpIn        Operator = "in"
+	OpNotIn     Operator = "notin"
+	OpExists    Operator = "exists"
+	OpNotExists Operator = "!exists"
+)
+
+// Requirement is a single condition of a selector. Equality operators have
+// exactly one value, existence operators none.
+type Requirement struct {
+	Key    string
+	Op     Operator
+	Values []string
+}
+
+// Selector matches label sets against a list of requirements, all of which
+// must hold. The zero Selector matches everything.
+type Selector struct {
+	requirements []Requirement
+}
+
+// Parse parses a comma separated selector such as
+// "env=staging,git_sha!=abc,team in (core,perf),ticket,!draft".
+func Parse(expr string) (Selector, error) {
+	var s Selector
+
+	parts, err := split(expr)
+	if err != nil {
+		return Selector{}, err
+	}
+
+	for _, part := range parts {
+		req, err := parseRequirement(part)
+		if err != nil {
+			return Selector{}, err
+		}
+		s.requirements = append(s.requirements, req)
+	}
+
+	return s, nil
+}
+
+func (s Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+// Requirements returns the conditions of the selector, for storage backends
+// that evaluate selectors themselves.
+func (s Selector) Requirements() []Requirement {
+	return s.requirements
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s.requirements {
+		value, ok := labels[req.Key]
+
+		var matched bool
+		switch req.Op {
+		case OpEquals:
+			matched = ok && value == req.Values[0]
+		case OpNotEquals:
+			matched = !ok || value != req.Values[0]
+		case OpIn:
+			matched = ok && slices.Contains(req.Values, value)
+		case OpNotIn:
+			matched = !ok || !slices.Contains(req.Values, value)
+		case OpExists:
+			matched = ok
+		case OpNotExists:
+			matched = !ok
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// split splits a selector on the commas that are not inside a value list.
+func split(expr string) ([]string, error) {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+
+	for i, c := range expr {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in selector")
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in selector")
+	}
+
+	parts = append(parts, expr[start:])
+
+	out := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+
+	return out, nil
+}
+
+func parseRequirement(part string) (Requirement, error) {
+	if key, ok := strings.CutPrefix(part, "!"); ok {
+		return newRequirement(strings.TrimSpace(key), OpNotExists, nil)
+	}
+
+	if key, value, ok := strings.Cut(part, "!="); ok {
+		return newRequirement(strings.TrimSpace(key), OpNotEquals, []string{strings.TrimSpace(value)})
+	}
+
+	if key, value, ok := strings.Cut(part, "=="); ok {
+		return newRequirement(strings.TrimSpace(key), OpEquals, []string{strings.TrimSpace(value)})
+	}
+
+	if key, value, ok := strings.Cut(part, "="); ok {
+		return newRequirement(strings.TrimSpace(key), OpEquals, []string{strings.TrimSpace(value)})
+	}
+
+	if fields := strings.Fields(part); len(fields) >= 2 {
+		var op Operator
+		switch {
+		case fields[1] == "in" || strings.HasPrefix(fields[1], "in("):
+			op = OpIn
+		case fields[1] == "notin" || strings.HasPrefix(fields[1], "notin("):
+			op = OpNotIn
+		default:
+			return Requirement{}, fmt.Errorf("invalid selector %q", part)
+		}
+
+		rest := strings.TrimSpace(strings.TrimPrefix(part, fields[0]))
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(rest, "notin"), "in"))
+		if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+			return Requirement{}, fmt.Errorf("invalid value list in selector %q", part)
+		}
+
+		var values []string
+		for _, v := range strings.Split(rest[1:len(rest)-1], ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+
+		return newRequirement(fields[0], op, values)
+	}
+
+	return newRequirement(part, OpExists, nil)
+}
+
+func newRequirement(key string, op Operator, values []string) (Requirement, error) {
+	if len(key) > maxKeyLength || !keyPattern.MatchString(key) {
+		return Requirement{}, fmt.Errorf("invalid label key %q in selector", key)
+	}
+
+	for _, v := range values {
+		if len(v) > maxValueLength || !valuePattern.MatchString(v) {
+			return Requirement{}, fmt.Errorf("invalid label value %q in selector", v)
+		}
+	}
+
+	return Requirement{Key: key, Op: op, Values: values}, nil
+}
@@ -4,75 +4,354 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bdtfs/gnat/internal/histogram"
+	"github.com/bdtfs/gnat/pkg/executor"
 	"github.com/google/uuid"
 )
 
-type SetupStatus string
+// Setups and samples are defined in pkg/executor, so that executors outside
+// this module can use them.
+type (
+	Setup          = executor.Setup
+	SetupStatus    = executor.SetupStatus
+	ConnectionMode = executor.ConnectionMode
+	PayloadMode    = executor.PayloadMode
+	RetryError     = executor.RetryError
+	RetryPolicy    = executor.RetryPolicy
+	Payload        = executor.Payload
+	PayloadFile    = executor.PayloadFile
+	SampleReason   = executor.SampleReason
+	Sample         = executor.Sample
+	SampleRequest  = executor.SampleRequest
+)
 
 const (
-	SetupStatusActive   SetupStatus = "active"
-	SetupStatusInactive SetupStatus = "inactive"
+	SetupStatusActive   = executor.SetupStatusActive
+	SetupStatusInactive = executor.SetupStatusInactive
+	SetupStatusArchived = executor.SetupStatusArchived
+
+	ConnectionModeReuse      = executor.ConnectionModeReuse
+	ConnectionModePerRequest = executor.ConnectionModePerRequest
+
+	PayloadModeRaw       = executor.PayloadModeRaw
+	PayloadModeText      = executor.PayloadModeText
+	PayloadModeJSON      = executor.PayloadModeJSON
+	PayloadModeForm      = executor.PayloadModeForm
+	PayloadModeMultipart = executor.PayloadModeMultipart
+	PayloadModeFile      = executor.PayloadModeFile
+	PayloadModeRandom    = executor.PayloadModeRandom
+
+	RetryErrorConnection = executor.RetryErrorConnection
+	RetryErrorTimeout    = executor.RetryErrorTimeout
+	RetryErrorAny        = executor.RetryErrorAny
+
+	SampleReasonFailure = executor.SampleReasonFailure
+	SampleReasonSlow    = executor.SampleReasonSlow
+	SampleReasonRandom  = executor.SampleReasonRandom
 )
 
 type RunStatus string
 
 const (
-	RunStatusPending   RunStatus = "pending"
-	RunStatusRunning   RunStatus = "running"
-	RunStatusCompleted RunStatus = "completed"
-	RunStatusFailed    RunStatus = "failed"
-	RunStatusCancelled RunStatus = "cancelled"
+	RunStatusPending    RunStatus = "pending"
+	RunStatusRunning    RunStatus = "running"
+	RunStatusPaused     RunStatus = "paused"
+	RunStatusCancelling RunStatus = "cancelling"
+	RunStatusCompleted  RunStatus = "completed"
+	RunStatusFailed     RunStatus = "failed"
+	RunStatusCancelled  RunStatus = "cancelled"
+	// RunStatusInterrupted marks a run whose instance stopped before the run
+	// finished. Its stats cover what was checkpointed until then.
+	RunStatusInterrupted RunStatus = "interrupted"
 )
 
-type Setup struct {
-	ID          string
-	Name        string
-	Description string
-	Method      string
-	URL         string
-	Body        []byte
-	Headers     map[string]string
-	RPS         int
-	Duration    time.Duration
-	Status      SetupStatus
-	HTTPConfig  map[string]interface{}
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-}
+type CancelMode string
+
+const (
+	CancelModeDrain CancelMode = "drain"
+	CancelModeAbort CancelMode = "abort"
+)
+
+type RunEventType string
+
+const (
+	RunEventQueued          RunEventType = "queued"
+	RunEventStarted         RunEventType = "started"
+	RunEventPaused          RunEventType = "paused"
+	RunEventResumed         RunEventType = "resumed"
+	RunEventRateChanged     RunEventType = "rate_changed"
+	RunEventCancelRequested RunEventType = "cancel_requested"
+	RunEventFinished        RunEventType = "finished"
+)
 
 type Run struct {
-	ID        string
-	SetupID   string
-	Status    RunStatus
-	StartedAt time.Time
-	EndedAt   time.Time
-	Error     string
-	Stats     *Stats
+	ID           string
+	SetupID      string
+	SetupVersion int
+	SetupName    string
+	Status       RunStatus
+	RPS          int
+	Priority     int
+	Labels       map[string]string
+	Notes        string
+	QueuedAt     time.Time
+	StartedAt    time.Time
+	EndedAt      time.Time
+	Error        string
+	Stats        *Stats
+
+	// RestartOf is the interrupted run this run was started in place of.
+	RestartOf string
+	// CheckpointedAt is when the state of the run was last stored. While the
+	// run is unfinished, the instance executing it renews LeaseExpiresAt;
+	// once the lease has expired, any instance may mark the run interrupted.
+	CheckpointedAt time.Time
+	LeaseExpiresAt time.Time
+	// CompactedAt is when retention reduced the stats of the run to a
+	// summary.
+	CompactedAt time.Time
+
+	Events   []RunEvent
+	EventsMu sync.RWMutex
+
+	// StateMu guards the fields the runner changes while the run is active:
+	// Status, RPS, StartedAt, EndedAt, Error and Stats.
+	StateMu sync.RWMutex
+}
+
+type RunEvent struct {
+	Type      RunEventType
+	Timestamp time.Time
+	RPS       int
+	Message   string
 }
 
 type Stats struct {
-	TotalRequests   uint64
-	SuccessRequests uint64
-	FailedRequests  uint64
-	TotalBytesRead  uint64
+	TotalRequests     uint64
+	SuccessRequests   uint64
+	FailedRequests    uint64
+	CancelledRequests uint64
+	TotalBytesRead    uint64
+
+	Attempts            uint64
+	FirstAttemptSuccess uint64
 
 	StatusCodes map[int]*uint64
 	StatusMu    sync.RWMutex
 
-	Latencies   []time.Duration
-	LatenciesMu sync.Mutex
-
+	Latencies    *histogram.Histogram
 	TotalLatency time.Duration
-	LatencyMu    sync.Mutex
+	// LatencySummary holds the latency percentiles of a compacted run, whose
+	// histogram was dropped.
+	LatencySummary *LatencySummary
+	LatencyMu      sync.Mutex
+
+	ConnectionsOpened     uint64
+	ConnectionErrors      uint64
+	TotalConnectLatency   time.Duration
+	Handshakes            uint64
+	TotalHandshakeLatency time.Duration
+	ConnectLatencyMu      sync.Mutex
 
 	Errors   []string
 	ErrorsMu sync.RWMutex
+
+	Operations   map[string]*OperationStats
+	OperationsMu sync.RWMutex
+
+	Sources   map[string]*SourceStats
+	SourcesMu sync.RWMutex
+
+	RemoteIPs   map[string]*uint64
+	RemoteIPsMu sync.RWMutex
+
+	Samples   []*Sample
+	SamplesMu sync.RWMutex
+}
+
+type LatencySummary struct {
+	Mean time.Duration
+	Min  time.Duration
+	Max  time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+}
+
+type SourceStats struct {
+	Requests uint64
+	Errors   uint64
+}
+
+type OperationStats struct {
+	TotalRequests   uint64
+	SuccessRequests uint64
+	FailedRequests  uint64
+	Responses       uint64
+	TotalLatency    time.Duration
+}
+
+type OverlapPolicy string
+
+const (
+	OverlapSkip  OverlapPolicy = "skip"
+	OverlapQueue OverlapPolicy = "queue"
+	OverlapAllow OverlapPolicy = "allow"
+)
+
+type ScheduleOutcome string
+
+const (
+	ScheduleOutcomeStarted ScheduleOutcome = "started"
+	ScheduleOutcomeSkipped ScheduleOutcome = "skipped"
+	ScheduleOutcomeQueued  ScheduleOutcome = "queued"
+	ScheduleOutcomeFailed  ScheduleOutcome = "failed"
+)
+
+type Schedule struct {
+	ID        string
+	Name      string
+	SetupID   string
+	At        time.Time
+	Cron      string
+	Timezone  string
+	Overlap   OverlapPolicy
+	Enabled   bool
+	NextRunAt time.Time
+	Queued    bool
+	LastRunID string
+	History   []ScheduleEntry
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ScheduleEntry struct {
+	Time    time.Time
+	Outcome ScheduleOutcome
+	RunID   string
+	Error   string
+}
+
+type Direction string
+
+const (
+	DirectionLowerIsBetter  Direction = "lower_is_better"
+	DirectionHigherIsBetter Direction = "higher_is_better"
+	DirectionNeutral        Direction = "neutral"
+)
+
+type Verdict string
+
+const (
+	VerdictRegression  Verdict = "regression"
+	VerdictImprovement Verdict = "improvement"
+	VerdictUnchanged   Verdict = "unchanged"
+)
+
+type Comparison struct {
+	Base         *Run
+	Candidate    *Run
+	Tolerance    float64
+	Alpha        float64
+	Metrics      []MetricDelta
+	Percentiles  []PercentileDelta
+	Significance Significance
+	Regressions  []string
+	Verdict      Verdict
+}
+
+type MetricDelta struct {
+	Name        string
+	Direction   Direction
+	Base        float64
+	Candidate   float64
+	Delta       float64
+	DeltaPct    *float64
+	Regression  bool
+	Improvement bool
+}
+
+type PercentileDelta struct {
+	Percentile  float64
+	Base        float64
+	Candidate   float64
+	Delta       float64
+	DeltaPct    *float64
+	Regression  bool
+	Improvement bool
+}
+
+type Significance struct {
+	Test        string
+	Statistic   float64
+	PValue      float64
+	Significant bool
+}
+
+type ConflictStrategy string
+
+const (
+	ConflictSkip      ConflictStrategy = "skip"
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	ConflictRename    ConflictStrategy = "rename"
+)
+
+type ImportAction string
+
+const (
+	ImportCreated     ImportAction = "created"
+	ImportSkipped     ImportAction = "skipped"
+	ImportOverwritten ImportAction = "overwritten"
+	ImportRenamed     ImportAction = "renamed"
+	ImportFailed      ImportAction = "failed"
+)
+
+// Archive holds setups, schedules and runs moved between instances.
+// SetupVersions holds the versions of the setups before their current one,
+// so that runs keep the version they executed.
+type Archive struct {
+	Setups        []*Setup
+	SetupVersions []*Setup
+	Schedules     []*Schedule
+	Runs          []*Run
+}
+
+type ImportReport struct {
+	Setups    []ImportResult
+	Schedules []ImportResult
+	Runs      []ImportResult
+}
+
+type ImportResult struct {
+	ID string
+	// NewID is the ID a renamed item was imported as.
+	NewID  string
+	Action ImportAction
+	Error  string
+}
+
+func NewSchedule(name, setupID string, at time.Time, cron, timezone string, overlap OverlapPolicy) *Schedule {
+	now := time.Now()
+	return &Schedule{
+		ID:        uuid.New().String(),
+		Name:      name,
+		SetupID:   setupID,
+		At:        at,
+		Cron:      cron,
+		Timezone:  timezone,
+		Overlap:   overlap,
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
 }
 
 func NewSetup(name, description, method, url string, body []byte, headers map[string]string, rps int, duration time.Duration) *Setup {
 	now := time.Now()
 	return &Setup{
 		ID:          uuid.New().String(),
+		Version:     1,
 		Name:        name,
 		Description: description,
 		Method:      method,
@@ -87,11 +366,26 @@ func NewSetup(name, description, method, url string, body []byte, headers map[st
 	}
 }
 
+// NewLatencySummary summarizes the latencies recorded in h.
+func NewLatencySummary(h *histogram.Histogram) *LatencySummary {
+	return &LatencySummary{
+		Mean: h.Mean(),
+		Min:  h.Min,
+		Max:  h.Max,
+		P50:  h.Quantile(0.50),
+		P90:  h.Quantile(0.90),
+		P95:  h.Quantile(0.95),
+		P99:  h.Quantile(0.99),
+	}
+}
+
 func NewRun(setupID string) *Run {
+	now := time.Now()
 	return &Run{
 		ID:        uuid.New().String(),
 		SetupID:   setupID,
 		Status:    RunStatusPending,
-		StartedAt: time.Now(),
+		QueuedAt:  now,
+		StartedAt: now,
 	}
 }
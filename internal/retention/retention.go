@@ -0,0 +1,148 @@
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/histogram"
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+const (
+	pageSize = 100
+	// maxCompactedErrors caps the distinct errors a compacted run keeps.
+	maxCompactedErrors = 20
+)
+
+// Policy selects the finished runs that are compacted or deleted. Zero fields
+// do not apply. Compacted runs keep their counters and a latency summary, but
+// drop the latency histogram, the samples and repeated errors.
+type Policy struct {
+	// KeepRuns is the number of the latest finished runs of each setup that
+	// keep their full stats; older runs are compacted.
+	KeepRuns int
+	// CompactAfter and DeleteAfter are how long after it ended a run is
+	// compacted and deleted respectively.
+	CompactAfter time.Duration
+	DeleteAfter  time.Duration
+	// Interval is how often the policy is applied.
+	Interval time.Duration
+}
+
+func (p Policy) enabled() bool {
+	return p.KeepRuns > 0 || p.CompactAfter > 0 || p.DeleteAfter > 0
+}
+
+// Retention applies a Policy to the stored runs.
+type Retention struct {
+	repo   storage.Repository
+	logger *slog.Logger
+	policy Policy
+}
+
+func New(repo storage.Repository, logger *slog.Logger, policy Policy) *Retention {
+	return &Retention{
+		repo:   repo,
+		logger: logger,
+		policy: policy,
+	}
+}
+
+// Start applies the policy every interval until ctx is done. It returns
+// immediately if the policy is empty.
+func (r *Retention) Start(ctx context.Context) {
+	if !r.policy.enabled() || r.policy.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.Enforce(now)
+		}
+	}
+}
+
+// Enforce compacts and deletes the finished runs the policy selects at now.
+// Runs are selected from their summaries; only the runs to compact are read
+// in full.
+func (r *Retention) Enforce(now time.Time) {
+	kept := make(map[string]int)
+	filter := storage.RunFilter{Status: storage.FinishedStatuses}
+	page := storage.Page{Desc: true, Limit: pageSize}
+
+	for {
+		runs, next, err := r.repo.ListRunSummaries(filter, page)
+		if err != nil {
+			r.logger.Error("list runs failed", "error", err)
+			return
+		}
+
+		for _, run := range runs {
+			kept[run.SetupID]++
+
+			switch {
+			case r.policy.DeleteAfter > 0 && now.Sub(run.EndedAt) > r.policy.DeleteAfter:
+				r.delete(run)
+			case !run.CompactedAt.IsZero():
+			case r.policy.KeepRuns > 0 && kept[run.SetupID] > r.policy.KeepRuns,
+				r.policy.CompactAfter > 0 && now.Sub(run.EndedAt) > r.policy.CompactAfter:
+				r.compact(run.ID, now)
+			}
+		}
+
+		if next == "" {
+			return
+		}
+		page.Cursor = next
+	}
+}
+
+func (r *Retention) delete(run *models.Run) {
+	if err := r.repo.DeleteRun(run.ID); err != nil {
+		r.logger.Error("delete run failed", "run_id", run.ID, "error", err)
+		return
+	}
+
+	r.logger.Info("run deleted", "run_id", run.ID, "ended_at", run.EndedAt)
+}
+
+func (r *Retention) compact(id string, now time.Time) {
+	run, err := r.repo.GetRun(id)
+	if err != nil {
+		r.logger.Error("compact run failed", "run_id", id, "error", err)
+		return
+	}
+
+	if s := run.Stats; s != nil {
+		s.LatencyMu.Lock()
+		if s.LatencySummary == nil {
+			s.LatencySummary = models.NewLatencySummary(s.Latencies)
+		}
+		s.Latencies = histogram.New()
+		s.LatencyMu.Unlock()
+
+		s.ErrorsMu.Lock()
+		s.Errors = storage.DistinctErrors(s.Errors, maxCompactedErrors)
+		s.ErrorsMu.Unlock()
+
+		s.SamplesMu.Lock()
+		s.Samples = nil
+		s.SamplesMu.Unlock()
+	}
+	run.CompactedAt = now
+
+	if err = r.repo.UpdateRun(run); err != nil {
+		r.logger.Error("compact run failed", "run_id", run.ID, "error", err)
+		return
+	}
+
+	r.logger.Info("run compacted", "run_id", run.ID)
+}
@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"sort"
+
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+const maxRandomPayloadSize = 64 << 20
+
+type bodyBuilder struct {
+	contentType string
+	static      []byte
+	tmpl        *payloadTemplate
+	randomSize  int64
+}
+
+// requestBody is the body of one request. A random body is not held in
+// memory: it is generated from its seed while it is sent.
+type requestBody struct {
+	data       []byte
+	randomSize int64
+	seed       [32]byte
+}
+
+func newBodyBuilder(setup *models.Setup) (*bodyBuilder, error) {
+	p := setup.Payload
+	if p == nil || p.Mode == "" || p.Mode == models.PayloadModeRaw {
+		b := &bodyBuilder{static: setup.Body}
+		if len(setup.Body) > 0 {
+			b.contentType = "application/octet-stream"
+		}
+		return b.withContentType(p), nil
+	}
+
+	b := &bodyBuilder{}
+
+	switch p.Mode {
+	case models.PayloadModeText:
+		tmpl, err := newPayloadTemplate(p.Text)
+		if err != nil {
+			return nil, fmt.Errorf("text: %w", err)
+		}
+		b.contentType = "text/plain; charset=utf-8"
+		b.tmpl = tmpl
+
+	case models.PayloadModeJSON:
+		if !json.Valid(p.JSON) {
+			return nil, fmt.Errorf("json payload is not valid json")
+		}
+		tmpl, err := newPayloadTemplate(string(p.JSON))
+		if err != nil {
+			return nil, fmt.Errorf("json: %w", err)
+		}
+		b.contentType = "application/json"
+		b.tmpl = tmpl
+
+	case models.PayloadModeForm:
+		values := url.Values{}
+		for k, v := range p.Fields {
+			values.Set(k, v)
+		}
+		b.contentType = "application/x-www-form-urlencoded"
+		b.static = []byte(values.Encode())
+
+	case models.PayloadModeMultipart:
+		body, contentType, err := buildMultipart(p)
+		if err != nil {
+			return nil, err
+		}
+		b.contentType = contentType
+		b.static = body
+
+	case models.PayloadModeFile:
+		if len(p.Files) != 1 {
+			return nil, fmt.Errorf("file payload requires exactly one file")
+		}
+		b.contentType = p.Files[0].ContentType
+		if b.contentType == "" {
+			b.contentType = "application/octet-stream"
+		}
+		b.static = p.Files[0].Data
+
+	case models.PayloadModeRandom:
+		if p.Size <= 0 || p.Size > maxRandomPayloadSize {
+			return nil, fmt.Errorf("random payload size must be between 1 and %d bytes", maxRandomPayloadSize)
+		}
+		b.contentType = "application/octet-stream"
+		b.randomSize = int64(p.Size)
+
+	default:
+		return nil, fmt.Errorf("unknown payload mode %q", p.Mode)
+	}
+
+	return b.withContentType(p), nil
+}
+
+func (b *bodyBuilder) withContentType(p *models.Payload) *bodyBuilder {
+	if p != nil && p.ContentType != "" {
+		b.contentType = p.ContentType
+	}
+	return b
+}
+
+func (b *bodyBuilder) build() (*requestBody, error) {
+	if b.randomSize > 0 {
+		body := &requestBody{randomSize: b.randomSize}
+		_, _ = crand.Read(body.seed[:])
+		return body, nil
+	}
+
+	if b.tmpl == nil {
+		return &requestBody{data: b.static}, nil
+	}
+
+	data, err := b.tmpl.render()
+	if err != nil {
+		return nil, err
+	}
+
+	return &requestBody{data: data}, nil
+}
+
+func (b *requestBody) size() int64 {
+	if b.randomSize > 0 {
+		return b.randomSize
+	}
+	return int64(len(b.data))
+}
+
+func (b *requestBody) reader() io.Reader {
+	if b.randomSize > 0 {
+		return io.LimitReader(rand.NewChaCha8(b.seed), b.randomSize)
+	}
+	return bytes.NewReader(b.data)
+}
+
+// head returns the start of the body, one byte longer than a sample keeps so
+// that a truncated body is reported as such.
+func (b *requestBody) head() []byte {
+	if b.randomSize == 0 {
+		return b.data
+	}
+
+	data := make([]byte, min(b.randomSize, sampleMaxBody+1))
+	_, _ = rand.NewChaCha8(b.seed).Read(data)
+	return data
+}
+
+func buildMultipart(p *models.Payload) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	keys := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := w.WriteField(k, p.Fields[k]); err != nil {
+			return nil, "", fmt.Errorf("write field %s: %w", k, err)
+		}
+	}
+
+	for _, f := range p.Files {
+		if f.Field == "" {
+			return nil, "", fmt.Errorf("multipart file part requires a field name")
+		}
+
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", multipart.FileContentDisposition(f.Field, f.Filename))
+		h.Set("Content-Type", contentType)
+
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", fmt.Errorf("create part %s: %w", f.Field, err)
+		}
+
+		if _, err = part.Write(f.Data); err != nil {
+			return nil, "", fmt.Errorf("write part %s: %w", f.Field, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", fmt.Errorf("close multipart: %w", err)
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
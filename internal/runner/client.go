@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/bdtfs/gnat/internal/models"
+	httpclient "github.com/bdtfs/gnat/pkg/clients/http"
+)
+
+func clientConfig(setup *models.Setup) (*httpclient.Config, error) {
+	localAddrs, err := httpclient.ParseLocalAddrs(setup.SourceAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve, err := httpclient.ParseResolve(setup.Resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	dnsServer, err := httpclient.ParseDNSServer(setup.DNSServer)
+	if err != nil {
+		return nil, err
+	}
+
+	switch setup.IPFamily {
+	case "", httpclient.IPFamilyAny, httpclient.IPFamilyIPv4, httpclient.IPFamilyIPv6:
+	default:
+		return nil, fmt.Errorf("unknown ip family %q", setup.IPFamily)
+	}
+
+	if setup.DNSCacheTTL < 0 {
+		return nil, fmt.Errorf("dns cache ttl must not be negative")
+	}
+
+	cfg := httpclient.DefaultConfig()
+	cfg.DisableKeepAlives = setup.Connection == models.ConnectionModePerRequest
+	cfg.MaxRequestsPerConn = setup.MaxRequestsPerConn
+	cfg.LocalAddrs = localAddrs
+	cfg.Resolve = resolve
+	cfg.DNSServer = dnsServer
+	cfg.DNSCacheTTL = setup.DNSCacheTTL
+	cfg.IPFamily = setup.IPFamily
+
+	return cfg, nil
+}
+
+func newHTTPClient(setup *models.Setup) (*http.Client, error) {
+	cfg, err := clientConfig(setup)
+	if err != nil {
+		return nil, err
+	}
+	return httpclient.WithConfig(cfg), nil
+}
+
+func validateClientConfig(setup *models.Setup) error {
+	cfg, err := clientConfig(setup)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.LocalAddrs) == 0 {
+		return nil
+	}
+
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("list interface addresses: %w", err)
+	}
+
+	local := make(map[string]struct{}, len(ifaceAddrs))
+	for _, addr := range ifaceAddrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			local[ipNet.IP.String()] = struct{}{}
+		}
+	}
+
+	for _, ip := range cfg.LocalAddrs {
+		if _, ok := local[ip.String()]; !ok && !ip.IsLoopback() {
+			return fmt.Errorf("source address %s is not assigned to this host", ip)
+		}
+	}
+
+	return nil
+}
+
+func sourceIP(conn net.Conn, err error) string {
+	if conn != nil {
+		return addrIP(conn.LocalAddr())
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return addrIP(opErr.Source)
+	}
+
+	return ""
+}
+
+func remoteIP(conn net.Conn, err error) string {
+	if conn != nil {
+		return addrIP(conn.RemoteAddr())
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return addrIP(opErr.Addr)
+	}
+
+	return ""
+}
+
+func addrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
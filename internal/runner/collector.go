@@ -1,10 +1,14 @@
 package runner
 
 import (
+	"context"
+	"errors"
+	"slices"
 	"sync"
 	"sync/atomic"
 
 	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
 )
 
 type Collector struct {
@@ -18,63 +22,203 @@ func NewCollector() *Collector {
 	}
 }
 
-func (c *Collector) StartRunStatsProcessing(run *models.Run) chan<- *Result {
+// StartRunStatsProcessing attaches fresh stats to run and returns the channel
+// results are sent to. The second channel is closed once the result channel
+// has been closed and every result on it has been processed.
+func (c *Collector) StartRunStatsProcessing(run *models.Run) (chan<- *Result, <-chan struct{}) {
 	stats := NewStats()
+	run.StateMu.Lock()
 	run.Stats = stats
+	run.StateMu.Unlock()
 
 	c.mu.Lock()
 	c.runs[run.ID] = stats
 	c.mu.Unlock()
 
 	ch := make(chan *Result, 100)
+	done := make(chan struct{})
 
 	go func() {
+		defer close(done)
 		for r := range ch {
 			c.ProcessOneResult(stats, r)
 		}
 	}()
 
-	return ch
+	return ch, done
 }
 
 func (c *Collector) ProcessOneResult(s *models.Stats, r *Result) {
 	atomic.AddUint64(&s.TotalRequests, 1)
 
-	if r.Error != nil {
-		atomic.AddUint64(&s.FailedRequests, 1)
-		s.ErrorsMu.Lock()
-		s.Errors = append(s.Errors, r.Error.Error())
-		s.ErrorsMu.Unlock()
+	if errors.Is(r.Error, context.Canceled) {
+		atomic.AddUint64(&s.CancelledRequests, 1)
 		return
 	}
 
-	atomic.AddUint64(&s.TotalBytesRead, uint64(r.BytesRead))
+	if r.ConnectLatency > 0 {
+		atomic.AddUint64(&s.ConnectionsOpened, 1)
+		s.ConnectLatencyMu.Lock()
+		s.TotalConnectLatency += r.ConnectLatency
+		s.ConnectLatencyMu.Unlock()
+	}
 
-	s.StatusMu.Lock()
-	ptr, ok := s.StatusCodes[r.StatusCode]
-	if !ok {
-		var n uint64
-		ptr = &n
-		s.StatusCodes[r.StatusCode] = ptr
+	if r.HandshakeLatency > 0 {
+		atomic.AddUint64(&s.Handshakes, 1)
+		s.ConnectLatencyMu.Lock()
+		s.TotalHandshakeLatency += r.HandshakeLatency
+		s.ConnectLatencyMu.Unlock()
+	}
+
+	if r.ConnError {
+		atomic.AddUint64(&s.ConnectionErrors, 1)
 	}
-	s.StatusMu.Unlock()
-	atomic.AddUint64(ptr, 1)
 
-	if r.StatusCode >= 200 && r.StatusCode < 400 {
+	success := r.Error == nil && (r.StatusCode == 0 || (r.StatusCode >= 200 && r.StatusCode < 400))
+	responded := r.Error == nil || r.StatusCode != 0
+
+	attempts := uint64(max(r.Attempts, 1))
+	atomic.AddUint64(&s.Attempts, attempts)
+
+	if success {
 		atomic.AddUint64(&s.SuccessRequests, 1)
+		if attempts == 1 {
+			atomic.AddUint64(&s.FirstAttemptSuccess, 1)
+		}
 	} else {
 		atomic.AddUint64(&s.FailedRequests, 1)
 	}
 
-	s.LatenciesMu.Lock()
-	s.Latencies = append(s.Latencies, r.Latency)
-	s.LatenciesMu.Unlock()
+	if r.Error != nil {
+		c.processError(s, r.Error.Error())
+	}
+
+	if r.Operation != "" {
+		c.processOperation(s, r, success, responded)
+	}
+
+	if r.SourceIP != "" {
+		c.processSource(s, r)
+	}
+
+	if r.Sample != nil {
+		c.processSample(s, r.Sample)
+	}
+
+	if r.RemoteIP != "" {
+		s.RemoteIPsMu.Lock()
+		ptr, ok := s.RemoteIPs[r.RemoteIP]
+		if !ok {
+			var n uint64
+			ptr = &n
+			s.RemoteIPs[r.RemoteIP] = ptr
+		}
+		s.RemoteIPsMu.Unlock()
+		atomic.AddUint64(ptr, 1)
+	}
+
+	if !responded {
+		return
+	}
+
+	atomic.AddUint64(&s.TotalBytesRead, uint64(r.BytesRead))
+
+	if r.StatusCode != 0 {
+		s.StatusMu.Lock()
+		ptr, ok := s.StatusCodes[r.StatusCode]
+		if !ok {
+			var n uint64
+			ptr = &n
+			s.StatusCodes[r.StatusCode] = ptr
+		}
+		s.StatusMu.Unlock()
+		atomic.AddUint64(ptr, 1)
+	}
 
 	s.LatencyMu.Lock()
+	s.Latencies.Record(r.Latency)
 	s.TotalLatency += r.Latency
 	s.LatencyMu.Unlock()
 }
 
+// processError keeps the first distinct errors of a run, as many as are
+// stored with it. A failing run repeats the same few errors, so keeping every
+// occurrence would grow with the number of requests.
+func (c *Collector) processError(s *models.Stats, msg string) {
+	s.ErrorsMu.Lock()
+	defer s.ErrorsMu.Unlock()
+
+	if len(s.Errors) < storage.MaxStoredErrors && !slices.Contains(s.Errors, msg) {
+		s.Errors = append(s.Errors, msg)
+	}
+}
+
+func (c *Collector) processOperation(s *models.Stats, r *Result, success, responded bool) {
+	s.OperationsMu.Lock()
+	defer s.OperationsMu.Unlock()
+
+	op, ok := s.Operations[r.Operation]
+	if !ok {
+		op = &models.OperationStats{}
+		s.Operations[r.Operation] = op
+	}
+
+	op.TotalRequests++
+	if success {
+		op.SuccessRequests++
+	} else {
+		op.FailedRequests++
+	}
+
+	if responded {
+		op.Responses++
+		op.TotalLatency += r.Latency
+	}
+}
+
+func (c *Collector) processSource(s *models.Stats, r *Result) {
+	s.SourcesMu.Lock()
+	defer s.SourcesMu.Unlock()
+
+	src, ok := s.Sources[r.SourceIP]
+	if !ok {
+		src = &models.SourceStats{}
+		s.Sources[r.SourceIP] = src
+	}
+
+	src.Requests++
+	if r.Error != nil {
+		src.Errors++
+	}
+}
+
+// processSample stores a sample, evicting the fastest slow sample once more
+// than sampleSlowest of them are held.
+func (c *Collector) processSample(s *models.Stats, sample *models.Sample) {
+	s.SamplesMu.Lock()
+	defer s.SamplesMu.Unlock()
+
+	s.Samples = append(s.Samples, sample)
+	if sample.Reason != models.SampleReasonSlow {
+		return
+	}
+
+	fastest, slow := -1, 0
+	for i, smp := range s.Samples {
+		if smp.Reason != models.SampleReasonSlow {
+			continue
+		}
+		slow++
+		if fastest < 0 || smp.Latency < s.Samples[fastest].Latency {
+			fastest = i
+		}
+	}
+
+	if slow > sampleSlowest {
+		s.Samples = append(s.Samples[:fastest], s.Samples[fastest+1:]...)
+	}
+}
+
 func (c *Collector) GetStats(runID string) *models.Stats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/pkg/executor"
+)
+
+type (
+	Executor = executor.Executor
+	Result   = executor.Result
+)
+
+const defaultExecutor = "http"
+
+func ExecutorName(setup *models.Setup) string {
+	if setup.Executor != "" {
+		return setup.Executor
+	}
+
+	if u, err := url.Parse(setup.URL); err == nil {
+		if _, ok := executor.Lookup(u.Scheme); ok {
+			return u.Scheme
+		}
+	}
+
+	return defaultExecutor
+}
+
+func ValidateSetup(setup *models.Setup) error {
+	if err := validateClientConfig(setup); err != nil {
+		return err
+	}
+
+	if err := validateRetryPolicy(setup.Retry); err != nil {
+		return err
+	}
+
+	name := ExecutorName(setup)
+
+	def, ok := executor.Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown executor %q", name)
+	}
+
+	if def.Validate == nil {
+		return nil
+	}
+
+	if err := def.Validate(setup); err != nil {
+		return fmt.Errorf("%s executor: %w", name, err)
+	}
+
+	return nil
+}
+
+// validateHTTPURL checks the url of an executor that sends HTTP requests.
+func validateHTTPURL(setup *models.Setup) error {
+	if setup.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+
+	u, err := url.Parse(setup.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be in the form http(s)://host/path")
+	}
+
+	return nil
+}
+
+func newExecutor(setup *models.Setup) (Executor, error) {
+	name := ExecutorName(setup)
+
+	def, ok := executor.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown executor %q", name)
+	}
+
+	if setup.Retry != nil {
+		return newRetryExecutor(def.New(), setup.Retry), nil
+	}
+
+	return def.New(), nil
+}
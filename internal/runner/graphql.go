@@ -0,0 +1,263 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/pkg/executor"
+)
+
+const anonymousOperation = "anonymous"
+
+var operationNameRe = regexp.MustCompile(`^\s*(query|mutation|subscription)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+func init() {
+	executor.Register("graphql", executor.Definition{
+		New:      func() Executor { return &graphqlExecutor{} },
+		Validate: validateGraphQLSetup,
+	})
+}
+
+type graphqlConfig struct {
+	Query         string `json:"query"`
+	Variables     any    `json:"variables"`
+	OperationName string `json:"operation_name"`
+}
+
+type graphqlExecutor struct {
+	client        *http.Client
+	trackSource   bool
+	url           string
+	headers       map[string]string
+	query         string
+	operationName string
+	operation     string
+	variables     *payloadTemplate
+	sampler       *sampler
+}
+
+type graphqlResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func validateGraphQLSetup(setup *models.Setup) error {
+	_, err := newGraphQLExecutor(setup)
+	return err
+}
+
+func newGraphQLExecutor(setup *models.Setup) (*graphqlExecutor, error) {
+	if err := validateHTTPURL(setup); err != nil {
+		return nil, err
+	}
+
+	var cfg graphqlConfig
+	if err := executor.DecodeConfig(setup, &cfg); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(cfg.Query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	operation := cfg.OperationName
+	if m := operationNameRe.FindStringSubmatch(cfg.Query); m != nil {
+		if m[1] == "subscription" {
+			return nil, fmt.Errorf("subscriptions are not supported")
+		}
+		if operation == "" {
+			operation = m[2]
+		}
+	}
+
+	e := &graphqlExecutor{
+		trackSource:   len(setup.SourceAddrs) > 0,
+		url:           setup.URL,
+		headers:       setup.Headers,
+		query:         cfg.Query,
+		operationName: cfg.OperationName,
+	}
+
+	variables, err := graphqlVariablesTemplate(cfg.Variables)
+	if err != nil {
+		return nil, err
+	}
+	e.variables = variables
+
+	if _, err = e.body(); err != nil {
+		return nil, err
+	}
+
+	if operation == "" {
+		operation = anonymousOperation
+	}
+	e.operation = operation
+
+	return e, nil
+}
+
+func graphqlVariablesTemplate(v any) (*payloadTemplate, error) {
+	var text string
+	switch val := v.(type) {
+	case nil:
+		text = "{}"
+	case string:
+		text = val
+	default:
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("encode variables: %w", err)
+		}
+		text = string(raw)
+	}
+
+	tmpl, err := newPayloadTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("variables: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+func (e *graphqlExecutor) Prepare(_ context.Context, setup *models.Setup) error {
+	prepared, err := newGraphQLExecutor(setup)
+	if err != nil {
+		return err
+	}
+
+	client, err := newHTTPClient(setup)
+	if err != nil {
+		return err
+	}
+
+	*e = *prepared
+	e.client = client
+	e.sampler = newSampler()
+	return nil
+}
+
+func (e *graphqlExecutor) Execute(ctx context.Context) *Result {
+	trace := &connTrace{trackSource: e.trackSource}
+	return trace.apply(e.execute(trace.context(ctx)))
+}
+
+func (e *graphqlExecutor) execute(ctx context.Context) *Result {
+	res := &Result{Timestamp: time.Now(), Operation: e.operation}
+
+	body, err := e.body()
+	if err != nil {
+		res.Error = err
+		return res
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		res.Error = fmt.Errorf("create request: %w", err)
+		return res
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := e.client.Do(req)
+	if err != nil {
+		res.Latency = time.Since(start)
+		res.Error = fmt.Errorf("do request: %w", err)
+		if reason, ok := e.sampler.reason(true, res.Latency); ok {
+			res.Sample = httpSample(reason, res, req, body)
+		}
+		return res
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil && res.Error == nil {
+			res.Error = fmt.Errorf("close body: %w", err)
+		}
+	}()
+
+	res.StatusCode = resp.StatusCode
+	res.RetryAfter = retryAfter(resp)
+
+	data, err := io.ReadAll(resp.Body)
+	res.Latency = time.Since(start)
+	res.BytesRead = int64(len(data))
+
+	if err != nil {
+		res.Error = fmt.Errorf("read body: %w", err)
+	} else {
+		res.Error = graphqlError(data)
+	}
+
+	failed := res.Error != nil || failedStatus(res.StatusCode)
+	if reason, ok := e.sampler.reason(failed, res.Latency); ok {
+		res.Sample = httpSample(reason, res, req, body)
+		res.Sample.Headers = sampleHeader(resp.Header)
+		res.Sample.Body, res.Sample.BodyTruncated = truncateSample(data)
+	}
+
+	return res
+}
+
+func (e *graphqlExecutor) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func (e *graphqlExecutor) body() ([]byte, error) {
+	variables, err := e.variables.render()
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(variables) {
+		return nil, fmt.Errorf("variables must render to valid json")
+	}
+
+	payload := struct {
+		Query         string          `json:"query"`
+		OperationName string          `json:"operationName,omitempty"`
+		Variables     json.RawMessage `json:"variables"`
+	}{
+		Query:         e.query,
+		OperationName: e.operationName,
+		Variables:     variables,
+	}
+
+	return json.Marshal(payload)
+}
+
+func graphqlError(data []byte) error {
+	var resp graphqlResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+
+	if len(resp.Errors) == 0 {
+		return nil
+	}
+
+	msg := resp.Errors[0].Message
+	if msg == "" {
+		msg = "unknown error"
+	}
+
+	if len(resp.Errors) > 1 {
+		return fmt.Errorf("graphql: %s (and %d more)", msg, len(resp.Errors)-1)
+	}
+
+	return errors.New("graphql: " + msg)
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// pacer releases iterations at the run's current rate until the run has been
+// active for its full duration. Time spent paused does not count towards the
+// duration. The rate can be changed and the pacer paused while next blocks.
+type pacer struct {
+	mu       sync.Mutex
+	rps      int
+	paused   bool
+	duration time.Duration
+	used     time.Duration
+	start    time.Time
+	sent     int
+	wake     chan struct{}
+}
+
+func newPacer(rps int, duration time.Duration) *pacer {
+	return &pacer{
+		rps:      rps,
+		duration: duration,
+		start:    time.Now(),
+		wake:     make(chan struct{}),
+	}
+}
+
+// next blocks until the next iteration is due. It returns false once the
+// duration is used up or ctx is done.
+func (p *pacer) next(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		wake := p.wake
+
+		if p.paused {
+			p.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return false
+			case <-wake:
+				continue
+			}
+		}
+
+		offset := time.Duration(p.sent) * time.Second / time.Duration(p.rps)
+		if p.used+offset >= p.duration {
+			p.mu.Unlock()
+			return false
+		}
+		due := p.start.Add(offset)
+		p.mu.Unlock()
+
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return false
+			case <-wake:
+				timer.Stop()
+				continue
+			case <-timer.C:
+			}
+		}
+
+		p.mu.Lock()
+		if p.wake != wake {
+			p.mu.Unlock()
+			continue
+		}
+		p.sent++
+		p.mu.Unlock()
+
+		return true
+	}
+}
+
+func (p *pacer) pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		return fmt.Errorf("run is already paused")
+	}
+
+	p.used += time.Since(p.start)
+	p.paused = true
+	p.signal()
+
+	return nil
+}
+
+func (p *pacer) resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return fmt.Errorf("run is not paused")
+	}
+
+	p.paused = false
+	p.restart()
+	p.signal()
+
+	return nil
+}
+
+func (p *pacer) setRate(rps int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		p.used += time.Since(p.start)
+		p.restart()
+	}
+	p.rps = rps
+	p.signal()
+}
+
+func (p *pacer) restart() {
+	p.start = time.Now()
+	p.sent = 0
+}
+
+func (p *pacer) signal() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+// Limits bound the load a single instance generates. Zero means unlimited.
+type Limits struct {
+	MaxConcurrentRuns int
+	MaxTotalRPS       int
+}
+
+type StartOptions struct {
+	Priority  int
+	Labels    map[string]string
+	Notes     string
+	RestartOf string
+}
+
+type queuedRun struct {
+	root  context.Context
+	run   *models.Run
+	setup *models.Setup
+	seq   uint64
+}
+
+// QueueStatus is a snapshot of the admission queue and the capacity in use.
+type QueueStatus struct {
+	Limits      Limits
+	RunningRuns int
+	UsedRPS     int
+	Pending     []*models.Run
+}
+
+// enqueue adds a run to the admission queue, ordered by priority and then by
+// arrival, and starts whatever fits.
+func (r *Runner) enqueue(root context.Context, run *models.Run, setup *models.Setup) {
+	r.activeRunsMu.Lock()
+	defer r.activeRunsMu.Unlock()
+
+	r.queueSeq++
+	r.queue = append(r.queue, &queuedRun{root: root, run: run, setup: setup, seq: r.queueSeq})
+	slices.SortStableFunc(r.queue, func(a, b *queuedRun) int {
+		if c := cmp.Compare(b.run.Priority, a.run.Priority); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	recordEvent(run, models.RunEventQueued, run.RPS, fmt.Sprintf("priority %d", run.Priority))
+
+	r.admit()
+}
+
+// admit starts queued runs in order while they fit the limits. A run at the
+// head that does not fit blocks the ones behind it, so large runs are not
+// starved by small ones. It must be called with activeRunsMu held.
+func (r *Runner) admit() {
+	for !r.stopped && len(r.queue) > 0 {
+		next := r.queue[0]
+
+		if r.limits.MaxConcurrentRuns > 0 && len(r.activeRuns) >= r.limits.MaxConcurrentRuns {
+			return
+		}
+
+		if r.limits.MaxTotalRPS > 0 && r.usedRPS()+next.setup.RPS > r.limits.MaxTotalRPS {
+			return
+		}
+
+		r.queue = r.queue[1:]
+		r.launch(next.root, next.run, next.setup)
+	}
+}
+
+// dequeue removes a pending run from the queue and marks it cancelled. It
+// reports whether the run was queued.
+func (r *Runner) dequeue(runID string) bool {
+	r.activeRunsMu.Lock()
+	defer r.activeRunsMu.Unlock()
+
+	i := slices.IndexFunc(r.queue, func(q *queuedRun) bool { return q.run.ID == runID })
+	if i < 0 {
+		return false
+	}
+
+	run := r.queue[i].run
+	r.queue = slices.Delete(r.queue, i, i+1)
+
+	run.StateMu.Lock()
+	run.Status = models.RunStatusCancelled
+	run.EndedAt = time.Now()
+	run.StateMu.Unlock()
+	recordEvent(run, models.RunEventFinished, run.RPS, string(run.Status))
+
+	if err := r.repo.UpdateRun(run); err != nil {
+		r.logger.Error("update run failed", "run_id", run.ID, "error", err)
+	}
+
+	r.logger.Info("queued run cancelled", "run_id", run.ID)
+
+	r.admit()
+	return true
+}
+
+// reserveRPS checks that changing an active run to rps stays within the
+// budget and records the new rate. Lowering a rate may admit queued runs.
+func (r *Runner) reserveRPS(active *activeRun, rps int) error {
+	r.activeRunsMu.Lock()
+	defer r.activeRunsMu.Unlock()
+
+	if r.limits.MaxTotalRPS > 0 {
+		used := r.usedRPS() - active.rps + rps
+		if used > r.limits.MaxTotalRPS {
+			return fmt.Errorf("rps budget exceeded: %d of %d would be in use", used, r.limits.MaxTotalRPS)
+		}
+	}
+
+	active.rps = rps
+	r.admit()
+
+	return nil
+}
+
+func (r *Runner) usedRPS() int {
+	var used int
+	for _, active := range r.activeRuns {
+		used += active.rps
+	}
+	return used
+}
+
+func (r *Runner) GetQueue() QueueStatus {
+	r.activeRunsMu.RLock()
+	defer r.activeRunsMu.RUnlock()
+
+	pending := make([]*models.Run, len(r.queue))
+	for i, q := range r.queue {
+		pending[i] = q.run
+	}
+
+	return QueueStatus{
+		Limits:      r.limits,
+		RunningRuns: len(r.activeRuns),
+		UsedRPS:     r.usedRPS(),
+		Pending:     pending,
+	}
+}
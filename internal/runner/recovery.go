@@ -0,0 +1,232 @@
+package runner
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+const (
+	defaultCheckpointInterval = 10 * time.Second
+	// leaseIntervals is how many checkpoints a run may miss before other
+	// instances consider it interrupted.
+	leaseIntervals = 3
+)
+
+// Start reconciles runs that were left unfinished by a stopped instance, then
+// checkpoints the runs of this instance at every interval until ctx is done.
+// Only a repository shared with other instances can gain runs left behind
+// after startup, so only then is it reconciled again at every interval.
+func (r *Runner) Start(ctx context.Context) {
+	r.reconcile(ctx)
+	shared := storage.Shared(r.repo)
+
+	ticker := time.NewTicker(r.checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.checkpoint()
+			if shared {
+				r.reconcile(ctx)
+			}
+		}
+	}
+}
+
+// Shutdown stops every queued and executing run and stores its partial stats
+// with an expired lease, so that the next instance to reconcile marks it
+// interrupted. Runs that are being cancelled finish as cancelled. Shutdown
+// waits for executing runs until ctx is done.
+func (r *Runner) Shutdown(ctx context.Context) {
+	r.activeRunsMu.Lock()
+	r.stopped = true
+	queue := r.queue
+	r.queue = nil
+	actives := make([]*activeRun, 0, len(r.activeRuns))
+	for _, active := range r.activeRuns {
+		actives = append(actives, active)
+	}
+	r.activeRunsMu.Unlock()
+
+	for _, q := range queue {
+		r.release(q.run)
+	}
+
+	for _, active := range actives {
+		active.mu.Lock()
+		if !active.done {
+			active.interrupted = !active.cancelling
+			active.cancel()
+			active.abort()
+		}
+		active.mu.Unlock()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		r.executing.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		r.logger.Info("runner stopped", "queued_runs", len(queue), "active_runs", len(actives))
+	case <-ctx.Done():
+		r.logger.Error("runner stop timed out, some runs keep their last checkpoint", "error", ctx.Err())
+	}
+}
+
+// checkpoint stores the state of every run of this instance and renews its
+// lease. Runs are stored under their locks, so a checkpoint never overwrites
+// the final state of a run.
+func (r *Runner) checkpoint() {
+	r.activeRunsMu.RLock()
+	actives := make([]*activeRun, 0, len(r.activeRuns))
+	for _, active := range r.activeRuns {
+		actives = append(actives, active)
+	}
+	r.activeRunsMu.RUnlock()
+
+	for _, active := range actives {
+		active.mu.Lock()
+		if !active.done {
+			r.store(active.run)
+		}
+		active.mu.Unlock()
+	}
+
+	r.activeRunsMu.Lock()
+	for _, q := range r.queue {
+		r.store(q.run)
+	}
+	r.activeRunsMu.Unlock()
+}
+
+func (r *Runner) store(run *models.Run) {
+	now := time.Now()
+	run.CheckpointedAt = now
+	run.LeaseExpiresAt = now.Add(r.lease())
+
+	if err := r.repo.UpdateRun(run); err != nil {
+		r.logger.Error("checkpoint run failed", "run_id", run.ID, "error", err)
+	}
+}
+
+// release stores a run that this instance stops executing without finishing
+// it.
+func (r *Runner) release(run *models.Run) {
+	now := time.Now()
+	run.CheckpointedAt = now
+	run.LeaseExpiresAt = now
+
+	if err := r.repo.UpdateRun(run); err != nil {
+		r.logger.Error("update run failed", "run_id", run.ID, "error", err)
+	}
+
+	r.logger.Info("run released", "run_id", run.ID, "status", run.Status)
+}
+
+// reconcile marks unfinished runs whose lease has expired as interrupted, or
+// as cancelled if they were being cancelled, and restarts interrupted runs
+// whose setup allows it.
+func (r *Runner) reconcile(ctx context.Context) {
+	runs, _, err := r.repo.ListRuns(storage.RunFilter{Status: storage.UnfinishedStatuses}, storage.Page{})
+	if err != nil {
+		r.logger.Error("list runs failed", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, run := range runs {
+		// Runs of this instance are checked first, as they are still being
+		// changed.
+		if r.owns(run.ID) || !storage.Expired(run, now) {
+			continue
+		}
+
+		r.interrupt(ctx, run.ID, now)
+	}
+}
+
+func (r *Runner) owns(runID string) bool {
+	r.activeRunsMu.RLock()
+	defer r.activeRunsMu.RUnlock()
+
+	if _, ok := r.activeRuns[runID]; ok {
+		return true
+	}
+
+	for _, q := range r.queue {
+		if q.run.ID == runID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// interrupt finishes a run whose lease expired. Only the instance whose
+// change is stored goes on to restart it; the others, and the owner if it
+// renewed the lease in the meantime, leave it alone.
+func (r *Runner) interrupt(ctx context.Context, id string, now time.Time) {
+	var previous models.RunStatus
+
+	run, expired, err := r.repo.ExpireRun(id, now, func(run *models.Run) {
+		run.StateMu.Lock()
+		previous = run.Status
+
+		run.Status = models.RunStatusInterrupted
+		if previous == models.RunStatusCancelling {
+			run.Status = models.RunStatusCancelled
+		}
+
+		run.EndedAt = run.CheckpointedAt
+		if run.EndedAt.IsZero() {
+			run.EndedAt = run.StartedAt
+		}
+		run.StateMu.Unlock()
+
+		recordEvent(run, models.RunEventFinished, run.RPS, string(run.Status))
+	})
+	if err != nil {
+		r.logger.Error("update run failed", "run_id", id, "error", err)
+		return
+	}
+	if !expired {
+		return
+	}
+
+	r.logger.Warn("run interrupted", "run_id", run.ID, "previous_status", previous, "status", run.Status)
+
+	if run.Status != models.RunStatusInterrupted {
+		return
+	}
+
+	setup, err := r.repo.GetSetup(run.SetupID)
+	if err != nil || !setup.RestartInterrupted {
+		return
+	}
+
+	restarted, err := r.StartRun(ctx, run.SetupID, StartOptions{
+		Priority:  run.Priority,
+		Labels:    run.Labels,
+		Notes:     run.Notes,
+		RestartOf: run.ID,
+	})
+	if err != nil {
+		r.logger.Error("restart run failed", "run_id", run.ID, "error", err)
+		return
+	}
+
+	r.logger.Info("run restarted", "run_id", run.ID, "restarted_run_id", restarted.ID)
+}
+
+func (r *Runner) lease() time.Duration {
+	return leaseIntervals * r.checkpointInterval
+}
@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+const (
+	maxRetryAttempts  = 10
+	defaultBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+var (
+	defaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	defaultRetryErrors   = []models.RetryError{models.RetryErrorConnection, models.RetryErrorTimeout}
+)
+
+// retryExecutor repeats failed iterations of the wrapped executor according to
+// the setup's retry policy. The result of the last attempt is reported, with
+// Attempts set to the number of attempts made.
+type retryExecutor struct {
+	Executor
+	policy models.RetryPolicy
+}
+
+func newRetryExecutor(inner Executor, policy *models.RetryPolicy) *retryExecutor {
+	p := *policy
+
+	if len(p.Statuses) == 0 && len(p.Errors) == 0 {
+		p.Statuses = defaultRetryStatuses
+		p.Errors = defaultRetryErrors
+	}
+
+	if p.Backoff <= 0 {
+		p.Backoff = defaultBackoff
+	}
+
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+
+	return &retryExecutor{Executor: inner, policy: p}
+}
+
+func validateRetryPolicy(p *models.RetryPolicy) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.MaxAttempts < 1 || p.MaxAttempts > maxRetryAttempts {
+		return fmt.Errorf("retry max_attempts must be between 1 and %d", maxRetryAttempts)
+	}
+
+	for _, code := range p.Statuses {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("retry status %d is not an error status", code)
+		}
+	}
+
+	for _, e := range p.Errors {
+		switch e {
+		case models.RetryErrorConnection, models.RetryErrorTimeout, models.RetryErrorAny:
+		default:
+			return fmt.Errorf("unknown retry error %q", e)
+		}
+	}
+
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+
+	if p.MaxBackoff > 0 && p.Backoff > p.MaxBackoff {
+		return fmt.Errorf("retry backoff must not exceed max_backoff")
+	}
+
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1")
+	}
+
+	return nil
+}
+
+func (e *retryExecutor) Execute(ctx context.Context) *Result {
+	var sample *models.Sample
+
+	for attempt := 1; ; attempt++ {
+		res := e.Executor.Execute(ctx)
+		res.Attempts = attempt
+
+		if res.Sample != nil {
+			sample = res.Sample
+		} else {
+			res.Sample = sample
+		}
+
+		if attempt >= e.policy.MaxAttempts || ctx.Err() != nil || !e.retryable(res) {
+			return res
+		}
+
+		timer := time.NewTimer(e.backoff(attempt, res.RetryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			res.Error = fmt.Errorf("retry: %w", ctx.Err())
+			return res
+		case <-timer.C:
+		}
+	}
+}
+
+func (e *retryExecutor) retryable(res *Result) bool {
+	if res.Error == nil || res.StatusCode != 0 {
+		return slices.Contains(e.policy.Statuses, res.StatusCode)
+	}
+
+	for _, kind := range e.policy.Errors {
+		switch kind {
+		case models.RetryErrorAny:
+			return true
+		case models.RetryErrorConnection:
+			if res.ConnError {
+				return true
+			}
+		case models.RetryErrorTimeout:
+			if isTimeout(res.Error) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// backoff returns the delay before the next attempt: exponential from Backoff
+// with jitter, capped at MaxBackoff. A Retry-After from the server is honored
+// when it is longer, up to the same cap.
+func (e *retryExecutor) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := e.policy.Backoff << (attempt - 1)
+	if delay <= 0 || delay > e.policy.MaxBackoff {
+		delay = e.policy.MaxBackoff
+	}
+
+	if e.policy.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * e.policy.Jitter * rand.Float64())
+	}
+
+	if retryAfter > delay {
+		delay = min(retryAfter, e.policy.MaxBackoff)
+	}
+
+	return delay
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryAfter parses the Retry-After header of 429 and 503 responses, given
+// either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+
+	return 0
+}
@@ -4,56 +4,51 @@ import (
 	"context"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/bdtfs/gnat/internal/models"
-	httpclient "github.com/bdtfs/gnat/pkg/clients/http"
 )
 
 func (r *Runner) runLoop(
 	ctx context.Context,
-	run *models.Run,
+	active *activeRun,
 	setup *models.Setup,
 ) error {
-	if setup.URL == "" {
-		return fmt.Errorf("url cannot be empty")
-	}
+	run := active.run
 
 	if setup.RPS <= 0 {
 		return fmt.Errorf("rps must be greater than 0")
 	}
 
-	total := setup.RPS * int(setup.Duration/time.Second)
-
-	client := httpclient.New()
-	ch := r.collector.StartRunStatsProcessing(run)
-	defer close(ch)
-
-	interval := time.Second / time.Duration(setup.RPS)
-	start := time.Now()
+	ch, processed := r.collector.StartRunStatsProcessing(run)
+	defer func() {
+		close(ch)
+		<-processed
+		// The stats stay attached to the run; the collector only tracks
+		// runs that are executing.
+		r.collector.DeleteRun(run.ID)
+	}()
+
+	executor, err := newExecutor(setup)
+	if err != nil {
+		return err
+	}
 
-	var wg sync.WaitGroup
-	loopCtx := context.WithoutCancel(ctx)
-
-	for i := 0; i < total; i++ {
-		select {
-		case <-ctx.Done():
-			wg.Wait()
-			return nil
-		default:
+	if err = executor.Prepare(ctx, setup); err != nil {
+		return fmt.Errorf("prepare executor: %w", err)
+	}
+	defer func() {
+		if err := executor.Close(); err != nil {
+			r.logger.Error("close executor failed", "run_id", run.ID, "error", err)
 		}
+	}()
 
-		now := time.Now()
-		next := start.Add(time.Duration(i) * interval)
-
-		if now.Before(next) {
-			time.Sleep(next.Sub(now))
-		}
+	var wg sync.WaitGroup
 
+	for active.pacer.next(ctx) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			ch <- send(loopCtx, client, setup.Method, setup.URL, setup.Body)
+			ch <- executor.Execute(active.requests)
 		}()
 	}
 
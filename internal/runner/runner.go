@@ -5,31 +5,69 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"sync"
 	"time"
 
+	"github.com/bdtfs/gnat/internal/labels"
 	"github.com/bdtfs/gnat/internal/models"
-	repository "github.com/bdtfs/gnat/internal/storage/memory"
+	"github.com/bdtfs/gnat/internal/storage"
 )
 
 type Runner struct {
-	repo         *repository.Repository
-	logger       *slog.Logger
-	collector    *Collector
-	activeRuns   map[string]context.CancelFunc
-	activeRunsMu sync.RWMutex
+	repo               storage.Repository
+	logger             *slog.Logger
+	collector          *Collector
+	limits             Limits
+	checkpointInterval time.Duration
+	activeRuns         map[string]*activeRun
+	queue              []*queuedRun
+	queueSeq           uint64
+	stopped            bool
+	activeRunsMu       sync.RWMutex
+	executing          sync.WaitGroup
 }
 
-func New(repo *repository.Repository, logger *slog.Logger, collector *Collector) *Runner {
+const (
+	defaultDrainTimeout = 10 * time.Second
+	maxNotesLength      = 4096
+)
+
+// activeRun holds the controls of a run that has not finished yet. cancel
+// stops new iterations; requests is the context of in-flight requests and is
+// cancelled by abort.
+type activeRun struct {
+	mu          sync.Mutex
+	done        bool
+	cancelling  bool
+	interrupted bool
+	run         *models.Run
+	cancel      context.CancelFunc
+	requests    context.Context
+	abort       context.CancelFunc
+	drainTimer  *time.Timer
+	pacer       *pacer
+	rps         int
+}
+
+func New(repo storage.Repository, logger *slog.Logger, collector *Collector, limits Limits, checkpointInterval time.Duration) *Runner {
+	if checkpointInterval <= 0 {
+		checkpointInterval = defaultCheckpointInterval
+	}
+
 	return &Runner{
-		repo:       repo,
-		logger:     logger,
-		collector:  collector,
-		activeRuns: make(map[string]context.CancelFunc),
+		repo:               repo,
+		logger:             logger,
+		collector:          collector,
+		limits:             limits,
+		checkpointInterval: checkpointInterval,
+		activeRuns:         make(map[string]*activeRun),
 	}
 }
 
-func (r *Runner) StartRun(ctx context.Context, setupID string) (*models.Run, error) {
+// StartRun creates a run for the setup and queues it. The run stays pending
+// until the instance limits allow it to start.
+func (r *Runner) StartRun(ctx context.Context, setupID string, opts StartOptions) (*models.Run, error) {
 	setup, err := r.repo.GetSetup(setupID)
 	if err != nil {
 		return nil, fmt.Errorf("get setup: %w", err)
@@ -39,39 +77,95 @@ func (r *Runner) StartRun(ctx context.Context, setupID string) (*models.Run, err
 		return nil, fmt.Errorf("setup is not active")
 	}
 
+	if r.limits.MaxTotalRPS > 0 && setup.RPS > r.limits.MaxTotalRPS {
+		return nil, fmt.Errorf("setup rps %d exceeds the instance budget of %d", setup.RPS, r.limits.MaxTotalRPS)
+	}
+
+	if err = labels.Validate(opts.Labels); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Notes) > maxNotesLength {
+		return nil, fmt.Errorf("notes must be at most %d bytes", maxNotesLength)
+	}
+
 	run := models.NewRun(setupID)
+	run.SetupVersion = setup.Version
+	run.SetupName = setup.Name
+	run.RPS = setup.RPS
+	run.Priority = opts.Priority
+	run.Labels = maps.Clone(opts.Labels)
+	run.Notes = opts.Notes
+	run.RestartOf = opts.RestartOf
+	run.LeaseExpiresAt = run.QueuedAt.Add(r.lease())
 
 	if err = r.repo.CreateRun(run); err != nil {
 		return nil, fmt.Errorf("create run: %w", err)
 	}
 
-	root := context.WithoutCancel(ctx)
+	r.enqueue(context.WithoutCancel(ctx), run, setup)
+
+	return run, nil
+}
+
+func (r *Runner) launch(root context.Context, run *models.Run, setup *models.Setup) {
 	runCtx, cancel := context.WithCancel(root)
+	requests, abort := context.WithCancel(root)
 
-	r.activeRunsMu.Lock()
-	r.activeRuns[run.ID] = cancel
-	r.activeRunsMu.Unlock()
+	active := &activeRun{
+		run:      run,
+		cancel:   cancel,
+		requests: requests,
+		abort:    abort,
+		pacer:    newPacer(setup.RPS, setup.Duration),
+		rps:      setup.RPS,
+	}
 
-	r.logger.Info("run starting", "run_id", run.ID)
+	r.activeRuns[run.ID] = active
+	r.executing.Add(1)
 
-	go r.execute(runCtx, run, setup)
+	r.logger.Info("run starting", "run_id", run.ID)
 
-	return run, nil
+	go r.execute(runCtx, active, setup)
 }
 
-func (r *Runner) execute(ctx context.Context, run *models.Run, setup *models.Setup) {
+func (r *Runner) execute(ctx context.Context, active *activeRun, setup *models.Setup) {
+	run := active.run
+
 	defer func() {
 		r.activeRunsMu.Lock()
 		delete(r.activeRuns, run.ID)
+		r.admit()
 		r.activeRunsMu.Unlock()
+		active.abort()
+		r.executing.Done()
 	}()
 
+	active.mu.Lock()
+	run.StateMu.Lock()
 	run.Status = models.RunStatusRunning
+	run.StartedAt = time.Now()
+	run.StateMu.Unlock()
+	recordEvent(run, models.RunEventStarted, run.RPS, "")
+	active.mu.Unlock()
 
 	start := time.Now()
-	err := r.runLoop(ctx, run, setup)
+	err := r.runLoop(ctx, active, setup)
 	duration := time.Since(start)
 
+	active.mu.Lock()
+	active.done = true
+	if active.drainTimer != nil {
+		active.drainTimer.Stop()
+	}
+
+	if active.interrupted {
+		active.mu.Unlock()
+		r.release(run)
+		return
+	}
+
+	run.StateMu.Lock()
 	run.EndedAt = time.Now()
 
 	switch {
@@ -83,6 +177,10 @@ func (r *Runner) execute(ctx context.Context, run *models.Run, setup *models.Set
 	default:
 		run.Status = models.RunStatusCompleted
 	}
+	run.StateMu.Unlock()
+
+	recordEvent(run, models.RunEventFinished, run.RPS, string(run.Status))
+	active.mu.Unlock()
 
 	r.logger.Info(
 		"run finished",
@@ -93,6 +191,7 @@ func (r *Runner) execute(ctx context.Context, run *models.Run, setup *models.Set
 		"total_requests", run.Stats.TotalRequests,
 		"success_requests", run.Stats.SuccessRequests,
 		"failed_requests", run.Stats.FailedRequests,
+		"cancelled_requests", run.Stats.CancelledRequests,
 	)
 
 	if err = r.repo.UpdateRun(run); err != nil {
@@ -100,17 +199,170 @@ func (r *Runner) execute(ctx context.Context, run *models.Run, setup *models.Set
 	}
 }
 
-func (r *Runner) CancelRun(runID string) error {
+// CancelRun stops a run from sending new requests. In drain mode in-flight
+// requests may finish until timeout has passed, after which they are aborted;
+// in abort mode they are aborted right away. Aborted requests are counted as
+// cancelled. The run becomes cancelled once everything has stopped. An abort
+// may follow a drain that is still in progress. Pending runs are removed from
+// the queue and cancelled immediately.
+func (r *Runner) CancelRun(runID string, mode models.CancelMode, timeout time.Duration) error {
+	if mode == "" {
+		mode = models.CancelModeDrain
+	}
+
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	if mode != models.CancelModeDrain && mode != models.CancelModeAbort {
+		return fmt.Errorf("unknown cancel mode %q", mode)
+	}
+
+	if r.dequeue(runID) {
+		return nil
+	}
+
+	active, err := r.lockActiveRun(runID)
+	if err != nil {
+		return err
+	}
+	defer active.mu.Unlock()
+
+	if active.cancelling && mode == models.CancelModeDrain {
+		return fmt.Errorf("run %s is already being cancelled", runID)
+	}
+
+	active.cancelling = true
+	active.run.StateMu.Lock()
+	active.run.Status = models.RunStatusCancelling
+	active.run.StateMu.Unlock()
+	active.cancel()
+
+	message := string(mode)
+	if mode == models.CancelModeAbort {
+		active.abort()
+	} else {
+		active.drainTimer = time.AfterFunc(timeout, active.abort)
+		message = fmt.Sprintf("drain, timeout %s", timeout)
+	}
+
+	recordEvent(active.run, models.RunEventCancelRequested, active.run.RPS, message)
+	r.logger.Info("run cancelling", "run_id", runID, "mode", mode)
+
+	return nil
+}
+
+func (r *Runner) PauseRun(runID string) error {
+	active, err := r.lockRunningRun(runID)
+	if err != nil {
+		return err
+	}
+	defer active.mu.Unlock()
+
+	if err = active.pacer.pause(); err != nil {
+		return err
+	}
+
+	active.run.StateMu.Lock()
+	active.run.Status = models.RunStatusPaused
+	active.run.StateMu.Unlock()
+	recordEvent(active.run, models.RunEventPaused, active.run.RPS, "")
+	r.logger.Info("run paused", "run_id", runID)
+
+	return nil
+}
+
+func (r *Runner) ResumeRun(runID string) error {
+	active, err := r.lockRunningRun(runID)
+	if err != nil {
+		return err
+	}
+	defer active.mu.Unlock()
+
+	if err = active.pacer.resume(); err != nil {
+		return err
+	}
+
+	active.run.StateMu.Lock()
+	active.run.Status = models.RunStatusRunning
+	active.run.StateMu.Unlock()
+	recordEvent(active.run, models.RunEventResumed, active.run.RPS, "")
+	r.logger.Info("run resumed", "run_id", runID)
+
+	return nil
+}
+
+func (r *Runner) SetRunRPS(runID string, rps int) error {
+	if rps <= 0 {
+		return fmt.Errorf("rps must be greater than 0")
+	}
+
+	active, err := r.lockRunningRun(runID)
+	if err != nil {
+		return err
+	}
+	defer active.mu.Unlock()
+
+	if err = r.reserveRPS(active, rps); err != nil {
+		return err
+	}
+
+	previous := active.run.RPS
+	active.pacer.setRate(rps)
+	active.run.StateMu.Lock()
+	active.run.RPS = rps
+	active.run.StateMu.Unlock()
+
+	recordEvent(active.run, models.RunEventRateChanged, rps, fmt.Sprintf("rps changed from %d to %d", previous, rps))
+	r.logger.Info("run rate changed", "run_id", runID, "rps", rps)
+
+	return nil
+}
+
+// lockActiveRun returns the active run with its lock held. The caller must
+// unlock it.
+func (r *Runner) lockActiveRun(runID string) (*activeRun, error) {
 	r.activeRunsMu.RLock()
-	cancel, ok := r.activeRuns[runID]
+	active, ok := r.activeRuns[runID]
 	r.activeRunsMu.RUnlock()
 
 	if !ok {
-		return fmt.Errorf("run %s is not active", runID)
+		return nil, fmt.Errorf("run %s is not active", runID)
 	}
 
-	cancel()
-	return nil
+	active.mu.Lock()
+	if active.done {
+		active.mu.Unlock()
+		return nil, fmt.Errorf("run %s is not active", runID)
+	}
+
+	return active, nil
+}
+
+func (r *Runner) lockRunningRun(runID string) (*activeRun, error) {
+	active, err := r.lockActiveRun(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	if active.cancelling {
+		active.mu.Unlock()
+		return nil, fmt.Errorf("run %s is being cancelled", runID)
+	}
+
+	return active, nil
+}
+
+func recordEvent(run *models.Run, typ models.RunEventType, rps int, message string) {
+	run.EventsMu.Lock()
+	defer run.EventsMu.Unlock()
+
+	run.Events = append(run.Events, models.RunEvent{
+		Type:      typ,
+		Timestamp: time.Now(),
+		RPS:       rps,
+		Message:   message,
+	})
 }
 
 func (r *Runner) GetActiveRuns() []string {
@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"container/heap"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+const (
+	sampleFailures   = 20
+	sampleSlowest    = 20
+	sampleRandomRate = 0.001
+	sampleMaxRandom  = 100
+	sampleMaxBody    = 4 << 10
+	sampleRedacted   = "[REDACTED]"
+)
+
+// sensitiveHeaders are redacted in samples, which are stored with the run and
+// exported with it.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Csrf-Token",
+}
+
+// sampler decides which results of a run keep a copy of their request and
+// response: the first failures, the slowest responses and a random fraction.
+// It is shared by all iterations of a run and is safe for concurrent use.
+type sampler struct {
+	failures atomic.Int64
+	randoms  atomic.Int64
+
+	mu      sync.Mutex
+	slowest latencyHeap
+}
+
+func newSampler() *sampler {
+	return &sampler{}
+}
+
+// reason returns why a result should be sampled, or false if it should not.
+// A nil sampler never samples.
+func (s *sampler) reason(failed bool, latency time.Duration) (models.SampleReason, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	if failed && s.failures.Load() < sampleFailures && s.failures.Add(1) <= sampleFailures {
+		return models.SampleReasonFailure, true
+	}
+
+	if s.slow(latency) {
+		return models.SampleReasonSlow, true
+	}
+
+	if rand.Float64() < sampleRandomRate && s.randoms.Add(1) <= sampleMaxRandom {
+		return models.SampleReasonRandom, true
+	}
+
+	return "", false
+}
+
+func (s *sampler) slow(latency time.Duration) bool {
+	if latency <= 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slowest.Len() < sampleSlowest {
+		heap.Push(&s.slowest, latency)
+		return true
+	}
+
+	if latency <= s.slowest[0] {
+		return false
+	}
+
+	s.slowest[0] = latency
+	heap.Fix(&s.slowest, 0)
+	return true
+}
+
+type latencyHeap []time.Duration
+
+func (h latencyHeap) Len() int           { return len(h) }
+func (h latencyHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h latencyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *latencyHeap) Push(x any)        { *h = append(*h, x.(time.Duration)) }
+
+func (h *latencyHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// readSampleBody reads the first sampleMaxBody bytes of r and discards the
+// rest, returning the excerpt, the total size and whether it was truncated.
+func readSampleBody(r io.Reader) ([]byte, int64, bool, error) {
+	buf := make([]byte, sampleMaxBody)
+
+	n, err := io.ReadFull(r, buf)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		return buf[:n], int64(n), false, nil
+	default:
+		return buf[:n], int64(n), false, err
+	}
+
+	rest, err := io.Copy(io.Discard, r)
+	return buf, int64(n) + rest, rest > 0, err
+}
+
+func truncateSample(data []byte) ([]byte, bool) {
+	if len(data) <= sampleMaxBody {
+		return append([]byte(nil), data...), false
+	}
+	return append([]byte(nil), data[:sampleMaxBody]...), true
+}
+
+func httpSample(reason models.SampleReason, res *Result, req *http.Request, body []byte) *models.Sample {
+	reqBody, reqTruncated := truncateSample(body)
+
+	sample := &models.Sample{
+		Reason:     reason,
+		Timestamp:  res.Timestamp,
+		Latency:    res.Latency,
+		StatusCode: res.StatusCode,
+		Request: models.SampleRequest{
+			Method:        req.Method,
+			URL:           req.URL.Redacted(),
+			Headers:       sampleHeader(req.Header),
+			Body:          reqBody,
+			BodyTruncated: reqTruncated,
+		},
+	}
+
+	if res.Error != nil {
+		sample.Error = res.Error.Error()
+	}
+
+	return sample
+}
+
+// sampleHeader returns a copy of h with the values of sensitive headers
+// redacted.
+func sampleHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range sensitiveHeaders {
+		for i := range out[name] {
+			out[name][i] = sampleRedacted
+		}
+	}
+	return out
+}
+
+func failedStatus(code int) bool {
+	return code < 200 || code >= 400
+}
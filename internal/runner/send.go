@@ -1,28 +1,103 @@
 package runner
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/pkg/executor"
 )
 
-type Result struct {
-	StatusCode int
-	Latency    time.Duration
-	BytesRead  int64
-	Error      error
-	Timestamp  time.Time
+func init() {
+	executor.Register("http", executor.Definition{
+		New:      func() Executor { return &httpExecutor{} },
+		Validate: validateHTTPSetup,
+	})
+}
+
+type httpExecutor struct {
+	client  *http.Client
+	setup   *models.Setup
+	body    *bodyBuilder
+	header  http.Header
+	sampler *sampler
+}
+
+func validateHTTPSetup(setup *models.Setup) error {
+	if err := validateHTTPURL(setup); err != nil {
+		return err
+	}
+
+	_, err := newBodyBuilder(setup)
+	return err
+}
+
+func (e *httpExecutor) Prepare(_ context.Context, setup *models.Setup) error {
+	if err := validateHTTPURL(setup); err != nil {
+		return err
+	}
+
+	client, err := newHTTPClient(setup)
+	if err != nil {
+		return err
+	}
+
+	body, err := newBodyBuilder(setup)
+	if err != nil {
+		return err
+	}
+
+	e.client = client
+	e.setup = setup
+	e.body = body
+	e.header = requestHeader(setup.Headers, body.contentType)
+	e.sampler = newSampler()
+	return nil
 }
 
-func send(ctx context.Context, client *http.Client, method, url string, body []byte) *Result {
+func (e *httpExecutor) Execute(ctx context.Context) *Result {
+	body, err := e.body.build()
+	if err != nil {
+		return &Result{Timestamp: time.Now(), Error: err}
+	}
+
+	trace := &connTrace{trackSource: len(e.setup.SourceAddrs) > 0}
+	res := send(trace.context(ctx), e.client, e.setup.Method, e.setup.URL, body, e.header.Clone(), e.sampler)
+	return trace.apply(res)
+}
+
+func (e *httpExecutor) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func requestHeader(headers map[string]string, contentType string) http.Header {
+	h := make(http.Header, len(headers)+1)
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return h
+}
+
+func send(
+	ctx context.Context,
+	client *http.Client,
+	method, url string,
+	body *requestBody,
+	header http.Header,
+	smp *sampler,
+) *Result {
 	res := &Result{Timestamp: time.Now()}
 
 	var reqBody io.Reader
-	if len(body) > 0 {
-		reqBody = bytes.NewReader(body)
+	if body.size() > 0 {
+		reqBody = body.reader()
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
@@ -31,8 +106,15 @@ func send(ctx context.Context, client *http.Client, method, url string, body []b
 		return res
 	}
 
-	if len(body) > 0 {
-		req.Header.Set("Content-Type", "application/octet-stream")
+	if body.randomSize > 0 {
+		req.ContentLength = body.randomSize
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(body.reader()), nil
+		}
+	}
+
+	if header != nil {
+		req.Header = header
 	}
 
 	start := time.Now()
@@ -41,6 +123,9 @@ func send(ctx context.Context, client *http.Client, method, url string, body []b
 
 	if err != nil {
 		res.Error = fmt.Errorf("do request: %w", err)
+		if reason, ok := smp.reason(true, res.Latency); ok {
+			res.Sample = httpSample(reason, res, req, body.head())
+		}
 		return res
 	}
 
@@ -51,13 +136,31 @@ func send(ctx context.Context, client *http.Client, method, url string, body []b
 	}()
 
 	res.StatusCode = resp.StatusCode
+	res.RetryAfter = retryAfter(resp)
 
-	n, err := io.Copy(io.Discard, resp.Body)
+	reason, sampled := smp.reason(failedStatus(resp.StatusCode), res.Latency)
+	if !sampled {
+		n, err := io.Copy(io.Discard, resp.Body)
+		if err != nil {
+			res.Error = fmt.Errorf("read body: %w", err)
+			return res
+		}
+
+		res.BytesRead = n
+		return res
+	}
+
+	data, n, truncated, err := readSampleBody(resp.Body)
 	if err != nil {
 		res.Error = fmt.Errorf("read body: %w", err)
-		return res
+	} else {
+		res.BytesRead = n
 	}
 
-	res.BytesRead = n
+	res.Sample = httpSample(reason, res, req, body.head())
+	res.Sample.Headers = sampleHeader(resp.Header)
+	res.Sample.Body = data
+	res.Sample.BodyTruncated = truncated
+
 	return res
 }
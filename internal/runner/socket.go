@@ -0,0 +1,306 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	httpclient "github.com/bdtfs/gnat/pkg/clients/http"
+	"github.com/bdtfs/gnat/pkg/executor"
+)
+
+const (
+	socketWriteTimeout  = 10 * time.Second
+	socketReadTimeout   = 10 * time.Second
+	socketMaxIdleConns  = 1024
+	socketMaxReadBuffer = 64 * 1024
+)
+
+func init() {
+	for _, network := range []string{"tcp", "udp"} {
+		executor.Register(network, executor.Definition{
+			New:      func() Executor { return &socketExecutor{network: network} },
+			Validate: validateSocketSetup,
+		})
+	}
+}
+
+// socketConfig is the executor_config of the tcp and udp executors.
+// ReadTimeout bounds the wait for a response, 10s by default. With NoResponse
+// a request succeeds once its payload is written, and nothing is read.
+type socketConfig struct {
+	ReadTimeout string `json:"read_timeout"`
+	NoResponse  bool   `json:"no_response"`
+}
+
+type socketExecutor struct {
+	network     string
+	url         string
+	addr        string
+	payload     []byte
+	expect      *regexp.Regexp
+	readTimeout time.Duration
+	noResponse  bool
+	reuse       bool
+	maxRequests int
+	trackSource bool
+	dialer      *httpclient.Dialer
+	idle        chan *socketConn
+	sampler     *sampler
+}
+
+type socketConn struct {
+	net.Conn
+	requests int
+}
+
+func validateSocketSetup(setup *models.Setup) error {
+	_, _, err := decodeSocketConfig(setup)
+	return err
+}
+
+// decodeSocketConfig checks setup and returns its executor_config, with the
+// read timeout it sets.
+func decodeSocketConfig(setup *models.Setup) (socketConfig, time.Duration, error) {
+	var cfg socketConfig
+	if setup.URL == "" {
+		return cfg, 0, fmt.Errorf("url is required")
+	}
+
+	u, err := url.Parse(setup.URL)
+	if err != nil {
+		return cfg, 0, fmt.Errorf("invalid url: %w", err)
+	}
+
+	if u.Scheme != ExecutorName(setup) {
+		return cfg, 0, fmt.Errorf("url scheme %q does not match executor", u.Scheme)
+	}
+
+	if u.Hostname() == "" || u.Port() == "" {
+		return cfg, 0, fmt.Errorf("%s url must be in the form %s://host:port", u.Scheme, u.Scheme)
+	}
+
+	if setup.Expect != "" {
+		if _, err = regexp.Compile(setup.Expect); err != nil {
+			return cfg, 0, fmt.Errorf("invalid expect pattern: %w", err)
+		}
+	}
+
+	if err = executor.DecodeConfig(setup, &cfg); err != nil {
+		return cfg, 0, err
+	}
+
+	if cfg.NoResponse && setup.Expect != "" {
+		return cfg, 0, fmt.Errorf("expect cannot be set with no_response")
+	}
+
+	readTimeout := socketReadTimeout
+	if cfg.ReadTimeout != "" {
+		if readTimeout, err = time.ParseDuration(cfg.ReadTimeout); err != nil {
+			return cfg, 0, fmt.Errorf("invalid read_timeout: %w", err)
+		}
+		if readTimeout <= 0 {
+			return cfg, 0, fmt.Errorf("read_timeout must be positive")
+		}
+	}
+
+	return cfg, readTimeout, nil
+}
+
+func (e *socketExecutor) Prepare(_ context.Context, setup *models.Setup) error {
+	socketCfg, readTimeout, err := decodeSocketConfig(setup)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := clientConfig(setup)
+	if err != nil {
+		return err
+	}
+
+	u, _ := url.Parse(setup.URL)
+
+	e.url = setup.URL
+	e.addr = u.Host
+	e.payload = setup.Body
+	e.readTimeout = readTimeout
+	e.noResponse = socketCfg.NoResponse
+	e.reuse = setup.Connection != models.ConnectionModePerRequest
+	e.maxRequests = setup.MaxRequestsPerConn
+	e.trackSource = len(setup.SourceAddrs) > 0
+	e.dialer = httpclient.NewDialer(cfg)
+	e.idle = make(chan *socketConn, socketMaxIdleConns)
+	e.sampler = newSampler()
+
+	if setup.Expect != "" {
+		e.expect = regexp.MustCompile(setup.Expect)
+	}
+
+	return nil
+}
+
+func (e *socketExecutor) Execute(ctx context.Context) *Result {
+	res := &Result{Timestamp: time.Now()}
+
+	conn, connectLatency, err := e.acquire(ctx)
+	res.ConnectLatency = connectLatency
+	if err != nil {
+		res.ConnError = true
+		res.Error = fmt.Errorf("dial: %w", err)
+		res.RemoteIP = remoteIP(nil, err)
+		if e.trackSource {
+			res.SourceIP = sourceIP(nil, err)
+		}
+		e.sample(res, nil)
+		return res
+	}
+
+	res.RemoteIP = remoteIP(conn, nil)
+	if e.trackSource {
+		res.SourceIP = sourceIP(conn, nil)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+
+	start := time.Now()
+	data, err := e.roundTrip(conn)
+	res.Latency = time.Since(start)
+	res.BytesRead = int64(len(data))
+
+	if !stop() && err != nil {
+		err = fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		res.Error = err
+		e.sample(res, data)
+		return res
+	}
+
+	e.release(conn)
+	e.sample(res, data)
+	return res
+}
+
+func (e *socketExecutor) sample(res *Result, data []byte) {
+	reason, ok := e.sampler.reason(res.Error != nil, res.Latency)
+	if !ok {
+		return
+	}
+
+	reqBody, reqTruncated := truncateSample(e.payload)
+	body, truncated := truncateSample(data)
+
+	res.Sample = &models.Sample{
+		Reason:        reason,
+		Timestamp:     res.Timestamp,
+		Latency:       res.Latency,
+		Body:          body,
+		BodyTruncated: truncated,
+		Request: models.SampleRequest{
+			URL:           e.url,
+			Body:          reqBody,
+			BodyTruncated: reqTruncated,
+		},
+	}
+
+	if res.Error != nil {
+		res.Sample.Error = res.Error.Error()
+	}
+}
+
+func (e *socketExecutor) acquire(ctx context.Context) (*socketConn, time.Duration, error) {
+	if e.reuse {
+		select {
+		case conn := <-e.idle:
+			return conn, 0, nil
+		default:
+		}
+	}
+
+	start := time.Now()
+	conn, err := e.dialer.DialContext(ctx, e.network, e.addr)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+
+	return &socketConn{Conn: conn}, time.Since(start), nil
+}
+
+func (e *socketExecutor) release(conn *socketConn) {
+	conn.requests++
+
+	if !e.reuse || (e.maxRequests > 0 && conn.requests >= e.maxRequests) {
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case e.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+// roundTrip writes the payload and reads the response, until expect matches
+// or, without expect, for one chunk or datagram. In no-response mode it only
+// writes.
+func (e *socketExecutor) roundTrip(conn *socketConn) ([]byte, error) {
+	if len(e.payload) > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout)); err != nil {
+			return nil, fmt.Errorf("set deadline: %w", err)
+		}
+		if _, err := conn.Write(e.payload); err != nil {
+			return nil, fmt.Errorf("write payload: %w", err)
+		}
+	}
+
+	if e.noResponse {
+		return nil, nil
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(e.readTimeout)); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+
+	buf := make([]byte, 0, 4096)
+	chunk := make([]byte, socketMaxReadBuffer)
+
+	for {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if n > 0 && (e.expect == nil || e.expect.Match(buf)) {
+			return buf, nil
+		}
+
+		switch {
+		case errors.Is(err, io.EOF) && e.expect != nil:
+			return buf, fmt.Errorf("unexpected response: connection closed before match")
+		case err != nil:
+			return buf, fmt.Errorf("read response: %w", err)
+		case len(buf) >= socketMaxReadBuffer:
+			return buf, fmt.Errorf("unexpected response: no match in %d bytes", len(buf))
+		}
+	}
+}
+
+func (e *socketExecutor) Close() error {
+	for {
+		select {
+		case conn := <-e.idle:
+			_ = conn.Close()
+		default:
+			return nil
+		}
+	}
+}
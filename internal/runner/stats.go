@@ -1,15 +1,18 @@
 package runner
 
 import (
-	"time"
-
+	"github.com/bdtfs/gnat/internal/histogram"
 	"github.com/bdtfs/gnat/internal/models"
 )
 
 func NewStats() *models.Stats {
 	return &models.Stats{
 		StatusCodes: make(map[int]*uint64),
-		Latencies:   make([]time.Duration, 0, 10000),
+		Latencies:   histogram.New(),
 		Errors:      make([]string, 0),
+		Operations:  make(map[string]*models.OperationStats),
+		Sources:     make(map[string]*models.SourceStats),
+		RemoteIPs:   make(map[string]*uint64),
+		Samples:     make([]*models.Sample, 0),
 	}
 }
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type payloadTemplate struct {
+	tmpl *template.Template
+	seq  atomic.Uint64
+}
+
+func newPayloadTemplate(text string) (*payloadTemplate, error) {
+	t := &payloadTemplate{}
+
+	tmpl, err := template.New("payload").Option("missingkey=error").Funcs(template.FuncMap{
+		"uuid":      func() string { return uuid.New().String() },
+		"seq":       func() uint64 { return t.seq.Add(1) },
+		"randInt":   randInt,
+		"now":       func() string { return time.Now().UTC().Format(time.RFC3339Nano) },
+		"timestamp": func() int64 { return time.Now().Unix() },
+		"quote":     strconv.Quote,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+
+	t.tmpl = tmpl
+	return t, nil
+}
+
+func (t *payloadTemplate) render() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func randInt(lo, hi int) int {
+	if hi <= lo {
+		return lo
+	}
+	return lo + rand.IntN(hi-lo+1)
+}
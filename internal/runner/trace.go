@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+type connTrace struct {
+	trackSource      bool
+	mu               sync.Mutex
+	connectStart     time.Time
+	handshakeStart   time.Time
+	connectLatency   time.Duration
+	handshakeLatency time.Duration
+	connErr          bool
+	sourceIP         string
+	remoteIP         string
+}
+
+func (t *connTrace) context(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.remoteIP = remoteIP(info.Conn, nil)
+			if t.trackSource {
+				t.sourceIP = sourceIP(info.Conn, nil)
+			}
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				t.mu.Lock()
+				t.connErr = true
+				t.mu.Unlock()
+			}
+		},
+		ConnectStart: func(_, _ string) {
+			t.mu.Lock()
+			t.connectStart = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			if err != nil {
+				t.connErr = true
+				return
+			}
+			t.connectLatency = time.Since(t.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.handshakeStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			if err != nil {
+				t.connErr = true
+				return
+			}
+			t.handshakeLatency = time.Since(t.handshakeStart)
+		},
+	})
+}
+
+func (t *connTrace) apply(res *Result) *Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res.ConnectLatency = t.connectLatency
+	res.HandshakeLatency = t.handshakeLatency
+	res.ConnError = t.connErr
+
+	res.RemoteIP = t.remoteIP
+	if res.RemoteIP == "" && res.Error != nil {
+		res.RemoteIP = remoteIP(nil, res.Error)
+	}
+
+	if t.trackSource {
+		res.SourceIP = t.sourceIP
+		if res.SourceIP == "" && res.Error != nil {
+			res.SourceIP = sourceIP(nil, res.Error)
+		}
+	}
+
+	return res
+}
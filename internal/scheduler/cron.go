@@ -0,0 +1,236 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard five-field cron expression: minute, hour,
+// day of month, month and day of week. Each field is a bit set of the values
+// it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// maxCronSearch bounds the search for the next activation so that expressions
+// which never match, like "0 0 30 2 *", fail instead of looping forever.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// Sunday may be written as 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domAny = fields[2] == "*" || fields[2] == "?"
+	s.dowAny = fields[4] == "*" || fields[4] == "?"
+
+	if _, ok := s.next(time.Now()); !ok {
+		return nil, fmt.Errorf("cron expression %q never matches", expr)
+	}
+
+	return &s, nil
+}
+
+func (f cronField) parse(spec string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(spec, ",") {
+		step := 1
+		if base, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", s)
+			}
+			part, step = base, n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			a, b, _ := strings.Cut(part, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := f.value(part)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// next returns the first activation strictly after t, in t's location.
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronSearch)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		// Turning the clock back repeats an hour. Only expressions that
+		// match every hour run in both; the others run once.
+		if s.hour != everyHour && repeated(t) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// everyHour is the hour field of "*".
+const everyHour = 1<<24 - 1
+
+// repeated reports whether the local time of t already passed an hour
+// earlier, before a DST change turned the clock back.
+func repeated(t time.Time) bool {
+	prev := t.Add(-time.Hour)
+	return prev.Day() == t.Day() && prev.Hour() == t.Hour() && prev.Minute() == t.Minute()
+}
+
+// later returns u if it is after t, and the minute after t otherwise.
+// time.Date maps a local time that a DST change skips to before the change,
+// which may not be after t.
+func later(t, u time.Time) time.Time {
+	if u.After(t) {
+		return u
+	}
+	return t.Add(time.Minute)
+}
+
+// dayMatches follows cron semantics: when both day fields are restricted, a
+// day matching either of them is enough.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
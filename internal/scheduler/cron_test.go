@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// local returns the time at "2006-01-02 15:04" in loc. On the day the clock
+// is turned back, the first of two equal local times is returned.
+func local(t *testing.T, loc *time.Location, value string) time.Time {
+	t.Helper()
+	v, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatalf("parse %q: %v", value, err)
+	}
+	return v
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		// err is part of the error, or empty if the expression is valid.
+		err string
+	}{
+		{expr: "* * * * *"},
+		{expr: "@Daily"},
+		{expr: "0 9-17/2 * jan-MAR mon-fri"},
+		{expr: "0 0 ? * 7"},
+		{expr: "* * * *", err: "must have 5 fields"},
+		{expr: "@fortnightly", err: "must have 5 fields"},
+		{expr: "60 * * * *", err: "minute: value 60 out of range 0-59"},
+		{expr: "* 24 * * *", err: "hour: value 24 out of range 0-23"},
+		{expr: "* * 0 * *", err: "day of month: value 0 out of range 1-31"},
+		{expr: "* * * 13 *", err: "month: value 13 out of range 1-12"},
+		{expr: "* * * * 8", err: "day of week: value 8 out of range 0-7"},
+		{expr: "* * * foo *", err: `month: invalid value "foo"`},
+		{expr: "*/0 * * * *", err: `minute: invalid step "0"`},
+		{expr: "30-10 * * * *", err: `minute: invalid range "30-10"`},
+		{expr: "0 0 30 2 *", err: "never matches"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseCron(tt.expr)
+
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("parseCron(%q) failed: %v", tt.expr, err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("parseCron(%q) returned error %v, want %q", tt.expr, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		loc  *time.Location
+		from string
+		// want is the next activation, or empty if there is none within
+		// the search bound.
+		want string
+	}{
+		{name: "every minute", expr: "* * * * *", from: "2024-01-01 10:15", want: "2024-01-01 10:16"},
+		{name: "strictly after a match", expr: "30 10 * * *", from: "2024-01-01 10:30", want: "2024-01-02 10:30"},
+		{name: "hourly", expr: "@hourly", from: "2024-01-01 10:15", want: "2024-01-01 11:00"},
+		{name: "daily", expr: "@daily", from: "2024-01-01 10:00", want: "2024-01-02 00:00"},
+		{name: "weekly starts on sunday", expr: "@weekly", from: "2024-01-03 10:00", want: "2024-01-07 00:00"},
+		{name: "monthly", expr: "@monthly", from: "2024-01-31 10:00", want: "2024-02-01 00:00"},
+		{name: "yearly", expr: "@yearly", from: "2024-06-01 00:00", want: "2025-01-01 00:00"},
+		{name: "list", expr: "0 8,20 * * *", from: "2024-01-01 08:00", want: "2024-01-01 20:00"},
+		{name: "range wraps to the next day", expr: "0 9-17 * * *", from: "2024-01-01 17:30", want: "2024-01-02 09:00"},
+		{name: "step", expr: "*/15 * * * *", from: "2024-01-01 10:16", want: "2024-01-01 10:30"},
+		{name: "step from a value", expr: "5/20 * * * *", from: "2024-01-01 10:26", want: "2024-01-01 10:45"},
+		{name: "range with step", expr: "10-40/15 * * * *", from: "2024-01-01 10:41", want: "2024-01-01 11:10"},
+		{name: "names", expr: "0 0 * jan-mar MON", from: "2024-03-26 00:00", want: "2025-01-06 00:00"},
+		{name: "sunday as 7", expr: "0 0 * * 7", from: "2024-01-01 00:00", want: "2024-01-07 00:00"},
+		{name: "day of month skips short months", expr: "0 0 31 * *", from: "2024-04-01 00:00", want: "2024-05-31 00:00"},
+		{name: "leap day", expr: "0 0 29 2 *", from: "2024-03-01 00:00", want: "2028-02-29 00:00"},
+		{name: "day of week or day of month: weekday first", expr: "0 0 13 * fri", from: "2024-01-01 00:00", want: "2024-01-05 00:00"},
+		{name: "day of week or day of month: date first", expr: "0 0 13 * fri", from: "2024-01-12 01:00", want: "2024-01-13 00:00"},
+		{name: "restricted day of week alone", expr: "0 0 * * fri", from: "2024-01-06 00:00", want: "2024-01-12 00:00"},
+		{name: "restricted day of month alone", expr: "0 0 13 * ?", from: "2024-01-06 00:00", want: "2024-01-13 00:00"},
+		{name: "in the time zone of from", expr: "0 9 * * *", loc: newYork, from: "2024-01-01 10:00", want: "2024-01-02 09:00"},
+		{name: "skipped hour does not run", expr: "30 2 * * *", loc: newYork, from: "2024-03-10 00:00", want: "2024-03-11 02:30"},
+		{name: "hourly across the skipped hour", expr: "0 * * * *", loc: newYork, from: "2024-03-10 01:30", want: "2024-03-10 03:00"},
+		{name: "minutes across the skipped hour", expr: "*/20 * * * *", loc: newYork, from: "2024-03-10 01:50", want: "2024-03-10 03:00"},
+		{name: "repeated hour runs once", expr: "30 1 * * *", loc: newYork, from: "2024-11-03 01:30", want: "2024-11-04 01:30"},
+		{name: "leap day beyond the search bound", expr: "0 0 29 2 *", from: "2097-03-01 00:00"},
+		{name: "leap day within the search bound", expr: "0 0 29 2 *", from: "2099-03-01 00:00", want: "2104-02-29 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := tt.loc
+			if loc == nil {
+				loc = time.UTC
+			}
+
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+
+			got, ok := s.next(local(t, loc, tt.from))
+
+			if tt.want == "" {
+				if ok {
+					t.Fatalf("next(%s) = %v, want none", tt.from, got)
+				}
+				return
+			}
+
+			if want := local(t, loc, tt.want); !ok || !got.Equal(want) || got.Location() != loc {
+				t.Fatalf("next(%s) = %v, %v, want %v", tt.from, got, ok, want)
+			}
+		})
+	}
+}
+
+func TestCronNextRepeatedHour(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	// 01:00 to 02:00 passes twice on 2024-11-03, first in EDT and then in EST.
+	edt := time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "30 * * * *", want: edt.Add(time.Hour)},
+		{expr: "30 1 * * *", want: edt.AddDate(0, 0, 1)},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+
+		if got, ok := s.next(edt); !ok || !got.Equal(tt.want) {
+			t.Errorf("%q: next(%v) = %v, want %v", tt.expr, edt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/runner"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+const (
+	tickInterval       = time.Second
+	maxScheduleHistory = 100
+)
+
+// Scheduler starts runs for schedules when they are due. Schedules are never
+// modified in place: every change stores an updated copy, so readers can keep
+// using the pointers they got from the repository.
+type Scheduler struct {
+	repo   storage.Repository
+	runner runStarter
+	logger *slog.Logger
+	mu     sync.Mutex
+}
+
+// runStarter starts the runs of schedules. It is *runner.Runner outside of
+// tests.
+type runStarter interface {
+	StartRun(ctx context.Context, setupID string, opts runner.StartOptions) (*models.Run, error)
+}
+
+func New(repo storage.Repository, runner *runner.Runner, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		repo:   repo,
+		runner: runner,
+		logger: logger,
+	}
+}
+
+// Start checks the schedules every second until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.tick(ctx, now)
+		}
+	}
+}
+
+func (s *Scheduler) Create(schedule *models.Schedule) error {
+	if err := s.prepare(schedule); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.repo.CreateSchedule(schedule); err != nil {
+		return fmt.Errorf("create schedule: %w", err)
+	}
+
+	return nil
+}
+
+// Replace replaces the definition of a schedule. Its history and creation
+// time stay.
+func (s *Scheduler) Replace(schedule *models.Schedule) error {
+	if err := s.prepare(schedule); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, _, err := s.repo.ModifySchedule(schedule.ID, func(current *models.Schedule) bool {
+		schedule.LastRunID = current.LastRunID
+		schedule.History = current.History
+		schedule.CreatedAt = current.CreatedAt
+		schedule.UpdatedAt = time.Now()
+		*current = *schedule
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	*schedule = *updated
+	return nil
+}
+
+// prepare validates a new schedule definition and sets its next run.
+func (s *Scheduler) prepare(schedule *models.Schedule) error {
+	setup, err := s.repo.GetSetup(schedule.SetupID)
+	if err != nil {
+		return fmt.Errorf("get setup: %w", err)
+	}
+
+	if setup.Status == models.SetupStatusArchived {
+		return fmt.Errorf("setup is archived")
+	}
+
+	if schedule.Overlap == "" {
+		schedule.Overlap = models.OverlapSkip
+	}
+
+	switch schedule.Overlap {
+	case models.OverlapSkip, models.OverlapQueue, models.OverlapAllow:
+	default:
+		return fmt.Errorf("unknown overlap policy %q", schedule.Overlap)
+	}
+
+	if (schedule.Cron == "") == schedule.At.IsZero() {
+		return fmt.Errorf("exactly one of at and cron is required")
+	}
+
+	if !schedule.At.IsZero() && !schedule.At.After(time.Now()) {
+		return fmt.Errorf("at must be in the future")
+	}
+
+	next, err := nextRun(schedule, time.Now())
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = next
+
+	return nil
+}
+
+func (s *Scheduler) SetEnabled(id string, enabled bool) (*models.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.repo.GetSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		setup, err := s.repo.GetSetup(current.SetupID)
+		if err == nil && setup.Status == models.SetupStatusArchived {
+			return nil, fmt.Errorf("setup is archived")
+		}
+	}
+
+	var nextErr error
+	updated, _, err := s.repo.ModifySchedule(id, func(schedule *models.Schedule) bool {
+		schedule.Enabled = enabled
+		schedule.UpdatedAt = time.Now()
+
+		if enabled && schedule.Cron != "" {
+			if schedule.NextRunAt, nextErr = nextRun(schedule, time.Now()); nextErr != nil {
+				return false
+			}
+		}
+
+		if !enabled {
+			schedule.Queued = false
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if nextErr != nil {
+		return nil, nextErr
+	}
+
+	return updated, nil
+}
+
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.repo.DeleteSchedule(id)
+}
+
+// RemoveSetup stops the schedules of a setup that is being deleted. They are
+// disabled if the setup is archived and deleted along with it otherwise.
+func (s *Scheduler) RemoveSetup(setupID string, archived bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.repo.ListSchedules()
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		if schedule.SetupID != setupID {
+			continue
+		}
+
+		if !archived {
+			err = s.repo.DeleteSchedule(schedule.ID)
+		} else if schedule.Enabled {
+			_, _, err = s.repo.ModifySchedule(schedule.ID, func(schedule *models.Schedule) bool {
+				schedule.Enabled = false
+				schedule.Queued = false
+				schedule.UpdatedAt = time.Now()
+				return true
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("schedule %s: %w", schedule.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.repo.ListSchedules()
+	if err != nil {
+		s.logger.Error("list schedules failed", "error", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if schedule.Enabled {
+			s.process(ctx, schedule, now)
+		}
+	}
+}
+
+// process starts the queued and the due run of a schedule. Every instance
+// sharing the repository sees the same due schedule, so each run is claimed
+// first: only the instance whose claim finds the schedule unchanged starts it.
+func (s *Scheduler) process(ctx context.Context, schedule *models.Schedule, now time.Time) {
+	if schedule.Queued && !s.runActive(schedule.LastRunID) {
+		claimed, ok := s.claim(schedule, now, func(claimed *models.Schedule) bool {
+			claimed.Queued = false
+			return true
+		})
+		if !ok {
+			return
+		}
+		schedule = s.start(ctx, claimed, now)
+	}
+
+	if schedule.NextRunAt.IsZero() || now.Before(schedule.NextRunAt) {
+		return
+	}
+
+	active := s.runActive(schedule.LastRunID)
+	start := false
+
+	claimed, ok := s.claim(schedule, now, func(claimed *models.Schedule) bool {
+		switch {
+		case !active || claimed.Overlap == models.OverlapAllow:
+			start = true
+		case claimed.Overlap == models.OverlapQueue && !claimed.Queued:
+			claimed.Queued = true
+			record(claimed, models.ScheduleEntry{Time: now, Outcome: models.ScheduleOutcomeQueued})
+		default:
+			record(claimed, models.ScheduleEntry{Time: now, Outcome: models.ScheduleOutcomeSkipped})
+		}
+
+		if claimed.Cron == "" {
+			claimed.NextRunAt = time.Time{}
+			return true
+		}
+
+		next, err := nextRun(claimed, now)
+		if err != nil {
+			s.logger.Error("schedule has no next run", "schedule_id", claimed.ID, "error", err)
+		}
+		claimed.NextRunAt = next
+		return true
+	})
+
+	if ok && start {
+		s.start(ctx, claimed, now)
+	}
+}
+
+// claim applies fn to the stored schedule if it is still enabled, queued and
+// due as it was when it was read, and reports whether the change was stored.
+func (s *Scheduler) claim(read *models.Schedule, now time.Time, fn func(schedule *models.Schedule) bool) (*models.Schedule, bool) {
+	claimed, ok, err := s.repo.ModifySchedule(read.ID, func(stored *models.Schedule) bool {
+		if !stored.Enabled || stored.Queued != read.Queued || !stored.NextRunAt.Equal(read.NextRunAt) {
+			return false
+		}
+
+		if !fn(stored) {
+			return false
+		}
+
+		stored.UpdatedAt = now
+		return true
+	})
+	if err != nil {
+		s.logger.Error("update schedule failed", "schedule_id", read.ID, "error", err)
+		return nil, false
+	}
+
+	return claimed, ok
+}
+
+// start starts a run for a claimed schedule, records the outcome and returns
+// the updated schedule.
+func (s *Scheduler) start(ctx context.Context, schedule *models.Schedule, now time.Time) *models.Schedule {
+	entry := models.ScheduleEntry{Time: now, Outcome: models.ScheduleOutcomeStarted}
+
+	run, err := s.runner.StartRun(ctx, schedule.SetupID, runner.StartOptions{})
+	if err != nil {
+		s.logger.Error("scheduled run failed to start", "schedule_id", schedule.ID, "error", err)
+		entry = models.ScheduleEntry{Time: now, Outcome: models.ScheduleOutcomeFailed, Error: err.Error()}
+	} else {
+		s.logger.Info("scheduled run started", "schedule_id", schedule.ID, "run_id", run.ID)
+		entry.RunID = run.ID
+	}
+
+	updated, _, err := s.repo.ModifySchedule(schedule.ID, func(stored *models.Schedule) bool {
+		if run != nil {
+			stored.LastRunID = run.ID
+		}
+		record(stored, entry)
+		stored.UpdatedAt = now
+		return true
+	})
+	if err != nil {
+		s.logger.Error("update schedule failed", "schedule_id", schedule.ID, "error", err)
+		return schedule
+	}
+
+	return updated
+}
+
+func (s *Scheduler) runActive(runID string) bool {
+	if runID == "" {
+		return false
+	}
+
+	run, err := s.repo.GetRun(runID)
+	if err != nil {
+		return false
+	}
+
+	return !storage.Finished(run)
+}
+
+func record(schedule *models.Schedule, entry models.ScheduleEntry) {
+	schedule.History = append(schedule.History, entry)
+	if len(schedule.History) > maxScheduleHistory {
+		schedule.History = schedule.History[len(schedule.History)-maxScheduleHistory:]
+	}
+}
+
+func nextRun(schedule *models.Schedule, after time.Time) (time.Time, error) {
+	if schedule.Cron == "" {
+		return schedule.At, nil
+	}
+
+	loc := time.UTC
+	if schedule.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(schedule.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %q", schedule.Timezone)
+		}
+	}
+
+	cron, err := parseCron(schedule.Cron)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron: %w", err)
+	}
+
+	next, ok := cron.next(after.In(loc))
+	if !ok {
+		return time.Time{}, fmt.Errorf("cron expression %q never matches", schedule.Cron)
+	}
+
+	return next, nil
+}
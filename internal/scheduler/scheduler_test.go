@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/runner"
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/bdtfs/gnat/internal/storage/memory"
+)
+
+// fakeRunner stores the runs it starts as running, or fails with err.
+type fakeRunner struct {
+	repo    storage.Repository
+	err     error
+	started []string
+}
+
+func (r *fakeRunner) StartRun(_ context.Context, setupID string, _ runner.StartOptions) (*models.Run, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	run := models.NewRun(setupID)
+	run.Status = models.RunStatusRunning
+	r.started = append(r.started, run.ID)
+	return run, r.repo.CreateRun(run)
+}
+
+func TestProcess(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC)
+	due := now.Truncate(time.Minute)
+	nextMinute := due.Add(time.Minute)
+
+	tests := []struct {
+		name    string
+		overlap models.OverlapPolicy
+		// at makes a one-time schedule instead of one that runs every minute.
+		at time.Time
+		// previous is the status of the last run of the schedule, if any.
+		previous models.RunStatus
+		queued   bool
+		next     time.Time
+		// stale reads the schedule as another instance left it before
+		// claiming it.
+		stale    bool
+		startErr error
+
+		wantStarted  bool
+		wantOutcomes []models.ScheduleOutcome
+		wantQueued   bool
+		wantNext     time.Time
+	}{
+		{name: "not due", overlap: models.OverlapSkip, next: nextMinute,
+			wantNext: nextMinute},
+		{name: "skip starts when idle", overlap: models.OverlapSkip, next: due,
+			wantStarted: true, wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeStarted}, wantNext: nextMinute},
+		{name: "skip starts after a finished run", overlap: models.OverlapSkip, previous: models.RunStatusCompleted, next: due,
+			wantStarted: true, wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeStarted}, wantNext: nextMinute},
+		{name: "skip skips while a run is active", overlap: models.OverlapSkip, previous: models.RunStatusRunning, next: due,
+			wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeSkipped}, wantNext: nextMinute},
+		{name: "queue queues while a run is active", overlap: models.OverlapQueue, previous: models.RunStatusRunning, next: due,
+			wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeQueued}, wantQueued: true, wantNext: nextMinute},
+		{name: "queue skips when a run is already queued", overlap: models.OverlapQueue, previous: models.RunStatusPaused, queued: true, next: due,
+			wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeSkipped}, wantQueued: true, wantNext: nextMinute},
+		{name: "queued run starts once the active one finished", overlap: models.OverlapQueue, previous: models.RunStatusCompleted, queued: true, next: nextMinute,
+			wantStarted: true, wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeStarted}, wantNext: nextMinute},
+		{name: "queued run starts and the due run queues behind it", overlap: models.OverlapQueue, previous: models.RunStatusCompleted, queued: true, next: due,
+			wantStarted: true, wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeStarted, models.ScheduleOutcomeQueued}, wantQueued: true, wantNext: nextMinute},
+		{name: "allow starts while a run is active", overlap: models.OverlapAllow, previous: models.RunStatusRunning, next: due,
+			wantStarted: true, wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeStarted}, wantNext: nextMinute},
+		{name: "one-time schedule runs once", overlap: models.OverlapSkip, at: due, next: due,
+			wantStarted: true, wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeStarted}},
+		{name: "failed start is recorded", overlap: models.OverlapSkip, next: due, startErr: errors.New("setup is archived"),
+			wantOutcomes: []models.ScheduleOutcome{models.ScheduleOutcomeFailed}, wantNext: nextMinute},
+		{name: "claimed by another instance", overlap: models.OverlapAllow, next: due, stale: true,
+			wantNext: nextMinute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := memory.New()
+			starter := &fakeRunner{repo: repo, err: tt.startErr}
+			s := &Scheduler{repo: repo, runner: starter, logger: slog.New(slog.DiscardHandler)}
+
+			schedule := &models.Schedule{
+				ID:        "schedule",
+				SetupID:   "setup",
+				Cron:      "* * * * *",
+				At:        tt.at,
+				Overlap:   tt.overlap,
+				Enabled:   true,
+				NextRunAt: tt.next,
+				Queued:    tt.queued,
+			}
+			if !tt.at.IsZero() {
+				schedule.Cron = ""
+			}
+
+			if tt.previous != "" {
+				run := models.NewRun("setup")
+				run.Status = tt.previous
+				if err := repo.CreateRun(run); err != nil {
+					t.Fatalf("CreateRun: %v", err)
+				}
+				schedule.LastRunID = run.ID
+			}
+
+			if err := repo.CreateSchedule(schedule); err != nil {
+				t.Fatalf("CreateSchedule: %v", err)
+			}
+
+			read := schedule
+			if tt.stale {
+				// Another instance started the due run, which moved the
+				// schedule on to its next run.
+				stored := *schedule
+				stored.NextRunAt = nextMinute
+				if err := repo.UpdateSchedule(&stored); err != nil {
+					t.Fatalf("UpdateSchedule: %v", err)
+				}
+			}
+
+			s.process(context.Background(), read, now)
+
+			got, err := repo.GetSchedule(schedule.ID)
+			if err != nil {
+				t.Fatalf("GetSchedule: %v", err)
+			}
+
+			var outcomes []models.ScheduleOutcome
+			for _, entry := range got.History {
+				outcomes = append(outcomes, entry.Outcome)
+			}
+
+			if !slices.Equal(outcomes, tt.wantOutcomes) {
+				t.Fatalf("outcomes are %v, want %v", outcomes, tt.wantOutcomes)
+			}
+			if got.Queued != tt.wantQueued {
+				t.Fatalf("queued is %v, want %v", got.Queued, tt.wantQueued)
+			}
+			if !got.NextRunAt.Equal(tt.wantNext) {
+				t.Fatalf("next run is %v, want %v", got.NextRunAt, tt.wantNext)
+			}
+
+			if started := len(starter.started) > 0; started != tt.wantStarted {
+				t.Fatalf("started runs %v, want a run: %v", starter.started, tt.wantStarted)
+			}
+			if tt.wantStarted && got.LastRunID != starter.started[len(starter.started)-1] {
+				t.Fatalf("last run is %q, want %q", got.LastRunID, starter.started[len(starter.started)-1])
+			}
+		})
+	}
+}
@@ -1,48 +1,296 @@
 package dto
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Setup struct {
-	ID          string                 `json:"id"`
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	Method      string                 `json:"method"`
-	URL         string                 `json:"url"`
-	Body        []byte                 `json:"body,omitempty"`
-	Headers     map[string]string      `json:"headers,omitempty"`
-	RPS         int                    `json:"rps"`
-	Duration    time.Duration          `json:"duration"`
-	Status      string                 `json:"status"`
-	HTTPConfig  map[string]interface{} `json:"http_config,omitempty"`
-	CreatedAt   time.Time              `json:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at"`
+	ID                 string                 `json:"id"`
+	Version            int                    `json:"version"`
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description"`
+	Executor           string                 `json:"executor"`
+	ExecutorConfig     map[string]interface{} `json:"executor_config,omitempty"`
+	Method             string                 `json:"method"`
+	URL                string                 `json:"url"`
+	Body               []byte                 `json:"body,omitempty"`
+	Payload            *Payload               `json:"payload,omitempty"`
+	Headers            map[string]string      `json:"headers,omitempty"`
+	RPS                int                    `json:"rps"`
+	Duration           time.Duration          `json:"duration"`
+	Status             string                 `json:"status"`
+	HTTPConfig         map[string]interface{} `json:"http_config,omitempty"`
+	Expect             string                 `json:"expect,omitempty"`
+	Connection         string                 `json:"connection,omitempty"`
+	MaxRequestsPerConn int                    `json:"max_requests_per_conn,omitempty"`
+	SourceAddrs        []string               `json:"source_addrs,omitempty"`
+	Resolve            map[string]string      `json:"resolve,omitempty"`
+	DNSServer          string                 `json:"dns_server,omitempty"`
+	DNSCacheTTL        time.Duration          `json:"dns_cache_ttl,omitempty"`
+	IPFamily           string                 `json:"ip_family,omitempty"`
+	Retry              *RetryPolicy           `json:"retry,omitempty"`
+	RestartInterrupted bool                   `json:"restart_interrupted,omitempty"`
+	CreatedAt          time.Time              `json:"created_at"`
+	UpdatedAt          time.Time              `json:"updated_at"`
+}
+
+type RetryPolicy struct {
+	MaxAttempts int      `json:"max_attempts" openapi:"required,min=1,max=10"`
+	Statuses    []int    `json:"statuses,omitempty" openapi:"min=400,max=599"`
+	Errors      []string `json:"errors,omitempty" openapi:"enum=connection|timeout|any"`
+	Backoff     string   `json:"backoff,omitempty" openapi:"format=duration"`
+	MaxBackoff  string   `json:"max_backoff,omitempty" openapi:"format=duration"`
+	Jitter      float64  `json:"jitter,omitempty" openapi:"min=0,max=1"`
+}
+
+type Payload struct {
+	Mode        string            `json:"mode" openapi:"enum=raw|text|json|form|multipart|file|random"`
+	ContentType string            `json:"content_type,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	JSON        json.RawMessage   `json:"json,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Files       []*PayloadFile    `json:"files,omitempty"`
+	Size        int               `json:"size,omitempty"`
+}
+
+type PayloadFile struct {
+	Field       string `json:"field,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+type SetupVersion struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Changes are relative to the previous version.
+	Changes []FieldChange `json:"changes"`
+	Setup   *Setup        `json:"setup"`
+}
+
+type SetupDiff struct {
+	SetupID string        `json:"setup_id"`
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is a setup field that differs between two versions. Nested
+// fields are named by their path, such as "retry.max_attempts". From or To is
+// missing when the field is only set in one of the versions.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from,omitempty"`
+	To    any    `json:"to,omitempty"`
 }
 
 type Run struct {
-	ID        string     `json:"id"`
-	SetupID   string     `json:"setup_id"`
-	Status    string     `json:"status"`
-	StartedAt time.Time  `json:"started_at"`
-	Elapsed   string     `json:"elapsed"`
-	EndedAt   *time.Time `json:"ended_at,omitempty"`
-	Error     string     `json:"error,omitempty"`
-	Stats     *Stats     `json:"stats"`
+	ID           string            `json:"id"`
+	SetupID      string            `json:"setup_id"`
+	SetupVersion int               `json:"setup_version,omitempty"`
+	SetupName    string            `json:"setup_name,omitempty"`
+	Status       string            `json:"status"`
+	RPS          int               `json:"rps"`
+	Priority     int               `json:"priority"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Notes        string            `json:"notes,omitempty"`
+	QueuedAt     time.Time         `json:"queued_at"`
+	StartedAt    time.Time         `json:"started_at"`
+	Elapsed      string            `json:"elapsed"`
+	EndedAt      *time.Time        `json:"ended_at,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	RestartOf    string            `json:"restart_of,omitempty"`
+	CompactedAt  *time.Time        `json:"compacted_at,omitempty"`
+	Events       []RunEvent        `json:"events,omitempty"`
+	Stats        *Stats            `json:"stats"`
+}
+
+type Queue struct {
+	MaxConcurrentRuns int    `json:"max_concurrent_runs"`
+	MaxTotalRPS       int    `json:"max_total_rps"`
+	RunningRuns       int    `json:"running_runs"`
+	UsedRPS           int    `json:"used_rps"`
+	Pending           []*Run `json:"pending"`
+}
+
+type RunEvent struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	RPS       int       `json:"rps,omitempty"`
+	Message   string    `json:"message,omitempty"`
 }
 
 type Stats struct {
-	Total       uint64         `json:"total"`
-	Success     uint64         `json:"success"`
-	Failed      uint64         `json:"failed"`
-	AvgLatency  float64        `json:"avg_latency_ms"`
-	MinLatency  float64        `json:"min_latency_ms"`
-	MaxLatency  float64        `json:"max_latency_ms"`
-	P50Latency  float64        `json:"p50_latency_ms"`
-	P90Latency  float64        `json:"p90_latency_ms"`
-	P95Latency  float64        `json:"p95_latency_ms"`
-	P99Latency  float64        `json:"p99_latency_ms"`
-	SuccessRate float64        `json:"success_rate"`
-	RPS         float64        `json:"rps"`
-	BytesRead   uint64         `json:"bytes_read"`
-	StatusCodes map[int]uint64 `json:"status_codes"`
-	Errors      []string       `json:"errors,omitempty"`
+	Total             uint64         `json:"total"`
+	Success           uint64         `json:"success"`
+	Failed            uint64         `json:"failed"`
+	Cancelled         uint64         `json:"cancelled"`
+	AvgLatency        float64        `json:"avg_latency_ms"`
+	MinLatency        float64        `json:"min_latency_ms"`
+	MaxLatency        float64        `json:"max_latency_ms"`
+	P50Latency        float64        `json:"p50_latency_ms"`
+	P90Latency        float64        `json:"p90_latency_ms"`
+	P95Latency        float64        `json:"p95_latency_ms"`
+	P99Latency        float64        `json:"p99_latency_ms"`
+	SuccessRate       float64        `json:"success_rate"`
+	FirstAttemptRate  float64        `json:"first_attempt_success_rate"`
+	Attempts          uint64         `json:"attempts"`
+	Retries           uint64         `json:"retries"`
+	RPS               float64        `json:"rps"`
+	BytesRead         uint64         `json:"bytes_read"`
+	ConnectionsOpened uint64         `json:"connections_opened"`
+	ConnectionErrors  uint64         `json:"connection_errors"`
+	AvgConnectLatency float64        `json:"avg_connect_latency_ms"`
+	AvgHandshake      float64        `json:"avg_tls_handshake_ms"`
+	StatusCodes       map[int]uint64 `json:"status_codes"`
+	Errors            []string       `json:"errors,omitempty"`
+
+	Operations map[string]*OperationStats `json:"operations,omitempty"`
+	Sources    map[string]*SourceStats    `json:"sources,omitempty"`
+	RemoteIPs  map[string]uint64          `json:"remote_ips,omitempty"`
+}
+
+type SourceStats struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+}
+
+type OperationStats struct {
+	Total       uint64  `json:"total"`
+	Success     uint64  `json:"success"`
+	Failed      uint64  `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+	AvgLatency  float64 `json:"avg_latency_ms"`
+}
+
+type Sample struct {
+	Reason        string              `json:"reason"`
+	Timestamp     time.Time           `json:"timestamp"`
+	LatencyMs     float64             `json:"latency_ms"`
+	Request       SampleRequest       `json:"request"`
+	StatusCode    int                 `json:"status_code,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Body          string              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+type SampleRequest struct {
+	Method        string              `json:"method,omitempty"`
+	URL           string              `json:"url"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Body          string              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+}
+
+type Schedule struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	SetupID   string          `json:"setup_id"`
+	At        *time.Time      `json:"at,omitempty"`
+	Cron      string          `json:"cron,omitempty"`
+	Timezone  string          `json:"timezone,omitempty"`
+	Overlap   string          `json:"overlap"`
+	Enabled   bool            `json:"enabled"`
+	NextRunAt *time.Time      `json:"next_run_at,omitempty"`
+	Queued    bool            `json:"queued"`
+	LastRunID string          `json:"last_run_id,omitempty"`
+	History   []ScheduleEntry `json:"history"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type ScheduleEntry struct {
+	Time    time.Time `json:"time"`
+	Outcome string    `json:"outcome"`
+	RunID   string    `json:"run_id,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type Comparison struct {
+	Base         *Run              `json:"base"`
+	Candidate    *Run              `json:"candidate"`
+	Tolerance    float64           `json:"tolerance"`
+	Alpha        float64           `json:"alpha"`
+	Verdict      string            `json:"verdict"`
+	Regressions  []string          `json:"regressions"`
+	Significance Significance      `json:"significance"`
+	Metrics      []MetricDelta     `json:"metrics"`
+	Percentiles  []PercentileDelta `json:"percentiles"`
+}
+
+type MetricDelta struct {
+	Name        string   `json:"name"`
+	Direction   string   `json:"direction"`
+	Base        float64  `json:"base"`
+	Candidate   float64  `json:"candidate"`
+	Delta       float64  `json:"delta"`
+	DeltaPct    *float64 `json:"delta_pct"`
+	Regression  bool     `json:"regression"`
+	Improvement bool     `json:"improvement"`
+}
+
+type PercentileDelta struct {
+	Percentile  float64  `json:"percentile"`
+	Base        float64  `json:"base_ms"`
+	Candidate   float64  `json:"candidate_ms"`
+	Delta       float64  `json:"delta_ms"`
+	DeltaPct    *float64 `json:"delta_pct"`
+	Regression  bool     `json:"regression"`
+	Improvement bool     `json:"improvement"`
+}
+
+type Significance struct {
+	Test        string  `json:"test"`
+	Statistic   float64 `json:"statistic"`
+	PValue      float64 `json:"p_value"`
+	Significant bool    `json:"significant"`
+}
+
+// Archive is the export format. Runs are kept in their stored form, with
+// their latency histograms and samples.
+type Archive struct {
+	Version       int                `json:"version" openapi:"required"`
+	ExportedAt    time.Time          `json:"exported_at"`
+	Setups        []*Setup           `json:"setups"`
+	SetupVersions []*Setup           `json:"setup_versions,omitempty"`
+	Schedules     []*ArchiveSchedule `json:"schedules"`
+	Runs          []json.RawMessage  `json:"runs,omitempty"`
+}
+
+type ArchiveSchedule struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	SetupID  string     `json:"setup_id"`
+	At       *time.Time `json:"at,omitempty"`
+	Cron     string     `json:"cron,omitempty"`
+	Timezone string     `json:"timezone,omitempty"`
+	Overlap  string     `json:"overlap"`
+	Enabled  bool       `json:"enabled"`
+}
+
+type ImportReport struct {
+	Setups    []ImportResult `json:"setups"`
+	Schedules []ImportResult `json:"schedules"`
+	Runs      []ImportResult `json:"runs"`
+}
+
+type ImportResult struct {
+	ID     string `json:"id"`
+	NewID  string `json:"new_id,omitempty"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Error is the body of error responses. Fields lists the values of a request
+// body that do not match the OpenAPI document.
+type Error struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
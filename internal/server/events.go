@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/converters"
+	"github.com/bdtfs/gnat/internal/service"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+// runSnapshot is the state of a run sent to the clients watching it.
+type runSnapshot struct {
+	// events holds the encoded events of the run, in order, and types their
+	// types.
+	events [][]byte
+	types  []string
+	// stats is the encoded dto.Stats, or nil before the run started.
+	stats    []byte
+	finished bool
+}
+
+type runFeed struct {
+	latest      *runSnapshot
+	subscribers map[chan *runSnapshot]struct{}
+}
+
+// feeds polls the runs that clients watch. Each run is polled and encoded
+// once per interval, however many clients watch it. A snapshot includes every
+// event so far, so a client that is too slow only misses stats; its channel
+// holds the latest snapshot.
+type feeds struct {
+	service  *service.Service
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu     sync.Mutex
+	runs   map[string]*runFeed
+	closed bool
+}
+
+const defaultEventsInterval = time.Second
+
+func newFeeds(service *service.Service, logger *slog.Logger, interval time.Duration) *feeds {
+	if interval <= 0 {
+		interval = defaultEventsInterval
+	}
+
+	return &feeds{
+		service:  service,
+		logger:   logger,
+		interval: interval,
+		runs:     make(map[string]*runFeed),
+	}
+}
+
+// subscribe returns a channel that receives the snapshots of a run. It is
+// closed after the snapshot of the finished run, or when the feeds close.
+func (f *feeds) subscribe(runID string) (<-chan *runSnapshot, func()) {
+	ch := make(chan *runSnapshot, 1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	feed, ok := f.runs[runID]
+	if !ok {
+		feed = &runFeed{subscribers: make(map[chan *runSnapshot]struct{})}
+		f.runs[runID] = feed
+		go f.poll(runID, feed)
+	} else if feed.latest != nil {
+		ch <- feed.latest
+	}
+	feed.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		f.mu.Lock()
+		delete(feed.subscribers, ch)
+		f.mu.Unlock()
+	}
+}
+
+// close ends every feed.
+func (f *feeds) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for runID, feed := range f.runs {
+		f.stop(runID, feed)
+	}
+}
+
+func (f *feeds) poll(runID string, feed *runFeed) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		snap, err := f.snapshot(runID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			f.logger.Error("run snapshot failed", "run_id", runID, "error", err)
+		}
+
+		f.mu.Lock()
+		if f.runs[runID] != feed {
+			f.mu.Unlock()
+			return
+		}
+
+		if snap != nil {
+			feed.latest = snap
+			for ch := range feed.subscribers {
+				publish(ch, snap)
+			}
+		}
+
+		if snap == nil || snap.finished || len(feed.subscribers) == 0 {
+			f.stop(runID, feed)
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+
+		<-ticker.C
+	}
+}
+
+// stop closes the subscribers of a feed and removes it. f.mu must be held.
+func (f *feeds) stop(runID string, feed *runFeed) {
+	for ch := range feed.subscribers {
+		close(ch)
+		delete(feed.subscribers, ch)
+	}
+	delete(f.runs, runID)
+}
+
+func (f *feeds) snapshot(runID string) (*runSnapshot, error) {
+	run, err := f.service.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	d := converters.RunToDTO(run)
+	snap := &runSnapshot{finished: storage.Finished(run)}
+
+	for _, e := range d.Events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		snap.events = append(snap.events, data)
+		snap.types = append(snap.types, e.Type)
+	}
+
+	if d.Stats != nil {
+		if snap.stats, err = json.Marshal(d.Stats); err != nil {
+			return nil, err
+		}
+	}
+
+	return snap, nil
+}
+
+// publish replaces the snapshot waiting in ch, if any, with snap.
+func publish(ch chan *runSnapshot, snap *runSnapshot) {
+	select {
+	case ch <- snap:
+		return
+	default:
+	}
+
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- snap
+}
@@ -0,0 +1,274 @@
+// Package openapi builds an OpenAPI document from Go types and validates
+// request bodies against it.
+//
+// Schemas follow the json tags of struct fields. The openapi tag adds
+// constraints, separated by commas:
+//
+//	required        the field must be present and not empty
+//	enum=a|b        the value must be one of the listed strings
+//	min=n, max=n    bounds of a number
+//	format=name     a string format: duration, date-time or byte
+//
+// Structs are closed: fields that are not part of the schema are refused.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem holds the operations of a path by lowercase method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is the subset of JSON Schema the API uses.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	OneOf       []*Schema          `json:"oneOf,omitempty"`
+	// AdditionalProperties is the schema of the values of a map, or false for
+	// a struct.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+	// Nullable allows null besides the values of the schema. It is written
+	// the JSON Schema way, as a type list with "null".
+	Nullable bool `json:"-"`
+}
+
+// MarshalJSON writes a nullable schema with "null" added to its type, or for a
+// reference, as one of the referenced schema and null.
+func (s Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	switch {
+	case !s.Nullable || (s.Type == "" && s.Ref == ""):
+		return json.Marshal(plain(s))
+	case s.Ref != "":
+		return json.Marshal(Schema{OneOf: []*Schema{{Ref: s.Ref}, {Type: "null"}}, Description: s.Description})
+	default:
+		return json.Marshal(struct {
+			plain
+			Type []string `json:"type"`
+		}{plain: plain(s), Type: []string{s.Type, "null"}})
+	}
+}
+
+// Generator builds schemas from Go types. Named structs become components
+// that other schemas refer to.
+type Generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+// Components returns the schemas of the named structs seen so far.
+func (g *Generator) Components() Components {
+	return Components{Schemas: g.schemas}
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+	rawType      = reflect.TypeFor[json.RawMessage]()
+)
+
+// Schema returns the schema of values of type t. Pointers, slices and maps
+// are nullable, as they decode from null.
+func (g *Generator) Schema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		schema := g.Schema(t.Elem())
+		schema.Nullable = true
+		return schema
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Description: "nanoseconds"}
+	case rawType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: new(float64)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: t.Kind() == reflect.Slice}
+		}
+		return &Schema{Type: "array", Items: g.Schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.Schema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		return &Schema{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return g.fields(t)
+	}
+
+	name, ok := g.names[t]
+	if !ok {
+		name = componentName(t.Name())
+		g.names[t] = name
+		// The entry is set before the fields, so that recursive types
+		// refer to it.
+		g.schemas[name] = &Schema{}
+		*g.schemas[name] = *g.fields(t)
+	}
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *Generator) fields(t reflect.Type) *Schema {
+	out := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := g.Schema(field.Type)
+		if g.constrain(schema, field.Tag.Get("openapi")) {
+			out.Required = append(out.Required, name)
+		}
+		out.Properties[name] = schema
+	}
+
+	return out
+}
+
+// constrain applies the constraints of an openapi tag to schema and reports
+// whether the field is required. The constraints of an array apply to its
+// items.
+func (g *Generator) constrain(schema *Schema, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	if schema.Type == "array" {
+		return g.constrain(schema.Items, tag)
+	}
+
+	required := false
+	for _, c := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(c, "=")
+		switch key {
+		case "required":
+			required = true
+		case "enum":
+			schema.Enum = strings.Split(value, "|")
+		case "min":
+			schema.Minimum = parseFloat(value)
+		case "max":
+			schema.Maximum = parseFloat(value)
+		case "format":
+			schema.Format = value
+		}
+	}
+
+	return required
+}
+
+func parseFloat(s string) *float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		panic("openapi: invalid number " + s)
+	}
+	return &f
+}
+
+func componentName(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
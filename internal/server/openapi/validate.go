@@ -0,0 +1,162 @@
+package openapi
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FieldError is a value of a request body that does not match its schema.
+// Field is the path of the value, such as "retry.statuses[1]", and empty for
+// the body itself.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Validate checks a decoded JSON value against schema, resolving references
+// in components. Null is only a value of nullable schemas; a null or empty
+// string required field counts as missing.
+func (c Components) Validate(schema *Schema, v any) []FieldError {
+	var errs []FieldError
+	c.validate(schema, v, "", &errs)
+
+	slices.SortStableFunc(errs, func(a, b FieldError) int {
+		return cmp.Compare(a.Field, b.Field)
+	})
+
+	return errs
+}
+
+func (c Components) validate(schema *Schema, v any, path string, errs *[]FieldError) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if v == nil {
+		if !schema.Nullable && (schema.Type != "" || schema.Ref != "") {
+			fail("must not be null")
+		}
+		return
+	}
+
+	if schema.Ref != "" {
+		schema = c.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+
+	switch schema.Type {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be a boolean")
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (schema.Type == "integer" && n != math.Trunc(n)) {
+			fail("must be %s", article(schema.Type))
+			return
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			fail("must be at most %v", *schema.Maximum)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if s == "" {
+			return
+		}
+		if schema.Enum != nil && !slices.Contains(schema.Enum, s) {
+			fail("must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+		if err := checkFormat(schema.Format, s); err != "" {
+			fail("%s", err)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			c.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		c.validateObject(schema, obj, path, errs)
+	}
+}
+
+func (c Components) validateObject(schema *Schema, obj map[string]any, path string, errs *[]FieldError) {
+	for _, name := range schema.Required {
+		if value := obj[name]; value == nil || value == "" {
+			*errs = append(*errs, FieldError{Field: join(path, name), Message: "is required"})
+		}
+	}
+
+	for name, value := range obj {
+		if value == nil && slices.Contains(schema.Required, name) {
+			// Reported as missing above.
+			continue
+		}
+
+		if property, ok := schema.Properties[name]; ok {
+			c.validate(property, value, join(path, name), errs)
+			continue
+		}
+
+		switch additional := schema.AdditionalProperties.(type) {
+		case *Schema:
+			c.validate(additional, value, join(path, name), errs)
+		case bool:
+			if !additional {
+				*errs = append(*errs, FieldError{Field: join(path, name), Message: "is not a known field"})
+			}
+		}
+	}
+}
+
+func checkFormat(format, s string) string {
+	var err error
+	switch format {
+	case "duration":
+		if _, err = time.ParseDuration(s); err != nil {
+			return `must be a duration, such as "1m30s"`
+		}
+	case "date-time":
+		if _, err = time.Parse(time.RFC3339, s); err != nil {
+			return "must be an RFC 3339 date and time"
+		}
+	case "byte":
+		if _, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return "must be base64 encoded"
+		}
+	}
+	return ""
+}
+
+func article(typ string) string {
+	if typ == "integer" {
+		return "an integer"
+	}
+	return "a number"
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
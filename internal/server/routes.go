@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bdtfs/gnat/internal/server/dto"
+	"github.com/bdtfs/gnat/internal/server/openapi"
+)
+
+// maxBodySize is the largest request body the server reads. It fits a setup
+// with the largest file payload the web UI uploads, base64 encoded.
+const maxBodySize = 64 << 20
+
+// route is an endpoint of the API. The routes are both served and described
+// by the OpenAPI document, so the document cannot drift from the server.
+// A route has either a handler or a body, which builds the handler.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	summary string
+	params  []param
+	// body is the request body and its handler, or nil. bodyType is its
+	// content type, application/json by default.
+	body      *requestBody
+	bodyType  string
+	responses []response
+}
+
+// requestBody ties the type of a request body to the handler that takes it,
+// so that the schema a body is validated against is the schema of the type
+// the handler decodes. See jsonBody and rawBody.
+type requestBody struct {
+	typ reflect.Type
+	// fn is the handler the operation is named after, and bind builds the
+	// route handler from the schema of typ.
+	fn   any
+	bind func(s *Server, schema *openapi.Schema) http.HandlerFunc
+}
+
+// jsonBody is the body of a route whose handler takes the body decoded into a
+// T. The handler is only called with a body that matches the schema of T.
+func jsonBody[T any](h func(w http.ResponseWriter, r *http.Request, req *T)) *requestBody {
+	return &requestBody{
+		typ: reflect.TypeFor[T](),
+		fn:  h,
+		bind: func(s *Server, schema *openapi.Schema) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				data, ok := readBody(w, r)
+				if !ok {
+					return
+				}
+
+				var req T
+				if s.decodeJSON(w, data, schema, &req) {
+					h(w, r, &req)
+				}
+			}
+		},
+	}
+}
+
+// rawBody is the body of a route whose handler reads the body itself, such as
+// to merge a patch, and then decodes a T with decode.
+func rawBody[T any](h func(w http.ResponseWriter, r *http.Request, decode func(data []byte) (*T, bool))) *requestBody {
+	return &requestBody{
+		typ: reflect.TypeFor[T](),
+		fn:  h,
+		bind: func(s *Server, schema *openapi.Schema) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				h(w, r, func(data []byte) (*T, bool) {
+					var req T
+					return &req, s.decodeJSON(w, data, schema, &req)
+				})
+			}
+		},
+	}
+}
+
+type param struct {
+	in          string
+	name        string
+	description string
+}
+
+type response struct {
+	status int
+	// body is a value of the type of the response body, or nil. oneOf lists
+	// the types of a body that has several forms.
+	body        any
+	contentType string
+}
+
+type oneOf []any
+
+func query(name, description string) param {
+	return param{in: "query", name: name, description: description}
+}
+
+func header(name, description string) param {
+	return param{in: "header", name: name, description: description}
+}
+
+var pageParams = []param{
+	query("sort", "created_at, status or name"),
+	query("order", "asc or desc"),
+	query("limit", "items per page, at most 1000; default 100"),
+	query("cursor", "X-Next-Cursor of the previous page"),
+}
+
+func (s *Server) routes() []route {
+	return []route{
+		{method: http.MethodPost, path: "/api/setups", body: jsonBody(s.handleCreateSetup), summary: "Create a setup",
+			responses: []response{{status: http.StatusCreated, body: dto.Setup{}}}},
+		{method: http.MethodGet, path: "/api/setups", handler: s.handleListSetups, summary: "List setups",
+			params: append([]param{
+				query("status", "comma separated statuses; archived setups are only listed when asked for"),
+				query("q", "part of the name"),
+				query("from", "created at or after, RFC 3339"),
+				query("to", "created before, RFC 3339"),
+			}, pageParams...),
+			responses: []response{{status: http.StatusOK, body: []dto.Setup{}}}},
+		{method: http.MethodGet, path: "/api/setups/{id}", handler: s.handleGetSetup, summary: "Get a setup",
+			responses: []response{{status: http.StatusOK, body: dto.Setup{}}}},
+		{method: http.MethodPut, path: "/api/setups/{id}", body: jsonBody(s.handleReplaceSetup), summary: "Replace the configuration of a setup",
+			params:    []param{header("If-Match", "ETag of the version the change is based on")},
+			responses: []response{{status: http.StatusOK, body: dto.Setup{}}}},
+		{method: http.MethodPatch, path: "/api/setups/{id}", body: rawBody(s.handlePatchSetup), summary: "Apply a JSON merge patch to the configuration of a setup",
+			params:    []param{header("If-Match", "ETag of the version the patch applies to")},
+			bodyType:  "application/merge-patch+json",
+			responses: []response{{status: http.StatusOK, body: dto.Setup{}}}},
+		{method: http.MethodPost, path: "/api/setups/{id}/activate", handler: s.handleActivateSetup, summary: "Activate a setup",
+			responses: []response{{status: http.StatusOK, body: dto.Setup{}}}},
+		{method: http.MethodPost, path: "/api/setups/{id}/deactivate", handler: s.handleDeactivateSetup, summary: "Deactivate a setup",
+			responses: []response{{status: http.StatusOK, body: dto.Setup{}}}},
+		{method: http.MethodGet, path: "/api/setups/{id}/versions", handler: s.handleListSetupVersions, summary: "List the versions of a setup, or diff two of them",
+			params: []param{
+				query("from", "version to diff from"),
+				query("to", "version to diff to"),
+			},
+			responses: []response{{status: http.StatusOK, body: oneOf{[]dto.SetupVersion{}, dto.SetupDiff{}}}}},
+		{method: http.MethodGet, path: "/api/setups/{id}/versions/{version}", handler: s.handleGetSetupVersion, summary: "Get a version of a setup",
+			responses: []response{{status: http.StatusOK, body: dto.SetupVersion{}}}},
+		{method: http.MethodDelete, path: "/api/setups/{id}", handler: s.handleDeleteSetup, summary: "Delete a setup, or archive it if it has runs",
+			params: []param{query("purge", "also delete the runs of the setup")},
+			responses: []response{
+				{status: http.StatusOK, body: dto.Setup{}},
+				{status: http.StatusNoContent},
+			}},
+
+		{method: http.MethodGet, path: "/api/executors", handler: s.handleListExecutors, summary: "List executors",
+			responses: []response{{status: http.StatusOK, body: []string{}}}},
+
+		{method: http.MethodPost, path: "/api/schedules", body: jsonBody(s.handleCreateSchedule), summary: "Create a schedule",
+			responses: []response{{status: http.StatusCreated, body: dto.Schedule{}}}},
+		{method: http.MethodGet, path: "/api/schedules", handler: s.handleListSchedules, summary: "List schedules",
+			responses: []response{{status: http.StatusOK, body: []dto.Schedule{}}}},
+		{method: http.MethodGet, path: "/api/schedules/{id}", handler: s.handleGetSchedule, summary: "Get a schedule",
+			responses: []response{{status: http.StatusOK, body: dto.Schedule{}}}},
+		{method: http.MethodPatch, path: "/api/schedules/{id}", body: jsonBody(s.handleUpdateSchedule), summary: "Enable or disable a schedule",
+			responses: []response{{status: http.StatusOK, body: dto.Schedule{}}}},
+		{method: http.MethodDelete, path: "/api/schedules/{id}", handler: s.handleDeleteSchedule, summary: "Delete a schedule",
+			responses: []response{{status: http.StatusNoContent}}},
+
+		{method: http.MethodPost, path: "/api/runs", body: jsonBody(s.handleStartRun), summary: "Start a run",
+			responses: []response{{status: http.StatusCreated, body: dto.Run{}}}},
+		{method: http.MethodGet, path: "/api/runs", handler: s.handleListRuns, summary: "List runs",
+			params: append([]param{
+				query("setup_id", "setup of the runs"),
+				query("status", "comma separated statuses"),
+				query("q", "part of the setup name"),
+				query("labels", "label selector, such as env=staging,team!=core"),
+				query("from", "started at or after, RFC 3339"),
+				query("to", "started before, RFC 3339"),
+			}, pageParams...),
+			responses: []response{{status: http.StatusOK, body: []dto.Run{}}}},
+		{method: http.MethodGet, path: "/api/runs/{id}", handler: s.handleGetRun, summary: "Get a run",
+			responses: []response{{status: http.StatusOK, body: dto.Run{}}}},
+		{method: http.MethodPatch, path: "/api/runs/{id}", body: jsonBody(s.handleUpdateRun), summary: "Change the rate of a run",
+			responses: []response{{status: http.StatusOK, body: dto.Run{}}}},
+		{method: http.MethodDelete, path: "/api/runs/{id}", handler: s.handleDeleteRun, summary: "Delete a finished run",
+			responses: []response{{status: http.StatusNoContent}}},
+		{method: http.MethodPost, path: "/api/runs/{id}/cancel", body: jsonBody(s.handleCancelRun), summary: "Cancel a run",
+			responses: []response{{status: http.StatusAccepted, body: dto.Run{}}}},
+		{method: http.MethodPost, path: "/api/runs/{id}/pause", handler: s.handlePauseRun, summary: "Pause a run",
+			responses: []response{{status: http.StatusOK, body: dto.Run{}}}},
+		{method: http.MethodPost, path: "/api/runs/{id}/resume", handler: s.handleResumeRun, summary: "Resume a paused run",
+			responses: []response{{status: http.StatusOK, body: dto.Run{}}}},
+		{method: http.MethodGet, path: "/api/runs/{id}/stats", handler: s.handleGetRunStats, summary: "Get the stats of a run",
+			responses: []response{{status: http.StatusOK, body: dto.Stats{}}}},
+		{method: http.MethodGet, path: "/api/runs/{id}/samples", handler: s.handleListRunSamples, summary: "List the sampled requests of a run",
+			params:    []param{query("reason", "failure, slow or random")},
+			responses: []response{{status: http.StatusOK, body: []dto.Sample{}}}},
+		{method: http.MethodGet, path: "/api/runs/{id}/events", handler: s.handleRunEvents, summary: "Stream the stats and events of a run",
+			responses: []response{{status: http.StatusOK, body: "", contentType: "text/event-stream"}}},
+
+		{method: http.MethodGet, path: "/api/queue", handler: s.handleGetQueue, summary: "Get the run queue",
+			responses: []response{{status: http.StatusOK, body: dto.Queue{}}}},
+		{method: http.MethodGet, path: "/api/compare", handler: s.handleCompareRuns, summary: "Compare two runs",
+			params: []param{
+				query("base", "run to compare against"),
+				query("candidate", "run to compare"),
+				query("tolerance", "change in percent a metric may move before it counts; default 5"),
+				query("alpha", "significance level of the latency test; default 0.05"),
+			},
+			responses: []response{{status: http.StatusOK, body: dto.Comparison{}}}},
+
+		{method: http.MethodGet, path: "/api/export", handler: s.handleExport, summary: "Export setups, schedules and optionally runs",
+			params:    []param{query("runs", "include finished runs")},
+			responses: []response{{status: http.StatusOK, body: dto.Archive{}}}},
+		{method: http.MethodPost, path: "/api/import", body: jsonBody(s.handleImport), summary: "Import an export",
+			params:    []param{query("conflict", "skip, overwrite or rename; default skip")},
+			responses: []response{{status: http.StatusOK, body: dto.ImportReport{}}}},
+
+		{method: http.MethodGet, path: "/api/openapi.json", handler: s.handleOpenAPI, summary: "Get this document",
+			responses: []response{{status: http.StatusOK, body: map[string]any{}}}},
+	}
+}
+
+// document describes routes, and builds the handlers of the routes with a
+// request body from the schema of the body. It panics on a route with both or
+// neither of a handler and a body, so that a route table the server cannot
+// serve fails at startup.
+func (s *Server) document(routes []route) *openapi.Document {
+	gen := openapi.NewGenerator()
+	errorSchema := gen.Schema(reflect.TypeFor[dto.Error]())
+
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info:    openapi.Info{Title: "gnat", Version: "1"},
+		Paths:   make(map[string]openapi.PathItem),
+	}
+
+	for i, rt := range routes {
+		if (rt.handler == nil) == (rt.body == nil) {
+			panic("server: route " + rt.method + " " + rt.path + " needs either a handler or a body")
+		}
+
+		var fn any = rt.handler
+		if rt.body != nil {
+			fn = rt.body.fn
+		}
+
+		op := &openapi.Operation{
+			OperationID: operationID(fn),
+			Summary:     rt.summary,
+			Responses: map[string]*openapi.Response{
+				"default": {
+					Description: "Error",
+					Content:     map[string]openapi.MediaType{"application/json": {Schema: errorSchema}},
+				},
+			},
+		}
+
+		for _, segment := range strings.Split(rt.path, "/") {
+			if name, ok := strings.CutPrefix(segment, "{"); ok {
+				op.Parameters = append(op.Parameters, openapi.Parameter{
+					Name:     strings.TrimSuffix(name, "}"),
+					In:       "path",
+					Required: true,
+					Schema:   &openapi.Schema{Type: "string"},
+				})
+			}
+		}
+
+		for _, p := range rt.params {
+			op.Parameters = append(op.Parameters, openapi.Parameter{
+				Name:        p.name,
+				In:          p.in,
+				Description: p.description,
+				Schema:      &openapi.Schema{Type: "string"},
+			})
+		}
+
+		if rt.body != nil {
+			schema := gen.Schema(rt.body.typ)
+			routes[i].handler = rt.body.bind(s, schema)
+
+			contentType := rt.bodyType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]openapi.MediaType{contentType: {Schema: schema}},
+			}
+		}
+
+		for _, resp := range rt.responses {
+			out := &openapi.Response{Description: http.StatusText(resp.status)}
+			if resp.body != nil {
+				contentType := resp.contentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				out.Content = map[string]openapi.MediaType{contentType: {Schema: responseSchema(gen, resp.body)}}
+			}
+			op.Responses[strconv.Itoa(resp.status)] = out
+		}
+
+		item, ok := doc.Paths[rt.path]
+		if !ok {
+			item = make(openapi.PathItem)
+			doc.Paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = op
+	}
+
+	doc.Components = gen.Components()
+	return doc
+}
+
+func responseSchema(gen *openapi.Generator, body any) *openapi.Schema {
+	forms, ok := body.(oneOf)
+	if !ok {
+		return gen.Schema(reflect.TypeOf(body))
+	}
+
+	out := &openapi.Schema{}
+	for _, form := range forms {
+		out.OneOf = append(out.OneOf, gen.Schema(reflect.TypeOf(form)))
+	}
+	return out
+}
+
+// operationID names an operation after its handler: handleCreateSetup
+// becomes createSetup.
+func operationID(handler any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndex(name, ".")+1:], "-fm")
+	name = strings.TrimPrefix(name, "handle")
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s.spec)
+}
+
+// readBody reads a request body of at most maxBodySize bytes. An empty body
+// is an empty object. It responds with an error and returns false if the body
+// cannot be read.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return nil, false
+	case err != nil:
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return nil, false
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+
+	return data, true
+}
+
+// decodeJSON decodes data into v after validating it against schema, the
+// schema of the type of v. The errors are sent field by field if it does not
+// match.
+func (s *Server) decodeJSON(w http.ResponseWriter, data []byte, schema *openapi.Schema, v any) bool {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+
+	if errs := s.schemas.Validate(schema, doc); len(errs) > 0 {
+		out := dto.Error{Error: "invalid request body", Fields: make([]dto.FieldError, len(errs))}
+		for i, e := range errs {
+			out.Fields[i] = dto.FieldError{Field: e.Field, Message: e.Message}
+		}
+		respondJSON(w, http.StatusBadRequest, out)
+		return false
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+
+	return true
+}
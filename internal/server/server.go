@@ -4,14 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bdtfs/gnat/internal/compare"
 	"github.com/bdtfs/gnat/internal/converters"
+	"github.com/bdtfs/gnat/internal/labels"
 	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/runner"
 	"github.com/bdtfs/gnat/internal/server/dto"
+	"github.com/bdtfs/gnat/internal/server/openapi"
 	"github.com/bdtfs/gnat/internal/service"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
 )
 
 type Server struct {
@@ -19,27 +34,37 @@ type Server struct {
 	service *service.Service
 	logger  *slog.Logger
 	server  *http.Server
+	feeds   *feeds
+
+	// spec is the encoded OpenAPI document, and schemas its components.
+	spec    []byte
+	schemas openapi.Components
 }
 
-func New(addr string, service *service.Service, logger *slog.Logger) *Server {
+// New creates a server. Clients watching a run get its stats every
+// eventsInterval.
+func New(addr string, service *service.Service, logger *slog.Logger, eventsInterval time.Duration) *Server {
 	s := &Server{
 		addr:    addr,
 		service: service,
 		logger:  logger,
+		feeds:   newFeeds(service, logger, eventsInterval),
 	}
 
-	mux := http.NewServeMux()
+	routes := s.routes()
+	doc := s.document(routes)
+	s.schemas = doc.Components
 
-	mux.HandleFunc("POST /api/setups", s.handleCreateSetup)
-	mux.HandleFunc("GET /api/setups", s.handleListSetups)
-	mux.HandleFunc("GET /api/setups/{id}", s.handleGetSetup)
-	mux.HandleFunc("DELETE /api/setups/{id}", s.handleDeleteSetup)
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		panic("server: encode OpenAPI document: " + err.Error())
+	}
+	s.spec = spec
 
-	mux.HandleFunc("POST /api/runs", s.handleStartRun)
-	mux.HandleFunc("GET /api/runs", s.handleListRuns)
-	mux.HandleFunc("GET /api/runs/{id}", s.handleGetRun)
-	mux.HandleFunc("POST /api/runs/{id}/cancel", s.handleCancelRun)
-	mux.HandleFunc("GET /api/runs/{id}/stats", s.handleGetRunStats)
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.method+" "+rt.path, rt.handler)
+	}
 
 	handler := panicRecovery(logging(logger)(mux))
 
@@ -50,6 +75,7 @@ func New(addr string, service *service.Service, logger *slog.Logger) *Server {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	s.server.RegisterOnShutdown(s.feeds.close)
 
 	return s
 }
@@ -69,47 +95,193 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
-func (s *Server) handleCreateSetup(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name        string            `json:"name"`
-		Description string            `json:"description"`
-		Method      string            `json:"method"`
-		URL         string            `json:"url"`
-		Body        []byte            `json:"body"`
-		Headers     map[string]string `json:"headers"`
-		RPS         int               `json:"rps"`
-		Duration    string            `json:"duration"`
+// setupRequest is the body of the requests that create and update setups.
+type setupRequest struct {
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description"`
+	Executor           string                 `json:"executor"`
+	ExecutorConfig     map[string]interface{} `json:"executor_config"`
+	Method             string                 `json:"method"`
+	URL                string                 `json:"url"`
+	Body               []byte                 `json:"body"`
+	Payload            *dto.Payload           `json:"payload"`
+	Headers            map[string]string      `json:"headers"`
+	RPS                int                    `json:"rps" openapi:"required,min=1"`
+	Duration           string                 `json:"duration" openapi:"required,format=duration"`
+	Expect             string                 `json:"expect"`
+	Connection         string                 `json:"connection" openapi:"enum=reuse|per_request"`
+	MaxRequestsPerConn int                    `json:"max_requests_per_conn" openapi:"min=0"`
+	SourceAddrs        []string               `json:"source_addrs"`
+	Resolve            map[string]string      `json:"resolve"`
+	DNSServer          string                 `json:"dns_server"`
+	DNSCacheTTL        string                 `json:"dns_cache_ttl" openapi:"format=duration"`
+	IPFamily           string                 `json:"ip_family" openapi:"enum=any|ipv4|ipv6"`
+	Retry              *dto.RetryPolicy       `json:"retry"`
+	RestartInterrupted bool                   `json:"restart_interrupted"`
+}
+
+// newSetupRequest returns the request that would create m, which is what a
+// patch applies to.
+func newSetupRequest(m *models.Setup) *setupRequest {
+	req := &setupRequest{
+		Name:               m.Name,
+		Description:        m.Description,
+		Executor:           m.Executor,
+		ExecutorConfig:     m.ExecutorConfig,
+		Method:             m.Method,
+		URL:                m.URL,
+		Body:               m.Body,
+		Payload:            converters.PayloadToDTO(m.Payload),
+		Headers:            m.Headers,
+		RPS:                m.RPS,
+		Duration:           m.Duration.String(),
+		Expect:             m.Expect,
+		Connection:         string(m.Connection),
+		MaxRequestsPerConn: m.MaxRequestsPerConn,
+		SourceAddrs:        m.SourceAddrs,
+		Resolve:            m.Resolve,
+		DNSServer:          m.DNSServer,
+		IPFamily:           m.IPFamily,
+		Retry:              converters.RetryPolicyToDTO(m.Retry),
+		RestartInterrupted: m.RestartInterrupted,
 	}
 
-	if json.NewDecoder(r.Body).Decode(&req) != nil {
-		respondError(w, http.StatusBadRequest, "invalid request body")
-		return
+	if m.DNSCacheTTL != 0 {
+		req.DNSCacheTTL = m.DNSCacheTTL.String()
 	}
 
+	return req
+}
+
+func (req *setupRequest) toModel() (*models.Setup, error) {
 	dur, err := time.ParseDuration(req.Duration)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "invalid duration")
-		return
+		return nil, fmt.Errorf("invalid duration")
+	}
+
+	var dnsCacheTTL time.Duration
+	if req.DNSCacheTTL != "" {
+		if dnsCacheTTL, err = time.ParseDuration(req.DNSCacheTTL); err != nil {
+			return nil, fmt.Errorf("invalid dns_cache_ttl")
+		}
+	}
+
+	retry, err := converters.RetryPolicyFromDTO(req.Retry)
+	if err != nil {
+		return nil, err
 	}
 
-	m, err := s.service.CreateSetup(req.Name, req.Description, req.Method, req.URL, req.Body, req.Headers, req.RPS, dur)
+	m := models.NewSetup(req.Name, req.Description, req.Method, req.URL, req.Body, req.Headers, req.RPS, dur)
+	m.Payload = converters.PayloadFromDTO(req.Payload)
+	m.Executor = req.Executor
+	m.ExecutorConfig = req.ExecutorConfig
+	m.Expect = req.Expect
+	m.Connection = models.ConnectionMode(req.Connection)
+	m.MaxRequestsPerConn = req.MaxRequestsPerConn
+	m.SourceAddrs = req.SourceAddrs
+	m.Resolve = req.Resolve
+	m.DNSServer = req.DNSServer
+	m.DNSCacheTTL = dnsCacheTTL
+	m.IPFamily = req.IPFamily
+	m.Retry = retry
+	m.RestartInterrupted = req.RestartInterrupted
+
+	return m, nil
+}
+
+type createScheduleRequest struct {
+	Name     string     `json:"name"`
+	SetupID  string     `json:"setup_id" openapi:"required"`
+	At       *time.Time `json:"at"`
+	Cron     string     `json:"cron"`
+	Timezone string     `json:"timezone"`
+	Overlap  string     `json:"overlap" openapi:"enum=skip|queue|allow"`
+}
+
+type updateScheduleRequest struct {
+	Enabled *bool `json:"enabled" openapi:"required"`
+}
+
+type startRunRequest struct {
+	SetupID  string            `json:"setup_id" openapi:"required"`
+	Priority int               `json:"priority"`
+	Labels   map[string]string `json:"labels"`
+	Notes    string            `json:"notes"`
+}
+
+type cancelRunRequest struct {
+	Mode    string `json:"mode" openapi:"enum=drain|abort"`
+	Timeout string `json:"timeout" openapi:"format=duration"`
+}
+
+type updateRunRequest struct {
+	RPS int `json:"rps" openapi:"required,min=1"`
+}
+
+func (s *Server) handleCreateSetup(w http.ResponseWriter, r *http.Request, req *setupRequest) {
+	m, err := req.toModel()
 	if err != nil {
 		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, converters.SetupToDTO(m))
+	if err = s.service.CreateSetup(m); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondSetup(w, http.StatusCreated, m)
 }
 
-func (s *Server) handleListSetups(w http.ResponseWriter, _ *http.Request) {
-	setups := s.service.ListSetups()
+func (s *Server) handleListSetups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := parsePage(query)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := storage.SetupFilter{Name: query.Get("q")}
+
+	filter.Status, err = parseStatuses(query.Get("status"),
+		models.SetupStatusActive, models.SetupStatusInactive, models.SetupStatusArchived)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Archived setups are only listed when asked for.
+	if len(filter.Status) == 0 {
+		filter.Status = []models.SetupStatus{models.SetupStatusActive, models.SetupStatusInactive}
+	}
+
+	if filter.CreatedFrom, err = parseTime(query.Get("from")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid from")
+		return
+	}
+
+	if filter.CreatedTo, err = parseTime(query.Get("to")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid to")
+		return
+	}
+
+	setups, next, err := s.service.ListSetups(filter, page)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
 	out := make([]*dto.Setup, len(setups))
 	for i, m := range setups {
 		out[i] = converters.SetupToDTO(m)
 	}
 
-	respondJSON(w, http.StatusOK, out)
+	respondPage(w, out, next)
 }
 
 func (s *Server) handleGetSetup(w http.ResponseWriter, r *http.Request) {
@@ -121,31 +293,289 @@ func (s *Server) handleGetSetup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, converters.SetupToDTO(m))
+	respondSetup(w, http.StatusOK, m)
+}
+
+// handleReplaceSetup replaces the whole configuration of a setup. Fields that
+// are missing get their defaults, as when creating a setup.
+func (s *Server) handleReplaceSetup(w http.ResponseWriter, r *http.Request, req *setupRequest) {
+	version, err := parseIfMatch(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.updateSetup(w, r.PathValue("id"), req, version)
+}
+
+// handlePatchSetup applies a JSON merge patch (RFC 7386) to the configuration
+// of a setup: fields in the patch replace the current ones, null removes them,
+// and objects are merged. The patch applies to the version it was based on,
+// which is the current version unless If-Match names another one.
+func (s *Server) handlePatchSetup(w http.ResponseWriter, r *http.Request, decode func(data []byte) (*setupRequest, bool)) {
+	id := r.PathValue("id")
+
+	patch, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := parseIfMatch(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	current, err := s.service.GetSetup(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if version == 0 {
+		version = current.Version
+	}
+
+	merged, err := mergePatch(newSetupRequest(current), patch)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	req, ok := decode(merged)
+	if !ok {
+		return
+	}
+
+	s.updateSetup(w, id, req, version)
+}
+
+func (s *Server) updateSetup(w http.ResponseWriter, id string, req *setupRequest, version int) {
+	m, err := req.toModel()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m.ID = id
+
+	err = s.service.UpdateSetup(m, version)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrVersionConflict), errors.Is(err, service.ErrSetupArchived):
+		respondError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondSetup(w, http.StatusOK, m)
+	}
+}
+
+// handleListSetupVersions lists every version of a setup with its changes, or
+// with from and to set, the changes between those two versions.
+func (s *Server) handleListSetupVersions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	query := r.URL.Query()
+
+	if query.Has("from") || query.Has("to") {
+		s.diffSetupVersions(w, id, query.Get("from"), query.Get("to"))
+		return
+	}
+
+	versions, err := s.service.ListSetupVersions(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	out := make([]*dto.SetupVersion, len(versions))
+	for i, m := range versions {
+		var previous *models.Setup
+		if i > 0 {
+			previous = versions[i-1]
+		}
+		out[i] = converters.SetupVersionToDTO(m, previous)
+	}
+
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) diffSetupVersions(w http.ResponseWriter, id, fromValue, toValue string) {
+	var versions [2]*models.Setup
+
+	for i, value := range []string{fromValue, toValue} {
+		version, err := strconv.Atoi(value)
+		if err != nil || version <= 0 {
+			respondError(w, http.StatusBadRequest, "from and to must be version numbers")
+			return
+		}
+
+		if versions[i], err = s.service.GetSetupVersion(id, version); err != nil {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, converters.SetupDiffToDTO(versions[0], versions[1]))
+}
+
+func (s *Server) handleGetSetupVersion(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || version <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid version")
+		return
+	}
+
+	m, err := s.service.GetSetupVersion(id, version)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var previous *models.Setup
+	if version > 1 {
+		if previous, err = s.service.GetSetupVersion(id, version-1); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, converters.SetupVersionToDTO(m, previous))
 }
 
+// handleDeleteSetup deletes a setup, or archives it if it has runs. With
+// purge=true its runs are deleted too.
 func (s *Server) handleDeleteSetup(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	if s.service.DeleteSetup(id) != nil {
+	var purge bool
+	if v := r.URL.Query().Get("purge"); v != "" {
+		var err error
+		if purge, err = strconv.ParseBool(v); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid purge")
+			return
+		}
+	}
+
+	archived, err := s.service.DeleteSetup(id, purge)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
 		respondError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, service.ErrSetupInUse):
+		respondError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	case archived != nil:
+		respondSetup(w, http.StatusOK, archived)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleActivateSetup(w http.ResponseWriter, r *http.Request) {
+	s.setSetupStatus(w, r.PathValue("id"), models.SetupStatusActive)
+}
+
+func (s *Server) handleDeactivateSetup(w http.ResponseWriter, r *http.Request) {
+	s.setSetupStatus(w, r.PathValue("id"), models.SetupStatusInactive)
+}
+
+func (s *Server) setSetupStatus(w http.ResponseWriter, id string, status models.SetupStatus) {
+	m, err := s.service.SetSetupStatus(id, status)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	respondSetup(w, http.StatusOK, m)
+}
+
+func (s *Server) handleListExecutors(w http.ResponseWriter, _ *http.Request) {
+	respondJSON(w, http.StatusOK, s.service.ListExecutors())
 }
 
-func (s *Server) handleStartRun(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		SetupID string `json:"setup_id"`
+func (s *Server) handleCreateSchedule(w http.ResponseWriter, r *http.Request, req *createScheduleRequest) {
+	var at time.Time
+	if req.At != nil {
+		at = *req.At
 	}
 
-	if json.NewDecoder(r.Body).Decode(&req) != nil {
-		respondError(w, http.StatusBadRequest, "invalid request")
+	m := models.NewSchedule(req.Name, req.SetupID, at, req.Cron, req.Timezone, models.OverlapPolicy(req.Overlap))
+
+	if err := s.service.CreateSchedule(m); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	m, err := s.service.StartRun(r.Context(), req.SetupID)
+	respondJSON(w, http.StatusCreated, converters.ScheduleToDTO(m))
+}
+
+func (s *Server) handleListSchedules(w http.ResponseWriter, _ *http.Request) {
+	schedules, err := s.service.ListSchedules()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := make([]*dto.Schedule, len(schedules))
+	for i, m := range schedules {
+		out[i] = converters.ScheduleToDTO(m)
+	}
+
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	m, err := s.service.GetSchedule(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, converters.ScheduleToDTO(m))
+}
+
+func (s *Server) handleUpdateSchedule(w http.ResponseWriter, r *http.Request, req *updateScheduleRequest) {
+	id := r.PathValue("id")
+
+	m, err := s.service.SetScheduleEnabled(id, *req.Enabled)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, converters.ScheduleToDTO(m))
+}
+
+func (s *Server) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if s.service.DeleteSchedule(id) != nil {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleStartRun(w http.ResponseWriter, r *http.Request, req *startRunRequest) {
+	m, err := s.service.StartRun(r.Context(), req.SetupID, runner.StartOptions{
+		Priority: req.Priority,
+		Labels:   req.Labels,
+		Notes:    req.Notes,
+	})
 	if err != nil {
 		respondError(w, http.StatusBadRequest, err.Error())
 		return
@@ -156,14 +586,47 @@ func (s *Server) handleStartRun(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
-	setupID := r.URL.Query().Get("setup_id")
+	query := r.URL.Query()
 
-	var modelsRuns []*models.Run
-	switch setupID {
-	case "":
-		modelsRuns = s.service.ListRuns()
-	default:
-		modelsRuns = s.service.ListRunsBySetup(setupID)
+	page, err := parsePage(query)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := storage.RunFilter{SetupID: query.Get("setup_id"), Name: query.Get("q")}
+
+	filter.Status, err = parseStatuses(query.Get("status"),
+		models.RunStatusPending, models.RunStatusRunning, models.RunStatusPaused, models.RunStatusCancelling,
+		models.RunStatusCompleted, models.RunStatusFailed, models.RunStatusCancelled, models.RunStatusInterrupted)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if filter.Labels, err = labels.Parse(query.Get("labels")); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if filter.StartedFrom, err = parseTime(query.Get("from")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid from")
+		return
+	}
+
+	if filter.StartedTo, err = parseTime(query.Get("to")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid to")
+		return
+	}
+
+	modelsRuns, next, err := s.service.ListRuns(filter, page)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
 	out := make([]*dto.Run, len(modelsRuns))
@@ -171,7 +634,7 @@ func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
 		out[i] = converters.RunToDTO(m)
 	}
 
-	respondJSON(w, http.StatusOK, out)
+	respondPage(w, out, next)
 }
 
 func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
@@ -186,15 +649,77 @@ func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, converters.RunToDTO(m))
 }
 
-func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleDeleteRun(w http.ResponseWriter, r *http.Request) {
+	err := s.service.DeleteRun(r.PathValue("id"))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		respondError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, service.ErrRunActive):
+		respondError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request, req *cancelRunRequest) {
+	var timeout time.Duration
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid timeout")
+			return
+		}
+	}
+
+	mode := models.CancelMode(req.Mode)
+	switch mode {
+	case "", models.CancelModeDrain, models.CancelModeAbort:
+	default:
+		respondError(w, http.StatusBadRequest, "unknown cancel mode")
+		return
+	}
+
+	s.runAction(w, r, http.StatusAccepted, func(id string) error {
+		return s.service.CancelRun(id, mode, timeout)
+	})
+}
+
+func (s *Server) handlePauseRun(w http.ResponseWriter, r *http.Request) {
+	s.runAction(w, r, http.StatusOK, s.service.PauseRun)
+}
+
+func (s *Server) handleResumeRun(w http.ResponseWriter, r *http.Request) {
+	s.runAction(w, r, http.StatusOK, s.service.ResumeRun)
+}
+
+func (s *Server) handleUpdateRun(w http.ResponseWriter, r *http.Request, req *updateRunRequest) {
+	s.runAction(w, r, http.StatusOK, func(id string) error {
+		return s.service.SetRunRPS(id, req.RPS)
+	})
+}
+
+func (s *Server) runAction(w http.ResponseWriter, r *http.Request, status int, action func(id string) error) {
 	id := r.PathValue("id")
 
-	if s.service.CancelRun(id) != nil {
-		respondError(w, http.StatusBadRequest, "cannot cancel")
+	if _, err := s.service.GetRun(id); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if err := action(id); err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	m, err := s.service.GetRun(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respondJSON(w, status, converters.RunToDTO(m))
 }
 
 func (s *Server) handleGetRunStats(w http.ResponseWriter, r *http.Request) {
@@ -209,6 +734,342 @@ func (s *Server) handleGetRunStats(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, converters.RunToDTO(run).Stats)
 }
 
+// handleRunEvents streams the events of a run as server-sent events, with a
+// stats event every interval. Event IDs are the indexes of the run's events,
+// so a client that reconnects with Last-Event-ID only gets the events it
+// missed. The stream ends after the finished event.
+func (s *Server) handleRunEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if _, err := s.service.GetRun(id); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	next := 0
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if last, err := strconv.Atoi(v); err == nil && last >= 0 {
+			next = last + 1
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	snapshots, unsubscribe := s.feeds.subscribe(id)
+	defer unsubscribe()
+
+	for {
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		var snap *runSnapshot
+		var ok bool
+		select {
+		case <-r.Context().Done():
+			return
+		case snap, ok = <-snapshots:
+			if !ok {
+				return
+			}
+		}
+
+		if snap.stats != nil {
+			fmt.Fprintf(w, "event: stats\ndata: %s\n\n", snap.stats)
+		} else {
+			fmt.Fprint(w, ": waiting for the run to start\n\n")
+		}
+
+		for ; next < len(snap.events); next++ {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", next, snap.types[next], snap.events[next])
+		}
+	}
+}
+
+func (s *Server) handleListRunSamples(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	reason := models.SampleReason(r.URL.Query().Get("reason"))
+
+	switch reason {
+	case "", models.SampleReasonFailure, models.SampleReasonSlow, models.SampleReasonRandom:
+	default:
+		respondError(w, http.StatusBadRequest, "unknown sample reason")
+		return
+	}
+
+	samples, err := s.service.ListRunSamples(id, reason)
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	out := make([]*dto.Sample, len(samples))
+	for i, m := range samples {
+		out[i] = converters.SampleToDTO(m)
+	}
+
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleGetQueue(w http.ResponseWriter, _ *http.Request) {
+	q := s.service.GetQueue()
+
+	pending := make([]*dto.Run, len(q.Pending))
+	for i, m := range q.Pending {
+		pending[i] = converters.RunToDTO(m)
+	}
+
+	respondJSON(w, http.StatusOK, &dto.Queue{
+		MaxConcurrentRuns: q.Limits.MaxConcurrentRuns,
+		MaxTotalRPS:       q.Limits.MaxTotalRPS,
+		RunningRuns:       q.RunningRuns,
+		UsedRPS:           q.UsedRPS,
+		Pending:           pending,
+	})
+}
+
+func (s *Server) handleCompareRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	baseID, candidateID := query.Get("base"), query.Get("candidate")
+
+	if baseID == "" || candidateID == "" {
+		respondError(w, http.StatusBadRequest, "base and candidate are required")
+		return
+	}
+
+	opts := compare.Options{Tolerance: compare.DefaultTolerance, Alpha: compare.DefaultAlpha}
+
+	if v := query.Get("tolerance"); v != "" {
+		pct, err := strconv.ParseFloat(v, 64)
+		if err != nil || pct < 0 {
+			respondError(w, http.StatusBadRequest, "invalid tolerance")
+			return
+		}
+		opts.Tolerance = pct / 100
+	}
+
+	if v := query.Get("alpha"); v != "" {
+		alpha, err := strconv.ParseFloat(v, 64)
+		if err != nil || alpha <= 0 || alpha >= 1 {
+			respondError(w, http.StatusBadRequest, "invalid alpha")
+			return
+		}
+		opts.Alpha = alpha
+	}
+
+	for _, id := range []string{baseID, candidateID} {
+		if _, err := s.service.GetRun(id); err != nil {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+	}
+
+	m, err := s.service.CompareRuns(baseID, candidateID, opts)
+	if err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, converters.ComparisonToDTO(m))
+}
+
+// parseTime parses an optional RFC 3339 timestamp.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parsePage reads the sort, order, limit and cursor parameters of a list.
+// Lists are newest first by default; sorting by status or name defaults to
+// ascending order.
+func parsePage(query url.Values) (storage.Page, error) {
+	page := storage.Page{
+		Sort:   storage.SortField(query.Get("sort")),
+		Limit:  defaultPageSize,
+		Cursor: query.Get("cursor"),
+	}
+
+	switch page.Sort {
+	case "":
+		page.Sort = storage.SortCreatedAt
+	case storage.SortCreatedAt, storage.SortStatus, storage.SortName:
+	default:
+		return storage.Page{}, fmt.Errorf("sort must be one of created_at, status, name")
+	}
+
+	switch query.Get("order") {
+	case "":
+		page.Desc = page.Sort == storage.SortCreatedAt
+	case "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return storage.Page{}, fmt.Errorf("order must be asc or desc")
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return storage.Page{}, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.Limit = limit
+	}
+
+	return page, nil
+}
+
+// parseStatuses parses a comma separated list of statuses.
+func parseStatuses[T ~string](value string, valid ...T) ([]T, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var out []T
+	for _, part := range strings.Split(value, ",") {
+		status := T(strings.TrimSpace(part))
+		if !slices.Contains(valid, status) {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		out = append(out, status)
+	}
+
+	return out, nil
+}
+
+// parseIfMatch returns the setup version named by the If-Match header, or zero
+// if there is none. Versions are sent as the ETag of setups.
+func parseIfMatch(r *http.Request) (int, error) {
+	value := r.Header.Get("If-Match")
+	if value == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(value, "W/"), `"`))
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("If-Match must be a setup version")
+	}
+
+	return version, nil
+}
+
+// mergePatch applies a JSON merge patch to the JSON encoding of v and
+// returns the result.
+func mergePatch(v any, patch []byte) ([]byte, error) {
+	var p map[string]any
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeJSON(doc, p))
+}
+
+func mergeJSON(doc, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	d, ok := doc.(map[string]any)
+	if !ok {
+		d = make(map[string]any)
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(d, key)
+		} else {
+			d[key] = mergeJSON(d[key], value)
+		}
+	}
+
+	return d
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	var runs bool
+	if v := r.URL.Query().Get("runs"); v != "" {
+		var err error
+		if runs, err = strconv.ParseBool(v); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid runs")
+			return
+		}
+	}
+
+	archive, err := s.service.Export(runs)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out, err := converters.ArchiveToDTO(archive)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="gnat-export.json"`)
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request, req *dto.Archive) {
+	strategy := models.ConflictStrategy(r.URL.Query().Get("conflict"))
+	switch strategy {
+	case "":
+		strategy = models.ConflictSkip
+	case models.ConflictSkip, models.ConflictOverwrite, models.ConflictRename:
+	default:
+		respondError(w, http.StatusBadRequest, "unknown conflict strategy")
+		return
+	}
+
+	archive, err := converters.ArchiveFromDTO(req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := s.service.Import(archive, strategy)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, converters.ImportReportToDTO(report))
+}
+
+func respondSetup(w http.ResponseWriter, status int, m *models.Setup) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(m.Version)))
+	respondJSON(w, status, converters.SetupToDTO(m))
+}
+
+// respondPage writes a page of a list. The cursor of the next page, if there
+// is one, is sent in the X-Next-Cursor header.
+func respondPage(w http.ResponseWriter, items any, next string) {
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	respondJSON(w, http.StatusOK, items)
+}
+
 func respondJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -216,5 +1077,5 @@ func respondJSON(w http.ResponseWriter, status int, v interface{}) {
 }
 
 func respondError(w http.ResponseWriter, status int, msg string) {
-	respondJSON(w, status, map[string]string{"error": msg})
+	respondJSON(w, status, dto.Error{Error: msg})
 }
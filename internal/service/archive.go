@@ -0,0 +1,402 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Export returns every setup with its earlier versions and every schedule,
+// and with runs set every finished run.
+func (s *Service) Export(runs bool) (*models.Archive, error) {
+	setups, _, err := s.repo.ListSetups(storage.SetupFilter{}, storage.Page{})
+	if err != nil {
+		return nil, fmt.Errorf("list setups: %w", err)
+	}
+
+	var versions []*models.Setup
+	for _, setup := range setups {
+		all, err := s.repo.ListSetupVersions(setup.ID)
+		if err != nil {
+			return nil, fmt.Errorf("list versions of setup %s: %w", setup.ID, err)
+		}
+
+		for _, v := range all {
+			if v.Version < setup.Version {
+				versions = append(versions, v)
+			}
+		}
+	}
+
+	schedules, err := s.repo.ListSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("list schedules: %w", err)
+	}
+
+	archive := &models.Archive{Setups: setups, SetupVersions: versions, Schedules: schedules}
+
+	if runs {
+		archive.Runs, _, err = s.repo.ListRuns(storage.RunFilter{Status: storage.FinishedStatuses}, storage.Page{})
+		if err != nil {
+			return nil, fmt.Errorf("list runs: %w", err)
+		}
+	}
+
+	return archive, nil
+}
+
+// Import stores the setups, schedules and runs of an archive. Items are
+// matched by ID; strategy decides what happens to an item whose ID is taken.
+// Renamed items get a new ID, and renamed setups a name that is not taken;
+// imported schedules and runs follow their setups. A run is only imported if
+// the version of the setup it executed is there after the import. Every item
+// is imported on its own, and the report tells how each one went.
+func (s *Service) Import(archive *models.Archive, strategy models.ConflictStrategy) (*models.ImportReport, error) {
+	switch strategy {
+	case models.ConflictSkip, models.ConflictOverwrite, models.ConflictRename:
+	default:
+		return nil, fmt.Errorf("unknown conflict strategy %q", strategy)
+	}
+
+	setups, _, err := s.repo.ListSetups(storage.SetupFilter{}, storage.Page{})
+	if err != nil {
+		return nil, fmt.Errorf("list setups: %w", err)
+	}
+
+	imp := &importer{
+		service:  s,
+		strategy: strategy,
+		setupIDs: make(map[string]string),
+		versions: make(map[string]map[int]int, len(archive.Setups)),
+		archived: make(map[string]bool, len(archive.Setups)),
+		names:    make(map[string]bool, len(setups)),
+	}
+	for _, setup := range setups {
+		imp.names[setup.Name] = true
+	}
+
+	report := &models.ImportReport{
+		Setups:    make([]models.ImportResult, len(archive.Setups)),
+		Schedules: make([]models.ImportResult, len(archive.Schedules)),
+		Runs:      make([]models.ImportResult, len(archive.Runs)),
+	}
+
+	older := make(map[string][]*models.Setup)
+	for _, v := range archive.SetupVersions {
+		older[v.ID] = append(older[v.ID], v)
+	}
+
+	for i, setup := range archive.Setups {
+		imp.archived[setup.ID] = true
+		report.Setups[i] = imp.setup(setup, older[setup.ID])
+	}
+
+	for i, schedule := range archive.Schedules {
+		report.Schedules[i] = imp.schedule(schedule)
+	}
+
+	for i, run := range archive.Runs {
+		report.Runs[i] = imp.run(run)
+	}
+
+	return report, nil
+}
+
+type importer struct {
+	service  *Service
+	strategy models.ConflictStrategy
+	// setupIDs maps the IDs of renamed setups to their new IDs.
+	setupIDs map[string]string
+	// versions maps the versions of each imported or skipped setup in the
+	// archive to the stored versions with the same configuration.
+	versions map[string]map[int]int
+	// archived holds the IDs of the setups in the archive.
+	archived map[string]bool
+	names    map[string]bool
+}
+
+// setup imports a setup along with its earlier versions.
+func (imp *importer) setup(setup *models.Setup, older []*models.Setup) models.ImportResult {
+	s := imp.service
+	id := setup.ID
+
+	if setup.Version < 1 {
+		setup.Version = 1
+	}
+	history := setupHistory(setup, older)
+
+	current, err := s.repo.GetSetup(id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return imp.result(id, "", models.ImportCreated, imp.createSetup(id, history))
+	case err != nil:
+		return imp.result(id, "", models.ImportFailed, err)
+	}
+
+	switch imp.strategy {
+	case models.ConflictOverwrite:
+		return imp.result(id, "", models.ImportOverwritten, imp.overwriteSetup(id, current, history))
+	case models.ConflictRename:
+		newID := uuid.New().String()
+		name := imp.uniqueName(setup.Name)
+		for _, v := range history {
+			if v.Name == setup.Name {
+				v.Name = name
+			}
+			v.ID = newID
+		}
+		if err = imp.createSetup(id, history); err == nil {
+			imp.setupIDs[id] = newID
+		}
+		return imp.result(id, newID, models.ImportRenamed, err)
+	default:
+		imp.matchVersions(id, current, history)
+		return imp.result(id, "", models.ImportSkipped, nil)
+	}
+}
+
+// setupHistory returns the versions of a setup in order, ending with the
+// current one. Versions that are not older than the current one are dropped.
+func setupHistory(setup *models.Setup, older []*models.Setup) []*models.Setup {
+	var history []*models.Setup
+	for _, v := range older {
+		if v.Version < setup.Version {
+			history = append(history, v)
+		}
+	}
+
+	slices.SortFunc(history, func(a, b *models.Setup) int { return a.Version - b.Version })
+	history = slices.CompactFunc(history, func(a, b *models.Setup) bool { return a.Version == b.Version })
+
+	return append(history, setup)
+}
+
+// createSetup stores a setup that is new to this instance with every version
+// of its history, keeping the version numbers. The versions are stored at
+// once, so a setup that fails to import leaves nothing behind.
+func (imp *importer) createSetup(id string, history []*models.Setup) error {
+	s := imp.service
+	setup := history[len(history)-1]
+	now := time.Now()
+
+	if setup.Status == "" {
+		setup.Status = models.SetupStatusActive
+	}
+	if setup.CreatedAt.IsZero() {
+		setup.CreatedAt = now
+	}
+
+	if err := validateSetup(setup); err != nil {
+		return err
+	}
+
+	versions := make(map[int]int, len(history))
+	for _, v := range history {
+		v.Status = setup.Status
+		v.CreatedAt = setup.CreatedAt
+		if v.UpdatedAt.IsZero() {
+			v.UpdatedAt = now
+		}
+		versions[v.Version] = v.Version
+	}
+
+	if err := s.repo.CreateSetupHistory(history); err != nil {
+		return fmt.Errorf("create setup: %w", err)
+	}
+
+	imp.versions[id] = versions
+	imp.names[setup.Name] = true
+	return nil
+}
+
+// overwriteSetup makes the last version of history the current version of a
+// stored setup. Versions that are stored with the same configuration are
+// reused, the others are added as new versions.
+func (imp *importer) overwriteSetup(id string, current *models.Setup, history []*models.Setup) error {
+	s := imp.service
+
+	if current.Status == models.SetupStatusArchived {
+		return ErrSetupArchived
+	}
+
+	if err := validateSetup(history[len(history)-1]); err != nil {
+		return err
+	}
+
+	versions := make(map[int]int, len(history))
+	for i, v := range history {
+		match := current
+		if i < len(history)-1 {
+			match, _ = s.repo.GetSetupVersion(id, v.Version)
+		}
+		if match != nil && sameVersion(v, match) {
+			versions[v.Version] = match.Version
+			continue
+		}
+
+		version := v.Version
+		v.Status = current.Status
+		v.CreatedAt = current.CreatedAt
+		v.Version = current.Version + 1
+		v.UpdatedAt = time.Now()
+
+		err := s.repo.CreateSetupVersion(v)
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return fmt.Errorf("%w: setup was changed concurrently", ErrVersionConflict)
+		}
+		if err != nil {
+			return err
+		}
+
+		versions[version] = v.Version
+		current = v
+	}
+
+	imp.versions[id] = versions
+	return nil
+}
+
+// matchVersions records which versions of a skipped setup are stored with the
+// same configuration, so that runs of those versions can still be imported.
+func (imp *importer) matchVersions(id string, current *models.Setup, history []*models.Setup) {
+	s := imp.service
+
+	versions := make(map[int]int, len(history))
+	for _, v := range history {
+		stored, err := s.repo.GetSetupVersion(id, v.Version)
+		if err == nil && sameVersion(v, stored) {
+			versions[v.Version] = v.Version
+		}
+	}
+
+	// The current version may match a later stored version.
+	setup := history[len(history)-1]
+	if _, ok := versions[setup.Version]; !ok && sameVersion(setup, current) {
+		versions[setup.Version] = current.Version
+	}
+
+	imp.versions[id] = versions
+}
+
+// uniqueName returns name followed by the first number that makes it unused.
+func (imp *importer) uniqueName(name string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)", name, n)
+		if !imp.names[candidate] {
+			return candidate
+		}
+	}
+}
+
+func (imp *importer) schedule(schedule *models.Schedule) models.ImportResult {
+	s := imp.service
+	id := schedule.ID
+
+	if setupID, ok := imp.setupIDs[schedule.SetupID]; ok {
+		schedule.SetupID = setupID
+	}
+
+	_, err := s.repo.GetSchedule(id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return imp.result(id, "", models.ImportCreated, s.scheduler.Create(schedule))
+	case err != nil:
+		return imp.result(id, "", models.ImportFailed, err)
+	}
+
+	switch imp.strategy {
+	case models.ConflictOverwrite:
+		return imp.result(id, "", models.ImportOverwritten, s.scheduler.Replace(schedule))
+	case models.ConflictRename:
+		schedule.ID = uuid.New().String()
+		return imp.result(id, schedule.ID, models.ImportRenamed, s.scheduler.Create(schedule))
+	default:
+		return imp.result(id, "", models.ImportSkipped, nil)
+	}
+}
+
+func (imp *importer) run(run *models.Run) models.ImportResult {
+	s := imp.service
+	id := run.ID
+
+	if !storage.Finished(run) {
+		return imp.result(id, "", models.ImportFailed, fmt.Errorf("%w: status is %s", ErrRunActive, run.Status))
+	}
+
+	if err := imp.resolveSetup(run); err != nil {
+		return imp.result(id, "", models.ImportFailed, err)
+	}
+
+	current, err := s.repo.GetRun(id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return imp.result(id, "", models.ImportCreated, s.repo.CreateRun(run))
+	case err != nil:
+		return imp.result(id, "", models.ImportFailed, err)
+	}
+
+	switch imp.strategy {
+	case models.ConflictOverwrite:
+		if !storage.Finished(current) {
+			err = fmt.Errorf("%w: status is %s", ErrRunActive, current.Status)
+		} else {
+			err = s.repo.UpdateRun(run)
+		}
+		return imp.result(id, "", models.ImportOverwritten, err)
+	case models.ConflictRename:
+		run.ID = uuid.New().String()
+		return imp.result(id, run.ID, models.ImportRenamed, s.repo.CreateRun(run))
+	default:
+		return imp.result(id, "", models.ImportSkipped, nil)
+	}
+}
+
+// sameVersion reports whether a setup from an archive has the configuration
+// of a stored version.
+func sameVersion(v, stored *models.Setup) bool {
+	x := *v
+	x.Status = stored.Status
+	x.CreatedAt = stored.CreatedAt
+	return sameConfig(&x, stored)
+}
+
+// resolveSetup points a run at the stored version of the setup it executed:
+// the one it was imported as if the setup is in the archive, or else the one
+// already stored.
+func (imp *importer) resolveSetup(run *models.Run) error {
+	if !imp.archived[run.SetupID] {
+		_, err := imp.service.repo.GetSetupVersion(run.SetupID, run.SetupVersion)
+		return err
+	}
+
+	versions, ok := imp.versions[run.SetupID]
+	if !ok {
+		return fmt.Errorf("setup %s was not imported", run.SetupID)
+	}
+
+	version, ok := versions[run.SetupVersion]
+	if !ok {
+		return fmt.Errorf("version %d of setup %s was not imported", run.SetupVersion, run.SetupID)
+	}
+
+	if setupID, ok := imp.setupIDs[run.SetupID]; ok {
+		run.SetupID = setupID
+	}
+	run.SetupVersion = version
+
+	return nil
+}
+
+// result reports action for the item with the given ID, or a failure if err
+// is not nil.
+func (imp *importer) result(id, newID string, action models.ImportAction, err error) models.ImportResult {
+	if err != nil {
+		return models.ImportResult{ID: id, Action: models.ImportFailed, Error: err.Error()}
+	}
+	return models.ImportResult{ID: id, NewID: newID, Action: action}
+}
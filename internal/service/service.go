@@ -2,89 +2,327 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 	"time"
 
+	"github.com/bdtfs/gnat/internal/compare"
 	"github.com/bdtfs/gnat/internal/models"
 	"github.com/bdtfs/gnat/internal/runner"
-	repository "github.com/bdtfs/gnat/internal/storage/memory"
+	"github.com/bdtfs/gnat/internal/scheduler"
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/bdtfs/gnat/pkg/executor"
+)
+
+var (
+	// ErrVersionConflict is returned when a setup is updated based on a
+	// version that is no longer current.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrSetupArchived is returned when an archived setup is updated.
+	ErrSetupArchived = errors.New("setup is archived")
+	// ErrSetupInUse is returned when a setup with unfinished runs is deleted.
+	ErrSetupInUse = errors.New("setup has unfinished runs")
+	// ErrRunActive is returned when an unfinished run is deleted.
+	ErrRunActive = errors.New("run is not finished")
 )
 
 type Service struct {
-	repo   *repository.Repository
-	runner *runner.Runner
+	repo      storage.Repository
+	runner    *runner.Runner
+	scheduler *scheduler.Scheduler
 }
 
-func New(repo *repository.Repository, runner *runner.Runner) *Service {
+func New(repo storage.Repository, runner *runner.Runner, scheduler *scheduler.Scheduler) *Service {
 	return &Service{
-		repo:   repo,
-		runner: runner,
+		repo:      repo,
+		runner:    runner,
+		scheduler: scheduler,
+	}
+}
+
+func (s *Service) CreateSetup(setup *models.Setup) error {
+	if err := validateSetup(setup); err != nil {
+		return err
+	}
+
+	if err := s.repo.CreateSetup(setup); err != nil {
+		return fmt.Errorf("create setup: %w", err)
 	}
+
+	return nil
+}
+
+func (s *Service) GetSetup(id string) (*models.Setup, error) {
+	return s.repo.GetSetup(id)
+}
+
+func (s *Service) ListSetups(filter storage.SetupFilter, page storage.Page) ([]*models.Setup, string, error) {
+	return s.repo.ListSetups(filter, page)
 }
 
-func (s *Service) CreateSetup(name, description, method, url string, body []byte, headers map[string]string, rps int, duration time.Duration) (*models.Setup, error) {
-	if url == "" {
-		return nil, fmt.Errorf("url is required")
+// UpdateSetup replaces the configuration of a setup and keeps it as a new
+// version; the status and creation time stay. Unless version is zero, it must
+// be the current version. An update that changes nothing keeps the current
+// version and fills setup with it.
+func (s *Service) UpdateSetup(setup *models.Setup, version int) error {
+	current, err := s.repo.GetSetup(setup.ID)
+	if err != nil {
+		return err
 	}
 
-	if rps <= 0 {
-		return nil, fmt.Errorf("rps must be greater than 0")
+	if current.Status == models.SetupStatusArchived {
+		return ErrSetupArchived
 	}
 
-	if duration <= 0 {
-		return nil, fmt.Errorf("duration must be greater than 0")
+	if version != 0 && version != current.Version {
+		return fmt.Errorf("%w: setup is at version %d, not %d", ErrVersionConflict, current.Version, version)
 	}
 
-	setup := models.NewSetup(name, description, method, url, body, headers, rps, duration)
+	if err = validateSetup(setup); err != nil {
+		return err
+	}
 
-	if err := s.repo.CreateSetup(setup); err != nil {
-		return nil, fmt.Errorf("create setup: %w", err)
+	setup.Status = current.Status
+	setup.CreatedAt = current.CreatedAt
+
+	if sameConfig(setup, current) {
+		*setup = *current
+		return nil
 	}
 
-	return setup, nil
+	setup.Version = current.Version + 1
+	setup.UpdatedAt = time.Now()
+
+	err = s.repo.CreateSetupVersion(setup)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		return fmt.Errorf("%w: setup was changed concurrently", ErrVersionConflict)
+	}
+
+	return err
 }
 
-func (s *Service) GetSetup(id string) (*models.Setup, error) {
-	return s.repo.GetSetup(id)
+func (s *Service) GetSetupVersion(id string, version int) (*models.Setup, error) {
+	return s.repo.GetSetupVersion(id, version)
 }
 
-func (s *Service) ListSetups() []*models.Setup {
-	return s.repo.ListSetups()
+func (s *Service) ListSetupVersions(id string) ([]*models.Setup, error) {
+	return s.repo.ListSetupVersions(id)
 }
 
-func (s *Service) UpdateSetup(setup *models.Setup) error {
-	if _, err := s.repo.GetSetup(setup.ID); err != nil {
-		return fmt.Errorf("setup not found: %w", err)
+// SetSetupStatus activates or deactivates a setup. Only active setups can
+// start runs; runs that were already started are not affected. Activating an
+// archived setup restores it.
+func (s *Service) SetSetupStatus(id string, status models.SetupStatus) (*models.Setup, error) {
+	current, err := s.repo.GetSetup(id)
+	if err != nil {
+		return nil, err
 	}
 
-	setup.UpdatedAt = time.Now()
-	return s.repo.UpdateSetup(setup)
+	if current.Status == status {
+		return current, nil
+	}
+
+	return s.repo.SetSetupStatus(id, status, time.Now())
 }
 
-func (s *Service) DeleteSetup(id string) error {
-	return s.repo.DeleteSetup(id)
+// DeleteSetup deletes a setup that has no runs, with its versions and
+// schedules. A setup with runs is archived instead: it is kept with its
+// versions for the history of its runs, and its schedules are disabled. With
+// purge set, its runs are deleted as well. Setups with unfinished runs are
+// never deleted. DeleteSetup returns the archived setup, or nil if the setup
+// was deleted.
+func (s *Service) DeleteSetup(id string, purge bool) (*models.Setup, error) {
+	current, err := s.repo.GetSetup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The setup is archived before its runs are checked, so that no new
+	// runs start in the meantime.
+	archived, err := s.repo.SetSetupStatus(id, models.SetupStatusArchived, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	runs, _, err := s.repo.ListRuns(storage.RunFilter{SetupID: id}, storage.Page{})
+	if err == nil {
+		if i := slices.IndexFunc(runs, func(run *models.Run) bool { return !storage.Finished(run) }); i >= 0 {
+			err = fmt.Errorf("%w: run %s is %s", ErrSetupInUse, runs[i].ID, runs[i].Status)
+		}
+	}
+	if err != nil {
+		if _, restoreErr := s.repo.SetSetupStatus(id, current.Status, time.Now()); restoreErr != nil {
+			return nil, errors.Join(err, restoreErr)
+		}
+		return nil, err
+	}
+
+	keep := len(runs) > 0 && !purge
+	if err = s.scheduler.RemoveSetup(id, keep); err != nil {
+		return nil, fmt.Errorf("remove schedules: %w", err)
+	}
+
+	if keep {
+		return archived, nil
+	}
+
+	for _, run := range runs {
+		if err = s.repo.DeleteRun(run.ID); err != nil {
+			return nil, fmt.Errorf("delete run %s: %w", run.ID, err)
+		}
+	}
+
+	return nil, s.repo.DeleteSetup(id)
 }
 
-func (s *Service) StartRun(ctx context.Context, setupID string) (*models.Run, error) {
-	return s.runner.StartRun(ctx, setupID)
+func (s *Service) StartRun(ctx context.Context, setupID string, opts runner.StartOptions) (*models.Run, error) {
+	return s.runner.StartRun(ctx, setupID, opts)
 }
 
 func (s *Service) GetRun(id string) (*models.Run, error) {
 	return s.repo.GetRun(id)
 }
 
-func (s *Service) ListRuns() []*models.Run {
-	return s.repo.ListRuns()
+func (s *Service) ListRuns(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	return s.repo.ListRuns(filter, page)
+}
+
+// DeleteRun deletes a finished run. Unfinished runs have to be cancelled
+// first.
+func (s *Service) DeleteRun(id string) error {
+	run, err := s.repo.GetRun(id)
+	if err != nil {
+		return err
+	}
+
+	if !storage.Finished(run) {
+		return fmt.Errorf("%w: status is %s", ErrRunActive, storage.Status(run))
+	}
+
+	return s.repo.DeleteRun(id)
+}
+
+func (s *Service) CompareRuns(baseID, candidateID string, opts compare.Options) (*models.Comparison, error) {
+	base, err := s.repo.GetRun(baseID)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	candidate, err := s.repo.GetRun(candidateID)
+	if err != nil {
+		return nil, fmt.Errorf("candidate: %w", err)
+	}
+
+	return compare.Runs(base, candidate, opts)
+}
+
+func (s *Service) ListRunSamples(id string, reason models.SampleReason) ([]*models.Sample, error) {
+	run, err := s.repo.GetRun(id)
+	if err != nil {
+		return nil, err
+	}
+
+	run.StateMu.RLock()
+	stats := run.Stats
+	run.StateMu.RUnlock()
+
+	if stats == nil {
+		return []*models.Sample{}, nil
+	}
+
+	stats.SamplesMu.RLock()
+	defer stats.SamplesMu.RUnlock()
+
+	out := make([]*models.Sample, 0, len(stats.Samples))
+	for _, sample := range stats.Samples {
+		if reason == "" || sample.Reason == reason {
+			out = append(out, sample)
+		}
+	}
+
+	return out, nil
+}
+
+func (s *Service) CancelRun(runID string, mode models.CancelMode, timeout time.Duration) error {
+	return s.runner.CancelRun(runID, mode, timeout)
+}
+
+func (s *Service) PauseRun(runID string) error {
+	return s.runner.PauseRun(runID)
+}
+
+func (s *Service) ResumeRun(runID string) error {
+	return s.runner.ResumeRun(runID)
 }
 
-func (s *Service) ListRunsBySetup(setupID string) []*models.Run {
-	return s.repo.ListRunsBySetup(setupID)
+func (s *Service) SetRunRPS(runID string, rps int) error {
+	return s.runner.SetRunRPS(runID, rps)
 }
 
-func (s *Service) CancelRun(runID string) error {
-	return s.runner.CancelRun(runID)
+func (s *Service) GetQueue() runner.QueueStatus {
+	return s.runner.GetQueue()
 }
 
 func (s *Service) GetActiveRuns() []string {
 	return s.runner.GetActiveRuns()
 }
+
+func (s *Service) CreateSchedule(schedule *models.Schedule) error {
+	return s.scheduler.Create(schedule)
+}
+
+func (s *Service) GetSchedule(id string) (*models.Schedule, error) {
+	return s.repo.GetSchedule(id)
+}
+
+func (s *Service) ListSchedules() ([]*models.Schedule, error) {
+	return s.repo.ListSchedules()
+}
+
+func (s *Service) SetScheduleEnabled(id string, enabled bool) (*models.Schedule, error) {
+	return s.scheduler.SetEnabled(id, enabled)
+}
+
+func (s *Service) DeleteSchedule(id string) error {
+	return s.scheduler.Delete(id)
+}
+
+func (s *Service) ListExecutors() []string {
+	return executor.Names()
+}
+
+// sameConfig reports whether two setups execute the same runs.
+func sameConfig(a, b *models.Setup) bool {
+	x, y := *a, *b
+	for _, setup := range []*models.Setup{&x, &y} {
+		setup.Version = 0
+		setup.UpdatedAt = time.Time{}
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func validateSetup(setup *models.Setup) error {
+	if setup.RPS <= 0 {
+		return fmt.Errorf("rps must be greater than 0")
+	}
+
+	if setup.Duration <= 0 {
+		return fmt.Errorf("duration must be greater than 0")
+	}
+
+	switch setup.Connection {
+	case "", models.ConnectionModeReuse, models.ConnectionModePerRequest:
+	default:
+		return fmt.Errorf("unknown connection mode %q", setup.Connection)
+	}
+
+	if setup.MaxRequestsPerConn < 0 {
+		return fmt.Errorf("max_requests_per_conn must not be negative")
+	}
+
+	setup.Executor = runner.ExecutorName(setup)
+
+	return runner.ValidateSetup(setup)
+}
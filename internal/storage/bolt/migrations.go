@@ -0,0 +1,123 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+var (
+	metaBucket        = []byte("meta")
+	setupsBucket      = []byte("setups")
+	runsBucket        = []byte("runs")
+	runsBySetupBucket = []byte("runs_by_setup")
+	schedulesBucket   = []byte("schedules")
+	versionsBucket    = []byte("setup_versions")
+
+	schemaVersionKey = []byte("schema_version")
+)
+
+// migrations upgrade the database one schema version at a time. Migration i
+// brings the schema to version i+1. New migrations are appended, existing
+// ones are never changed.
+var migrations = []func(tx *bbolt.Tx) error{
+	createBuckets,
+	indexRunsBySetup,
+	versionSetups,
+}
+
+func migrate(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		var version uint64
+		if v := meta.Get(schemaVersionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("schema version %d is newer than the supported version %d", version, len(migrations))
+		}
+
+		for i := version; i < uint64(len(migrations)); i++ {
+			if err = migrations[i](tx); err != nil {
+				return fmt.Errorf("migrate to version %d: %w", i+1, err)
+			}
+		}
+
+		return meta.Put(schemaVersionKey, binary.BigEndian.AppendUint64(nil, uint64(len(migrations))))
+	})
+}
+
+func createBuckets(tx *bbolt.Tx) error {
+	for _, name := range [][]byte{setupsBucket, runsBucket, schedulesBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRunsBySetup adds an index of run IDs by setup ID so that listing the
+// runs of a setup does not decode every run.
+func indexRunsBySetup(tx *bbolt.Tx) error {
+	index, err := tx.CreateBucketIfNotExists(runsBySetupBucket)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(runsBucket).ForEach(func(_, v []byte) error {
+		run, err := storage.DecodeRun(v)
+		if err != nil {
+			return err
+		}
+		return index.Put(runIndexKey(run.SetupID, run.ID), []byte{})
+	})
+}
+
+// versionSetups keeps every existing setup as its first version.
+func versionSetups(tx *bbolt.Tx) error {
+	versions, err := tx.CreateBucketIfNotExists(versionsBucket)
+	if err != nil {
+		return err
+	}
+
+	setups := tx.Bucket(setupsBucket)
+
+	return setups.ForEach(func(k, v []byte) error {
+		setup, err := storage.DecodeSetup(v)
+		if err != nil {
+			return err
+		}
+
+		setup.Version = 1
+		data, err := storage.EncodeSetup(setup)
+		if err != nil {
+			return err
+		}
+
+		if err = setups.Put(k, data); err != nil {
+			return err
+		}
+		return versions.Put(versionKey(setup.ID, setup.Version), data)
+	})
+}
+
+func runIndexKey(setupID, runID string) []byte {
+	return []byte(setupID + "/" + runID)
+}
+
+// versionKey orders the versions of a setup by number.
+func versionKey(setupID string, version int) []byte {
+	return binary.BigEndian.AppendUint64(versionPrefix(setupID), uint64(version))
+}
+
+func versionPrefix(setupID string) []byte {
+	return []byte(setupID + "/")
+}
@@ -0,0 +1,608 @@
+package bolt
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+// Repository stores everything in a single bbolt file. Runs that have not
+// finished are also kept in memory, so callers see the runner's updates to
+// them; finished runs are decoded from the file on every read.
+type Repository struct {
+	db   *bbolt.DB
+	live map[string]*models.Run
+	mu   sync.RWMutex
+}
+
+var _ storage.Repository = (*Repository)(nil)
+
+// Open opens or creates the database at path and migrates it to the current
+// schema.
+func Open(path string) (*Repository, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	if err = migrate(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate %s: %w", path, err)
+	}
+
+	return &Repository{
+		db:   db,
+		live: make(map[string]*models.Run),
+	}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+func (r *Repository) CreateSetup(setup *models.Setup) error {
+	return r.CreateSetupHistory([]*models.Setup{setup})
+}
+
+func (r *Repository) CreateSetupHistory(history []*models.Setup) error {
+	setup := history[len(history)-1]
+
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(setupsBucket)
+		if b.Get([]byte(setup.ID)) != nil {
+			return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrAlreadyExists)
+		}
+
+		versions := tx.Bucket(versionsBucket)
+
+		var data []byte
+		for _, v := range history {
+			key := versionKey(v.ID, v.Version)
+			if versions.Get(key) != nil {
+				return fmt.Errorf("setup with id %s version %d %w", v.ID, v.Version, storage.ErrAlreadyExists)
+			}
+
+			var err error
+			if data, err = storage.EncodeSetup(v); err != nil {
+				return fmt.Errorf("encode setup: %w", err)
+			}
+
+			if err = versions.Put(key, data); err != nil {
+				return err
+			}
+		}
+
+		return b.Put([]byte(setup.ID), data)
+	})
+}
+
+func (r *Repository) GetSetup(id string) (*models.Setup, error) {
+	var setup *models.Setup
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(setupsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+		}
+
+		var err error
+		setup, err = storage.DecodeSetup(data)
+		return err
+	})
+
+	return setup, err
+}
+
+// ListSetups decodes every setup and filters, sorts and pages them in memory.
+func (r *Repository) ListSetups(filter storage.SetupFilter, page storage.Page) ([]*models.Setup, string, error) {
+	setups := make([]*models.Setup, 0)
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(setupsBucket).ForEach(func(_, data []byte) error {
+			setup, err := storage.DecodeSetup(data)
+			if err != nil {
+				return err
+			}
+			if filter.Matches(setup) {
+				setups = append(setups, setup)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return storage.PageSetups(setups, page)
+}
+
+func (r *Repository) UpdateSetup(setup *models.Setup) error {
+	data, err := storage.EncodeSetup(setup)
+	if err != nil {
+		return fmt.Errorf("encode setup: %w", err)
+	}
+
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(setupsBucket)
+		if b.Get([]byte(setup.ID)) == nil {
+			return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrNotFound)
+		}
+		return b.Put([]byte(setup.ID), data)
+	})
+}
+
+func (r *Repository) SetSetupStatus(id string, status models.SetupStatus, at time.Time) (*models.Setup, error) {
+	var setup *models.Setup
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(setupsBucket)
+
+		data := b.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+		}
+
+		var err error
+		if setup, err = storage.DecodeSetup(data); err != nil {
+			return err
+		}
+
+		setup.Status = status
+		setup.UpdatedAt = at
+
+		if data, err = storage.EncodeSetup(setup); err != nil {
+			return fmt.Errorf("encode setup: %w", err)
+		}
+		return b.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return setup, nil
+}
+
+func (r *Repository) DeleteSetup(id string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(setupsBucket)
+		if b.Get([]byte(id)) == nil {
+			return fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+		}
+
+		prefix := versionPrefix(id)
+		c := tx.Bucket(versionsBucket).Cursor()
+
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+func (r *Repository) CreateSetupVersion(setup *models.Setup) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(setupsBucket)
+
+		stored := b.Get([]byte(setup.ID))
+		if stored == nil {
+			return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrNotFound)
+		}
+
+		versions := tx.Bucket(versionsBucket)
+		key := versionKey(setup.ID, setup.Version)
+		if versions.Get(key) != nil {
+			return fmt.Errorf("setup with id %s version %d %w", setup.ID, setup.Version, storage.ErrAlreadyExists)
+		}
+
+		current, err := storage.DecodeSetup(stored)
+		if err != nil {
+			return err
+		}
+		setup.Status = current.Status
+
+		data, err := storage.EncodeSetup(setup)
+		if err != nil {
+			return fmt.Errorf("encode setup: %w", err)
+		}
+
+		if err := versions.Put(key, data); err != nil {
+			return err
+		}
+
+		return b.Put([]byte(setup.ID), data)
+	})
+}
+
+func (r *Repository) GetSetupVersion(id string, version int) (*models.Setup, error) {
+	var setup *models.Setup
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(versionsBucket).Get(versionKey(id, version))
+		if data == nil {
+			return fmt.Errorf("setup with id %s version %d %w", id, version, storage.ErrNotFound)
+		}
+
+		var err error
+		setup, err = storage.DecodeSetup(data)
+		return err
+	})
+
+	return setup, err
+}
+
+func (r *Repository) ListSetupVersions(id string) ([]*models.Setup, error) {
+	versions := make([]*models.Setup, 0)
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := versionPrefix(id)
+		c := tx.Bucket(versionsBucket).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			setup, err := storage.DecodeSetup(v)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, setup)
+		}
+
+		if len(versions) == 0 {
+			return fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+		}
+		return nil
+	})
+
+	return versions, err
+}
+
+func (r *Repository) CreateRun(run *models.Run) error {
+	data, err := storage.EncodeRun(run)
+	if err != nil {
+		return fmt.Errorf("encode run: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b.Get([]byte(run.ID)) != nil {
+			return fmt.Errorf("run with id %s %w", run.ID, storage.ErrAlreadyExists)
+		}
+
+		if err := tx.Bucket(runsBySetupBucket).Put(runIndexKey(run.SetupID, run.ID), []byte{}); err != nil {
+			return err
+		}
+
+		return b.Put([]byte(run.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.track(run)
+	return nil
+}
+
+func (r *Repository) GetRun(id string) (*models.Run, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if run, ok := r.live[id]; ok {
+		return run, nil
+	}
+
+	var run *models.Run
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(runsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+		}
+
+		var err error
+		run, err = storage.DecodeRun(data)
+		return err
+	})
+
+	return run, err
+}
+
+// ListRuns decodes every run, or only the runs of the setup when the filter
+// names one, and applies the rest of the filter, sorting and paging in memory.
+func (r *Repository) ListRuns(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	return r.listRuns(filter, page, storage.DecodeRun)
+}
+
+func (r *Repository) ListRunSummaries(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	return r.listRuns(filter, page, storage.DecodeRunSummary)
+}
+
+func (r *Repository) listRuns(
+	filter storage.RunFilter,
+	page storage.Page,
+	decode func(data []byte) (*models.Run, error),
+) ([]*models.Run, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	runs := make([]*models.Run, 0)
+
+	collect := func(id, data []byte) error {
+		run, ok := r.live[string(id)]
+		if !ok {
+			if data == nil {
+				return fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+			}
+
+			var err error
+			if run, err = decode(data); err != nil {
+				return err
+			}
+		}
+		if filter.Matches(run) {
+			runs = append(runs, run)
+		}
+		return nil
+	}
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+
+		if filter.SetupID == "" {
+			return b.ForEach(collect)
+		}
+
+		prefix := runIndexKey(filter.SetupID, "")
+		c := tx.Bucket(runsBySetupBucket).Cursor()
+
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := k[len(prefix):]
+			if err := collect(id, b.Get(id)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return storage.PageRuns(runs, page)
+}
+
+func (r *Repository) UpdateRun(run *models.Run) error {
+	data, err := storage.EncodeRun(run)
+	if err != nil {
+		return fmt.Errorf("encode run: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b.Get([]byte(run.ID)) == nil {
+			return fmt.Errorf("run with id %s %w", run.ID, storage.ErrNotFound)
+		}
+		return b.Put([]byte(run.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.track(run)
+	return nil
+}
+
+func (r *Repository) ExpireRun(id string, now time.Time, finish func(run *models.Run)) (*models.Run, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var run *models.Run
+	expired := false
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+
+		var err error
+		if run, err = r.decodeRun([]byte(id), b.Get([]byte(id))); err != nil {
+			return err
+		}
+
+		if !storage.Expired(run, now) {
+			return nil
+		}
+
+		finish(run)
+		expired = true
+
+		data, err := storage.EncodeRun(run)
+		if err != nil {
+			return fmt.Errorf("encode run: %w", err)
+		}
+		return b.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !expired {
+		return nil, false, nil
+	}
+
+	r.track(run)
+	return run, true, nil
+}
+
+func (r *Repository) DeleteRun(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+
+		data := b.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+		}
+
+		run, err := storage.DecodeRun(data)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Bucket(runsBySetupBucket).Delete(runIndexKey(run.SetupID, id)); err != nil {
+			return err
+		}
+
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(r.live, id)
+	return nil
+}
+
+func (r *Repository) CreateSchedule(schedule *models.Schedule) error {
+	data, err := storage.EncodeSchedule(schedule)
+	if err != nil {
+		return fmt.Errorf("encode schedule: %w", err)
+	}
+
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(schedulesBucket)
+		if b.Get([]byte(schedule.ID)) != nil {
+			return fmt.Errorf("schedule with id %s %w", schedule.ID, storage.ErrAlreadyExists)
+		}
+		return b.Put([]byte(schedule.ID), data)
+	})
+}
+
+func (r *Repository) GetSchedule(id string) (*models.Schedule, error) {
+	var schedule *models.Schedule
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(schedulesBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+		}
+
+		var err error
+		schedule, err = storage.DecodeSchedule(data)
+		return err
+	})
+
+	return schedule, err
+}
+
+func (r *Repository) ListSchedules() ([]*models.Schedule, error) {
+	schedules := make([]*models.Schedule, 0)
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(schedulesBucket).ForEach(func(_, data []byte) error {
+			schedule, err := storage.DecodeSchedule(data)
+			if err != nil {
+				return err
+			}
+			schedules = append(schedules, schedule)
+			return nil
+		})
+	})
+
+	return schedules, err
+}
+
+func (r *Repository) UpdateSchedule(schedule *models.Schedule) error {
+	data, err := storage.EncodeSchedule(schedule)
+	if err != nil {
+		return fmt.Errorf("encode schedule: %w", err)
+	}
+
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(schedulesBucket)
+		if b.Get([]byte(schedule.ID)) == nil {
+			return fmt.Errorf("schedule with id %s %w", schedule.ID, storage.ErrNotFound)
+		}
+		return b.Put([]byte(schedule.ID), data)
+	})
+}
+
+func (r *Repository) ModifySchedule(id string, modify func(schedule *models.Schedule) bool) (*models.Schedule, bool, error) {
+	var schedule *models.Schedule
+	modified := false
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(schedulesBucket)
+
+		data := b.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+		}
+
+		var err error
+		if schedule, err = storage.DecodeSchedule(data); err != nil {
+			return err
+		}
+
+		if !modify(schedule) {
+			return nil
+		}
+		modified = true
+
+		if data, err = storage.EncodeSchedule(schedule); err != nil {
+			return fmt.Errorf("encode schedule: %w", err)
+		}
+		return b.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return schedule, modified, nil
+}
+
+func (r *Repository) DeleteSchedule(id string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(schedulesBucket)
+		if b.Get([]byte(id)) == nil {
+			return fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// track keeps unfinished runs in memory and forgets finished ones. It must be
+// called with mu held.
+func (r *Repository) track(run *models.Run) {
+	if storage.Finished(run) {
+		delete(r.live, run.ID)
+		return
+	}
+	r.live[run.ID] = run
+}
+
+// decodeRun returns the live run for id, or decodes data. It must be called
+// with mu held.
+func (r *Repository) decodeRun(id, data []byte) (*models.Run, error) {
+	if run, ok := r.live[string(id)]; ok {
+		return run, nil
+	}
+
+	if data == nil {
+		return nil, fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+	}
+
+	return storage.DecodeRun(data)
+}
@@ -0,0 +1,37 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/bdtfs/gnat/internal/storage/storagetest"
+)
+
+func TestRepository(t *testing.T) {
+	paths := make(map[storage.Repository]string)
+
+	open := func(t *testing.T, path string) storage.Repository {
+		t.Helper()
+
+		repo, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		paths[repo] = path
+
+		return repo
+	}
+
+	storagetest.Run(t, storagetest.Factory{
+		New: func(t *testing.T) storage.Repository {
+			return open(t, filepath.Join(t.TempDir(), "gnat.db"))
+		},
+		Reopen: func(t *testing.T, repo storage.Repository) storage.Repository {
+			if err := repo.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			return open(t, paths[repo])
+		},
+	})
+}
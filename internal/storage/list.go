@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type SortField string
+
+const (
+	SortCreatedAt SortField = "created_at"
+	SortStatus    SortField = "status"
+	SortName      SortField = "name"
+)
+
+// Page selects a window of a sorted list. Items are ordered by the sort field
+// and then by ID. The zero Page returns everything sorted by creation time.
+type Page struct {
+	Sort SortField
+	Desc bool
+	// Limit caps the number of items; zero means no limit.
+	Limit int
+	// Cursor is the next cursor returned for the previous page. It is only
+	// valid with the same sort field and direction.
+	Cursor string
+}
+
+// Cursor is the position of the last item of a page.
+type Cursor struct {
+	Sort SortField `json:"s"`
+	Desc bool      `json:"d,omitempty"`
+	Key  string    `json:"k"`
+	ID   string    `json:"i"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns the position the page starts after, or nil for the
+// first page.
+func (p Page) DecodeCursor() (*Cursor, error) {
+	if p.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.Sort != p.sort() || c.Desc != p.Desc {
+		return nil, fmt.Errorf("%w: it was issued for another sort order", ErrInvalidCursor)
+	}
+
+	return &c, nil
+}
+
+// Next returns the cursor of the page after the item with the given sort key
+// and ID.
+func (p Page) Next(key, id string) string {
+	return Cursor{Sort: p.sort(), Desc: p.Desc, Key: key, ID: id}.Encode()
+}
+
+func (p Page) sort() SortField {
+	if p.Sort == "" {
+		return SortCreatedAt
+	}
+	return p.Sort
+}
+
+// TimeKey formats a time as a sort key. Keys are truncated to microseconds,
+// the precision of SQL timestamps, and sort lexically in time order.
+func TimeKey(t time.Time) string {
+	return t.UTC().Truncate(time.Microsecond).Format(timeKeyLayout)
+}
+
+func ParseTimeKey(key string) (time.Time, error) {
+	t, err := time.Parse(timeKeyLayout, key)
+	if err != nil {
+		return time.Time{}, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+const timeKeyLayout = "2006-01-02T15:04:05.000000Z"
+
+// SetupFilter selects setups. Zero fields do not filter.
+type SetupFilter struct {
+	Status []models.SetupStatus
+	// Name matches setups whose name contains it, ignoring case.
+	Name string
+	// CreatedFrom and CreatedTo bound the creation time, inclusive and
+	// exclusive respectively.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+func (f SetupFilter) Matches(setup *models.Setup) bool {
+	if len(f.Status) > 0 && !slices.Contains(f.Status, setup.Status) {
+		return false
+	}
+
+	if f.Name != "" && !containsFold(setup.Name, f.Name) {
+		return false
+	}
+
+	if !f.CreatedFrom.IsZero() && setup.CreatedAt.Before(f.CreatedFrom) {
+		return false
+	}
+
+	return f.CreatedTo.IsZero() || setup.CreatedAt.Before(f.CreatedTo)
+}
+
+// SetupKey returns the sort key of a setup.
+func SetupKey(setup *models.Setup, field SortField) string {
+	switch field {
+	case SortStatus:
+		return string(setup.Status)
+	case SortName:
+		return setup.Name
+	default:
+		return TimeKey(setup.CreatedAt)
+	}
+}
+
+// RunKey returns the sort key of a run. Runs are named after their setup and
+// created when they are queued.
+func RunKey(run *models.Run, field SortField) string {
+	switch field {
+	case SortStatus:
+		return string(Status(run))
+	case SortName:
+		return run.SetupName
+	default:
+		return TimeKey(run.QueuedAt)
+	}
+}
+
+// PageSetups sorts setups in place and returns the requested page with the
+// cursor of the next one, which is empty on the last page.
+func PageSetups(setups []*models.Setup, page Page) ([]*models.Setup, string, error) {
+	return paginate(setups, page, func(s *models.Setup) (string, string) {
+		return SetupKey(s, page.sort()), s.ID
+	})
+}
+
+// PageRuns is PageSetups for runs.
+func PageRuns(runs []*models.Run, page Page) ([]*models.Run, string, error) {
+	return paginate(runs, page, func(r *models.Run) (string, string) {
+		return RunKey(r, page.sort()), r.ID
+	})
+}
+
+func paginate[T any](items []T, page Page, key func(T) (string, string)) ([]T, string, error) {
+	switch page.sort() {
+	case SortCreatedAt, SortStatus, SortName:
+	default:
+		return nil, "", fmt.Errorf("unknown sort field %q", page.Sort)
+	}
+
+	after, err := page.DecodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	compare := func(aKey, aID, bKey, bID string) int {
+		c := cmp.Or(cmp.Compare(aKey, bKey), cmp.Compare(aID, bID))
+		if page.Desc {
+			return -c
+		}
+		return c
+	}
+
+	slices.SortFunc(items, func(a, b T) int {
+		aKey, aID := key(a)
+		bKey, bID := key(b)
+		return compare(aKey, aID, bKey, bID)
+	})
+
+	if after != nil {
+		start, _ := slices.BinarySearchFunc(items, after, func(item T, c *Cursor) int {
+			k, id := key(item)
+			if compare(k, id, c.Key, c.ID) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		items = items[start:]
+	}
+
+	if page.Limit <= 0 || len(items) <= page.Limit {
+		return items, "", nil
+	}
+
+	items = items[:page.Limit]
+	k, id := key(items[len(items)-1])
+
+	return items, page.Next(k, id), nil
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -2,33 +2,57 @@ package memory
 
 import (
 	"fmt"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
 )
 
 type Repository struct {
-	setups map[string]*models.Setup
-	runs   map[string]*models.Run
-	mu     sync.RWMutex
+	setups    map[string]*models.Setup
+	versions  map[string][]*models.Setup
+	runs      map[string]*models.Run
+	schedules map[string]*models.Schedule
+	mu        sync.RWMutex
 }
 
+var _ storage.Repository = (*Repository)(nil)
+
 func New() *Repository {
 	return &Repository{
-		setups: make(map[string]*models.Setup),
-		runs:   make(map[string]*models.Run),
+		setups:    make(map[string]*models.Setup),
+		versions:  make(map[string][]*models.Setup),
+		runs:      make(map[string]*models.Run),
+		schedules: make(map[string]*models.Schedule),
 	}
 }
 
 func (r *Repository) CreateSetup(setup *models.Setup) error {
+	return r.CreateSetupHistory([]*models.Setup{setup})
+}
+
+func (r *Repository) CreateSetupHistory(history []*models.Setup) error {
+	setup := history[len(history)-1]
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if _, exists := r.setups[setup.ID]; exists {
-		return fmt.Errorf("setup with id %s already exists", setup.ID)
+		return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrAlreadyExists)
+	}
+
+	versions := make([]*models.Setup, 0, len(history))
+	for _, v := range history {
+		if slices.ContainsFunc(versions, func(stored *models.Setup) bool { return stored.Version == v.Version }) {
+			return fmt.Errorf("setup with id %s version %d %w", v.ID, v.Version, storage.ErrAlreadyExists)
+		}
+		versions = append(versions, snapshot(v))
 	}
 
 	r.setups[setup.ID] = setup
+	r.versions[setup.ID] = versions
 	return nil
 }
 
@@ -38,22 +62,24 @@ func (r *Repository) GetSetup(id string) (*models.Setup, error) {
 
 	setup, exists := r.setups[id]
 	if !exists {
-		return nil, fmt.Errorf("setup with id %s not found", id)
+		return nil, fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
 	}
 
 	return setup, nil
 }
 
-func (r *Repository) ListSetups() []*models.Setup {
+func (r *Repository) ListSetups(filter storage.SetupFilter, page storage.Page) ([]*models.Setup, string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	setups := make([]*models.Setup, 0, len(r.setups))
 	for _, setup := range r.setups {
-		setups = append(setups, setup)
+		if filter.Matches(setup) {
+			setups = append(setups, setup)
+		}
 	}
 
-	return setups
+	return storage.PageSetups(setups, page)
 }
 
 func (r *Repository) UpdateSetup(setup *models.Setup) error {
@@ -61,73 +87,129 @@ func (r *Repository) UpdateSetup(setup *models.Setup) error {
 	defer r.mu.Unlock()
 
 	if _, exists := r.setups[setup.ID]; !exists {
-		return fmt.Errorf("setup with id %s not found", setup.ID)
+		return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrNotFound)
 	}
 
 	r.setups[setup.ID] = setup
 	return nil
 }
 
+func (r *Repository) SetSetupStatus(id string, status models.SetupStatus, at time.Time) (*models.Setup, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, exists := r.setups[id]
+	if !exists {
+		return nil, fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+	}
+
+	setup := *current
+	setup.Status = status
+	setup.UpdatedAt = at
+
+	r.setups[id] = &setup
+	return &setup, nil
+}
+
 func (r *Repository) DeleteSetup(id string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if _, exists := r.setups[id]; !exists {
-		return fmt.Errorf("setup with id %s not found", id)
+		return fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
 	}
 
 	delete(r.setups, id)
+	delete(r.versions, id)
 	return nil
 }
 
-func (r *Repository) CreateRun(run *models.Run) error {
+func (r *Repository) CreateSetupVersion(setup *models.Setup) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if _, exists := r.runs[run.ID]; exists {
-		return fmt.Errorf("run with id %s already exists", run.ID)
+	current, exists := r.setups[setup.ID]
+	if !exists {
+		return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrNotFound)
 	}
 
-	r.runs[run.ID] = run
+	versions := r.versions[setup.ID]
+	if slices.ContainsFunc(versions, func(v *models.Setup) bool { return v.Version == setup.Version }) {
+		return fmt.Errorf("setup with id %s version %d %w", setup.ID, setup.Version, storage.ErrAlreadyExists)
+	}
+
+	setup.Status = current.Status
+	r.setups[setup.ID] = setup
+	r.versions[setup.ID] = append(versions, snapshot(setup))
 	return nil
 }
 
-func (r *Repository) GetRun(id string) (*models.Run, error) {
+func (r *Repository) GetSetupVersion(id string, version int) (*models.Setup, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	run, exists := r.runs[id]
+	for _, v := range r.versions[id] {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("setup with id %s version %d %w", id, version, storage.ErrNotFound)
+}
+
+func (r *Repository) ListSetupVersions(id string) ([]*models.Setup, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions, exists := r.versions[id]
 	if !exists {
-		return nil, fmt.Errorf("run with id %s not found", id)
+		return nil, fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
 	}
 
-	return run, nil
+	return slices.Clone(versions), nil
+}
+
+func (r *Repository) CreateRun(run *models.Run) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.runs[run.ID]; exists {
+		return fmt.Errorf("run with id %s %w", run.ID, storage.ErrAlreadyExists)
+	}
+
+	r.runs[run.ID] = run
+	return nil
 }
 
-func (r *Repository) ListRuns() []*models.Run {
+func (r *Repository) GetRun(id string) (*models.Run, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	runs := make([]*models.Run, 0, len(r.runs))
-	for _, run := range r.runs {
-		runs = append(runs, run)
+	run, exists := r.runs[id]
+	if !exists {
+		return nil, fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
 	}
 
-	return runs
+	return run, nil
 }
 
-func (r *Repository) ListRunsBySetup(setupID string) []*models.Run {
+func (r *Repository) ListRuns(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	runs := make([]*models.Run, 0)
 	for _, run := range r.runs {
-		if run.SetupID == setupID {
+		if filter.Matches(run) {
 			runs = append(runs, run)
 		}
 	}
 
-	return runs
+	return storage.PageRuns(runs, page)
+}
+
+// ListRunSummaries returns the runs themselves, as they are not decoded.
+func (r *Repository) ListRunSummaries(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	return r.ListRuns(filter, page)
 }
 
 func (r *Repository) UpdateRun(run *models.Run) error {
@@ -135,21 +217,131 @@ func (r *Repository) UpdateRun(run *models.Run) error {
 	defer r.mu.Unlock()
 
 	if _, exists := r.runs[run.ID]; !exists {
-		return fmt.Errorf("run with id %s not found", run.ID)
+		return fmt.Errorf("run with id %s %w", run.ID, storage.ErrNotFound)
 	}
 
 	r.runs[run.ID] = run
 	return nil
 }
 
+func (r *Repository) ExpireRun(id string, now time.Time, finish func(run *models.Run)) (*models.Run, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run, exists := r.runs[id]
+	if !exists {
+		return nil, false, fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+	}
+
+	if !storage.Expired(run, now) {
+		return nil, false, nil
+	}
+
+	finish(run)
+	return run, true, nil
+}
+
 func (r *Repository) DeleteRun(id string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if _, exists := r.runs[id]; !exists {
-		return fmt.Errorf("run with id %s not found", id)
+		return fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
 	}
 
 	delete(r.runs, id)
 	return nil
 }
+
+func (r *Repository) CreateSchedule(schedule *models.Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.schedules[schedule.ID]; exists {
+		return fmt.Errorf("schedule with id %s %w", schedule.ID, storage.ErrAlreadyExists)
+	}
+
+	r.schedules[schedule.ID] = schedule
+	return nil
+}
+
+func (r *Repository) GetSchedule(id string) (*models.Schedule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schedule, exists := r.schedules[id]
+	if !exists {
+		return nil, fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+	}
+
+	return schedule, nil
+}
+
+func (r *Repository) ListSchedules() ([]*models.Schedule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schedules := make([]*models.Schedule, 0, len(r.schedules))
+	for _, schedule := range r.schedules {
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+func (r *Repository) UpdateSchedule(schedule *models.Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.schedules[schedule.ID]; !exists {
+		return fmt.Errorf("schedule with id %s %w", schedule.ID, storage.ErrNotFound)
+	}
+
+	r.schedules[schedule.ID] = schedule
+	return nil
+}
+
+func (r *Repository) ModifySchedule(id string, modify func(schedule *models.Schedule) bool) (*models.Schedule, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, exists := r.schedules[id]
+	if !exists {
+		return nil, false, fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+	}
+
+	// Stored schedules are never modified in place, so the copy must not
+	// share its history either.
+	schedule := *current
+	schedule.History = slices.Clone(current.History)
+
+	if !modify(&schedule) {
+		return current, false, nil
+	}
+
+	r.schedules[id] = &schedule
+	return &schedule, true, nil
+}
+
+func (r *Repository) DeleteSchedule(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.schedules[id]; !exists {
+		return fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+	}
+
+	delete(r.schedules, id)
+	return nil
+}
+
+func (r *Repository) Close() error {
+	return nil
+}
+
+// snapshot copies a setup, so that changing the current setup through
+// UpdateSetup leaves its versions as they were.
+func snapshot(setup *models.Setup) *models.Setup {
+	v := *setup
+	return &v
+}
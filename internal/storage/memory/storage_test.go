@@ -0,0 +1,16 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/bdtfs/gnat/internal/storage/storagetest"
+)
+
+func TestRepository(t *testing.T) {
+	storagetest.Run(t, storagetest.Factory{
+		New: func(t *testing.T) storage.Repository {
+			return New()
+		},
+	})
+}
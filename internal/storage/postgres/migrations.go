@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationLockID serializes migrations of instances that start at the same
+// time against the same database.
+const migrationLockID = 0x676e6174
+
+// Migrations are files named NNNN_description.sql, applied in order of NNNN.
+// New migrations are added as new files, existing ones are never changed.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(names))
+	for _, name := range names {
+		base := strings.TrimPrefix(name, "migrations/")
+
+		prefix, _, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name %s", base)
+		}
+
+		data, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: base, sql: string(data)})
+	}
+
+	slices.SortFunc(migrations, func(a, b migration) int { return a.version - b.version })
+
+	return migrations, nil
+}
+
+// migrate applies the pending migrations in a single transaction.
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    integer PRIMARY KEY,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return err
+		}
+
+		var version int
+		if err = tx.QueryRow(ctx, `SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+			return err
+		}
+
+		if latest := migrations[len(migrations)-1].version; version > latest {
+			return fmt.Errorf("schema version %d is newer than the supported version %d", version, latest)
+		}
+
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			if _, err = tx.Exec(ctx, m.sql); err != nil {
+				return fmt.Errorf("apply %s: %w", m.name, err)
+			}
+			if _, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
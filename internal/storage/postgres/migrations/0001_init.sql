@@ -0,0 +1,24 @@
+CREATE TABLE setups (
+    id         text PRIMARY KEY,
+    name       text NOT NULL,
+    created_at timestamptz NOT NULL,
+    data       jsonb NOT NULL
+);
+
+CREATE TABLE runs (
+    id         text PRIMARY KEY,
+    setup_id   text NOT NULL,
+    status     text NOT NULL,
+    labels     jsonb NOT NULL DEFAULT '{}',
+    started_at timestamptz NOT NULL,
+    data       jsonb NOT NULL
+);
+
+CREATE INDEX runs_setup_id_started_at_idx ON runs (setup_id, started_at);
+CREATE INDEX runs_started_at_idx ON runs (started_at);
+CREATE INDEX runs_labels_idx ON runs USING gin (labels);
+
+CREATE TABLE schedules (
+    id   text PRIMARY KEY,
+    data jsonb NOT NULL
+);
@@ -0,0 +1,19 @@
+-- Columns and indexes for sorting and filtering the setup and run lists.
+-- Identifiers and sort keys use the "C" collation, so that the order matches
+-- the other backends.
+
+ALTER TABLE setups ADD COLUMN status text NOT NULL DEFAULT '';
+UPDATE setups SET status = coalesce(data->>'Status', '');
+
+CREATE INDEX setups_created_at_idx ON setups (created_at, id COLLATE "C");
+CREATE INDEX setups_name_idx ON setups (name COLLATE "C", id COLLATE "C");
+
+ALTER TABLE runs ADD COLUMN setup_name text NOT NULL DEFAULT '';
+ALTER TABLE runs ADD COLUMN queued_at timestamptz;
+UPDATE runs SET
+    setup_name = coalesce(data->>'setup_name', ''),
+    queued_at = (data->>'queued_at')::timestamptz;
+ALTER TABLE runs ALTER COLUMN queued_at SET NOT NULL;
+
+CREATE INDEX runs_queued_at_idx ON runs (queued_at, id COLLATE "C");
+CREATE INDEX runs_status_idx ON runs (status COLLATE "C", id COLLATE "C");
@@ -0,0 +1,15 @@
+-- Every version of a setup is kept, so runs can refer to the one they
+-- executed. Existing setups become their first version.
+
+CREATE TABLE setup_versions (
+    setup_id   text NOT NULL REFERENCES setups (id) ON DELETE CASCADE,
+    version    integer NOT NULL,
+    created_at timestamptz NOT NULL,
+    data       jsonb NOT NULL,
+    PRIMARY KEY (setup_id, version)
+);
+
+UPDATE setups SET data = jsonb_set(data, '{Version}', '1');
+
+INSERT INTO setup_versions (setup_id, version, created_at, data)
+SELECT id, 1, created_at, data FROM setups;
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bdtfs/gnat/internal/labels"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+// Sort columns use the "C" collation, like the indexes in the migrations, so
+// that rows are ordered byte-wise as in the other backends.
+var (
+	setupSortColumns = map[storage.SortField]string{
+		storage.SortCreatedAt: "created_at",
+		storage.SortStatus:    `status COLLATE "C"`,
+		storage.SortName:      `name COLLATE "C"`,
+	}
+	runSortColumns = map[storage.SortField]string{
+		storage.SortCreatedAt: "queued_at",
+		storage.SortStatus:    `status COLLATE "C"`,
+		storage.SortName:      `setup_name COLLATE "C"`,
+	}
+)
+
+// query builds a SELECT with numbered arguments.
+type query struct {
+	conds []string
+	args  []any
+}
+
+func (q *query) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *query) where(format string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, a := range args {
+		placeholders[i] = q.arg(a)
+	}
+	q.conds = append(q.conds, fmt.Sprintf(format, placeholders...))
+}
+
+// build returns the query with the conditions, and the order and limit of
+// the page. It asks for one row more than the limit, so that the caller can
+// tell whether there is a next page.
+func (q *query) build(selectFrom string, page storage.Page, columns map[storage.SortField]string) (string, []any, error) {
+	field := page.Sort
+	if field == "" {
+		field = storage.SortCreatedAt
+	}
+
+	column, ok := columns[field]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown sort field %q", field)
+	}
+
+	direction, compare := "ASC", ">"
+	if page.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	after, err := page.DecodeCursor()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if after != nil {
+		var key any = after.Key
+		if field == storage.SortCreatedAt {
+			if key, err = storage.ParseTimeKey(after.Key); err != nil {
+				return "", nil, err
+			}
+		}
+		q.where(`(`+column+`, id COLLATE "C") `+compare+` (%s, %s)`, key, after.ID)
+	}
+
+	sql := selectFrom
+	if len(q.conds) > 0 {
+		sql += " WHERE " + strings.Join(q.conds, " AND ")
+	}
+
+	sql += fmt.Sprintf(` ORDER BY %s %s, id COLLATE "C" %s`, column, direction, direction)
+
+	if page.Limit > 0 {
+		sql += " LIMIT " + q.arg(page.Limit+1)
+	}
+
+	return sql, q.args, nil
+}
+
+func setupsQuery(filter storage.SetupFilter, page storage.Page) (string, []any, error) {
+	var q query
+
+	if len(filter.Status) > 0 {
+		statuses := make([]string, len(filter.Status))
+		for i, status := range filter.Status {
+			statuses[i] = string(status)
+		}
+		q.where("status = ANY(%s::text[])", statuses)
+	}
+	if filter.Name != "" {
+		q.where("strpos(lower(name), lower(%s)) > 0", filter.Name)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		q.where("created_at >= %s", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		q.where("created_at < %s", filter.CreatedTo)
+	}
+
+	return q.build("SELECT data FROM setups", page, setupSortColumns)
+}
+
+// runsQuery translates a filter into a query of columns over the indexed
+// columns of the runs table.
+func runsQuery(columns string, filter storage.RunFilter, page storage.Page) (string, []any, error) {
+	var q query
+
+	if filter.SetupID != "" {
+		q.where("setup_id = %s", filter.SetupID)
+	}
+	if len(filter.Status) > 0 {
+		statuses := make([]string, len(filter.Status))
+		for i, status := range filter.Status {
+			statuses[i] = string(status)
+		}
+		q.where("status = ANY(%s::text[])", statuses)
+	}
+	if filter.Name != "" {
+		q.where("strpos(lower(setup_name), lower(%s)) > 0", filter.Name)
+	}
+	if !filter.StartedFrom.IsZero() {
+		q.where("started_at >= %s", filter.StartedFrom)
+	}
+	if !filter.StartedTo.IsZero() {
+		q.where("started_at < %s", filter.StartedTo)
+	}
+
+	for _, req := range filter.Labels.Requirements() {
+		switch req.Op {
+		case labels.OpEquals:
+			q.where("labels @> %s::jsonb", map[string]string{req.Key: req.Values[0]})
+		case labels.OpNotEquals:
+			q.where("NOT labels @> %s::jsonb", map[string]string{req.Key: req.Values[0]})
+		case labels.OpIn:
+			q.where("labels->>%s = ANY(%s::text[])", req.Key, req.Values)
+		case labels.OpNotIn:
+			q.where("coalesce(labels->>%s <> ALL(%s::text[]), true)", req.Key, req.Values)
+		case labels.OpExists:
+			q.where("labels ? %s", req.Key)
+		case labels.OpNotExists:
+			q.where("NOT labels ? %s", req.Key)
+		}
+	}
+
+	return q.build("SELECT "+columns+" FROM runs", page, runSortColumns)
+}
@@ -0,0 +1,729 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Options tune the connection pool. Zero values keep the defaults.
+type Options struct {
+	// MaxConns caps the pool size; pgx defaults to the larger of 4 and the
+	// number of CPUs.
+	MaxConns int
+	// Timeout bounds every query.
+	Timeout time.Duration
+}
+
+// Repository stores everything in PostgreSQL so that several instances can
+// share setups, runs and schedules. Like the bolt backend it keeps the runs
+// started by this instance in memory until they finish.
+type Repository struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+	live    map[string]*models.Run
+	mu      sync.RWMutex
+}
+
+var _ storage.Repository = (*Repository)(nil)
+
+// Open connects to the database at dsn and migrates it to the current schema.
+func Open(dsn string, opts Options) (*Repository, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = int32(opts.MaxConns)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	if err = migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return &Repository{
+		pool:    pool,
+		timeout: opts.Timeout,
+		live:    make(map[string]*models.Run),
+	}, nil
+}
+
+// Shared reports that other instances may use the database too.
+func (r *Repository) Shared() bool {
+	return true
+}
+
+func (r *Repository) Close() error {
+	r.pool.Close()
+	return nil
+}
+
+func (r *Repository) CreateSetup(setup *models.Setup) error {
+	return r.CreateSetupHistory([]*models.Setup{setup})
+}
+
+func (r *Repository) CreateSetupHistory(history []*models.Setup) error {
+	setup := history[len(history)-1]
+
+	encoded := make([][]byte, len(history))
+	for i, v := range history {
+		data, err := storage.EncodeSetup(v)
+		if err != nil {
+			return fmt.Errorf("encode setup: %w", err)
+		}
+		encoded[i] = data
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO setups (id, name, status, created_at, data) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO NOTHING`,
+			setup.ID, setup.Name, setup.Status, setup.CreatedAt, encoded[len(encoded)-1])
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrAlreadyExists)
+		}
+
+		for i, v := range history {
+			if err = insertVersion(ctx, tx, v, encoded[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *Repository) GetSetup(id string) (*models.Setup, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var data []byte
+	err := r.pool.QueryRow(ctx, `SELECT data FROM setups WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.DecodeSetup(data)
+}
+
+// ListSetups filters, sorts and pages setups in SQL.
+func (r *Repository) ListSetups(filter storage.SetupFilter, page storage.Page) ([]*models.Setup, string, error) {
+	query, args, err := setupsQuery(filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	setups := make([]*models.Setup, 0)
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, "", err
+		}
+
+		setup, err := storage.DecodeSetup(data)
+		if err != nil {
+			return nil, "", err
+		}
+		setups = append(setups, setup)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if page.Limit <= 0 || len(setups) <= page.Limit {
+		return setups, "", nil
+	}
+
+	setups = setups[:page.Limit]
+	last := setups[len(setups)-1]
+
+	return setups, page.Next(storage.SetupKey(last, page.Sort), last.ID), nil
+}
+
+func (r *Repository) UpdateSetup(setup *models.Setup) error {
+	data, err := storage.EncodeSetup(setup)
+	if err != nil {
+		return fmt.Errorf("encode setup: %w", err)
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `UPDATE setups SET name = $2, status = $3, data = $4 WHERE id = $1`,
+		setup.ID, setup.Name, setup.Status, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+// SetSetupStatus changes the status inside the stored row, so that a version
+// written concurrently is kept.
+func (r *Repository) SetSetupStatus(id string, status models.SetupStatus, at time.Time) (*models.Setup, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var data []byte
+	err := r.pool.QueryRow(ctx,
+		`UPDATE setups SET status = $2, data = data || jsonb_build_object('Status', $2::text, 'UpdatedAt', $3::text)
+		WHERE id = $1 RETURNING data`,
+		id, string(status), at.Format(time.RFC3339Nano)).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.DecodeSetup(data)
+}
+
+func (r *Repository) DeleteSetup(id string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM setups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateSetupVersion(setup *models.Setup) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var status models.SetupStatus
+		err := tx.QueryRow(ctx, `SELECT status FROM setups WHERE id = $1 FOR UPDATE`, setup.ID).Scan(&status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("setup with id %s %w", setup.ID, storage.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		setup.Status = status
+
+		data, err := storage.EncodeSetup(setup)
+		if err != nil {
+			return fmt.Errorf("encode setup: %w", err)
+		}
+
+		if _, err = tx.Exec(ctx, `UPDATE setups SET name = $2, data = $3 WHERE id = $1`, setup.ID, setup.Name, data); err != nil {
+			return err
+		}
+
+		return insertVersion(ctx, tx, setup, data)
+	})
+}
+
+func insertVersion(ctx context.Context, tx pgx.Tx, setup *models.Setup, data []byte) error {
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO setup_versions (setup_id, version, created_at, data) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+		setup.ID, setup.Version, setup.UpdatedAt, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("setup with id %s version %d %w", setup.ID, setup.Version, storage.ErrAlreadyExists)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetSetupVersion(id string, version int) (*models.Setup, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var data []byte
+	err := r.pool.QueryRow(ctx, `SELECT data FROM setup_versions WHERE setup_id = $1 AND version = $2`, id, version).
+		Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("setup with id %s version %d %w", id, version, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.DecodeSetup(data)
+}
+
+func (r *Repository) ListSetupVersions(id string) ([]*models.Setup, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, `SELECT data FROM setup_versions WHERE setup_id = $1 ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]*models.Setup, 0)
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		setup, err := storage.DecodeSetup(data)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, setup)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("setup with id %s %w", id, storage.ErrNotFound)
+	}
+
+	return versions, nil
+}
+
+func (r *Repository) CreateRun(run *models.Run) error {
+	data, err := storage.EncodeRun(run)
+	if err != nil {
+		return fmt.Errorf("encode run: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx,
+		`INSERT INTO runs (id, setup_id, setup_name, status, labels, queued_at, started_at, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (id) DO NOTHING`,
+		run.ID, run.SetupID, run.SetupName, run.Status, runLabels(run), run.QueuedAt, run.StartedAt, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("run with id %s %w", run.ID, storage.ErrAlreadyExists)
+	}
+
+	r.track(run)
+	return nil
+}
+
+func (r *Repository) GetRun(id string) (*models.Run, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if run, ok := r.live[id]; ok {
+		return run, nil
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var data []byte
+	err := r.pool.QueryRow(ctx, `SELECT data FROM runs WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.DecodeRun(data)
+}
+
+// ListRuns filters, sorts and pages runs in SQL. Live runs are matched
+// against the filter again, since their stored row may lag behind the runner
+// by up to a checkpoint; they are sorted by their stored row.
+func (r *Repository) ListRuns(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	return r.listRuns(`id, data`, filter, page)
+}
+
+// ListRunSummaries leaves the events and stats out of the rows it reads.
+func (r *Repository) ListRunSummaries(filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	return r.listRuns(`id, data - 'events' - 'stats'`, filter, page)
+}
+
+func (r *Repository) listRuns(columns string, filter storage.RunFilter, page storage.Page) ([]*models.Run, string, error) {
+	query, args, err := runsQuery(columns, filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var (
+		runs  = make([]*models.Run, 0)
+		count int
+		last  []byte
+	)
+
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+		)
+		if err = rows.Scan(&id, &data); err != nil {
+			return nil, "", err
+		}
+
+		// The query asks for one row more than the limit to tell whether
+		// there is a next page.
+		if count++; page.Limit > 0 && count > page.Limit {
+			break
+		}
+		last = data
+
+		if run, ok := r.live[id]; ok {
+			if filter.Matches(run) {
+				runs = append(runs, run)
+			}
+			continue
+		}
+
+		run, err := storage.DecodeRun(data)
+		if err != nil {
+			return nil, "", err
+		}
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if page.Limit <= 0 || count <= page.Limit {
+		return runs, "", nil
+	}
+
+	stored, err := storage.DecodeRun(last)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return runs, page.Next(storage.RunKey(stored, page.Sort), stored.ID), nil
+}
+
+func (r *Repository) UpdateRun(run *models.Run) error {
+	data, err := storage.EncodeRun(run)
+	if err != nil {
+		return fmt.Errorf("encode run: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	run.StateMu.RLock()
+	status, startedAt := run.Status, run.StartedAt
+	run.StateMu.RUnlock()
+
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE runs SET status = $2, labels = $3, started_at = $4, data = $5 WHERE id = $1`,
+		run.ID, status, runLabels(run), startedAt, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("run with id %s %w", run.ID, storage.ErrNotFound)
+	}
+
+	r.track(run)
+	return nil
+}
+
+// ExpireRun reads the stored row rather than a live run, and only writes it
+// back if its status and lease are still the ones that were read.
+func (r *Repository) ExpireRun(id string, now time.Time, finish func(run *models.Run)) (*models.Run, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var data []byte
+	var lease string
+	err := r.pool.QueryRow(ctx, `SELECT data, data->>'lease_expires_at' FROM runs WHERE id = $1`, id).Scan(&data, &lease)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	run, err := storage.DecodeRun(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !storage.Expired(run, now) {
+		return nil, false, nil
+	}
+
+	previous := run.Status
+	finish(run)
+
+	if data, err = storage.EncodeRun(run); err != nil {
+		return nil, false, fmt.Errorf("encode run: %w", err)
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE runs SET status = $2, labels = $3, started_at = $4, data = $5
+		WHERE id = $1 AND status = $6 AND data->>'lease_expires_at' = $7`,
+		run.ID, run.Status, runLabels(run), run.StartedAt, data, previous, lease)
+	if err != nil {
+		return nil, false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, false, nil
+	}
+
+	r.track(run)
+	return run, true, nil
+}
+
+func (r *Repository) DeleteRun(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM runs WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("run with id %s %w", id, storage.ErrNotFound)
+	}
+
+	delete(r.live, id)
+	return nil
+}
+
+func (r *Repository) CreateSchedule(schedule *models.Schedule) error {
+	data, err := storage.EncodeSchedule(schedule)
+	if err != nil {
+		return fmt.Errorf("encode schedule: %w", err)
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `INSERT INTO schedules (id, data) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`,
+		schedule.ID, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("schedule with id %s %w", schedule.ID, storage.ErrAlreadyExists)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetSchedule(id string) (*models.Schedule, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var data []byte
+	err := r.pool.QueryRow(ctx, `SELECT data FROM schedules WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.DecodeSchedule(data)
+}
+
+func (r *Repository) ListSchedules() ([]*models.Schedule, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, `SELECT data FROM schedules`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make([]*models.Schedule, 0)
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		schedule, err := storage.DecodeSchedule(data)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+func (r *Repository) UpdateSchedule(schedule *models.Schedule) error {
+	data, err := storage.EncodeSchedule(schedule)
+	if err != nil {
+		return fmt.Errorf("encode schedule: %w", err)
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `UPDATE schedules SET data = $2 WHERE id = $1`, schedule.ID, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("schedule with id %s %w", schedule.ID, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+// ModifySchedule locks the row for the duration of modify, so instances that
+// modify the same schedule take turns.
+func (r *Repository) ModifySchedule(id string, modify func(schedule *models.Schedule) bool) (*models.Schedule, bool, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	var schedule *models.Schedule
+	modified := false
+
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var data []byte
+		err := tx.QueryRow(ctx, `SELECT data FROM schedules WHERE id = $1 FOR UPDATE`, id).Scan(&data)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		if schedule, err = storage.DecodeSchedule(data); err != nil {
+			return err
+		}
+
+		if !modify(schedule) {
+			return nil
+		}
+		modified = true
+
+		if data, err = storage.EncodeSchedule(schedule); err != nil {
+			return fmt.Errorf("encode schedule: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE schedules SET data = $2 WHERE id = $1`, id, data)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return schedule, modified, nil
+}
+
+func (r *Repository) DeleteSchedule(id string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM schedules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("schedule with id %s %w", id, storage.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.timeout)
+}
+
+// track keeps unfinished runs in memory and forgets finished ones. It must be
+// called with mu held.
+func (r *Repository) track(run *models.Run) {
+	if storage.Finished(run) {
+		delete(r.live, run.ID)
+		return
+	}
+	r.live[run.ID] = run
+}
+
+// runLabels returns the labels column of a run. It is never null so that
+// negated label conditions match runs without labels.
+func runLabels(run *models.Run) map[string]string {
+	if run.Labels == nil {
+		return map[string]string{}
+	}
+	return run.Labels
+}
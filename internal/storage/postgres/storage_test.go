@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bdtfs/gnat/internal/storage"
+	"github.com/bdtfs/gnat/internal/storage/storagetest"
+)
+
+// The suite needs a disposable database, named by GNAT_TEST_POSTGRES_DSN.
+// Every test empties its tables.
+const dsnEnv = "GNAT_TEST_POSTGRES_DSN"
+
+func TestRepository(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skip(dsnEnv + " is not set")
+	}
+
+	open := func(t *testing.T) *Repository {
+		t.Helper()
+
+		repo, err := Open(dsn, Options{})
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+
+		return repo
+	}
+
+	storagetest.Run(t, storagetest.Factory{
+		New: func(t *testing.T) storage.Repository {
+			repo := open(t)
+
+			_, err := repo.pool.Exec(context.Background(), `TRUNCATE setups, setup_versions, runs, schedules`)
+			if err != nil {
+				t.Fatalf("truncate: %v", err)
+			}
+
+			return repo
+		},
+		Reopen: func(t *testing.T, repo storage.Repository) storage.Repository {
+			if err := repo.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			return open(t)
+		},
+	})
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/histogram"
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+// Runs and their stats are stored through these records rather than encoded
+// directly, so that live runs are read under their locks and the stored format
+// does not depend on the synchronization fields of the models.
+
+type runRecord struct {
+	ID           string            `json:"id"`
+	SetupID      string            `json:"setup_id"`
+	SetupVersion int               `json:"setup_version,omitempty"`
+	SetupName    string            `json:"setup_name,omitempty"`
+	Status       models.RunStatus  `json:"status"`
+	RPS          int               `json:"rps"`
+	Priority     int               `json:"priority"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Notes        string            `json:"notes,omitempty"`
+	QueuedAt     time.Time         `json:"queued_at"`
+	StartedAt    time.Time         `json:"started_at"`
+	EndedAt      time.Time         `json:"ended_at"`
+	Error        string            `json:"error,omitempty"`
+	Events       []models.RunEvent `json:"events,omitempty"`
+	Stats        *statsRecord      `json:"stats,omitempty"`
+
+	RestartOf      string    `json:"restart_of,omitempty"`
+	CheckpointedAt time.Time `json:"checkpointed_at"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	CompactedAt    time.Time `json:"compacted_at"`
+}
+
+type statsRecord struct {
+	TotalRequests     uint64 `json:"total_requests"`
+	SuccessRequests   uint64 `json:"success_requests"`
+	FailedRequests    uint64 `json:"failed_requests"`
+	CancelledRequests uint64 `json:"cancelled_requests"`
+	TotalBytesRead    uint64 `json:"total_bytes_read"`
+
+	Attempts            uint64 `json:"attempts"`
+	FirstAttemptSuccess uint64 `json:"first_attempt_success"`
+
+	StatusCodes map[int]uint64 `json:"status_codes,omitempty"`
+
+	Latencies      *histogram.Histogram   `json:"latencies"`
+	TotalLatency   time.Duration          `json:"total_latency"`
+	LatencySummary *models.LatencySummary `json:"latency_summary,omitempty"`
+
+	ConnectionsOpened     uint64        `json:"connections_opened"`
+	ConnectionErrors      uint64        `json:"connection_errors"`
+	TotalConnectLatency   time.Duration `json:"total_connect_latency"`
+	Handshakes            uint64        `json:"handshakes"`
+	TotalHandshakeLatency time.Duration `json:"total_handshake_latency"`
+
+	Errors     []string                          `json:"errors,omitempty"`
+	Operations map[string]*models.OperationStats `json:"operations,omitempty"`
+	Sources    map[string]*models.SourceStats    `json:"sources,omitempty"`
+	RemoteIPs  map[string]uint64                 `json:"remote_ips,omitempty"`
+	Samples    []*models.Sample                  `json:"samples,omitempty"`
+}
+
+func EncodeSetup(setup *models.Setup) ([]byte, error) {
+	return json.Marshal(setup)
+}
+
+func DecodeSetup(data []byte) (*models.Setup, error) {
+	var setup models.Setup
+	if err := json.Unmarshal(data, &setup); err != nil {
+		return nil, err
+	}
+	return &setup, nil
+}
+
+func EncodeSchedule(schedule *models.Schedule) ([]byte, error) {
+	return json.Marshal(schedule)
+}
+
+func DecodeSchedule(data []byte) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func EncodeRun(run *models.Run) ([]byte, error) {
+	run.EventsMu.RLock()
+	events := append([]models.RunEvent(nil), run.Events...)
+	run.EventsMu.RUnlock()
+
+	run.StateMu.RLock()
+	rec := &runRecord{
+		ID:           run.ID,
+		SetupID:      run.SetupID,
+		SetupVersion: run.SetupVersion,
+		SetupName:    run.SetupName,
+		Status:       run.Status,
+		RPS:          run.RPS,
+		Priority:     run.Priority,
+		Labels:       run.Labels,
+		Notes:        run.Notes,
+		QueuedAt:     run.QueuedAt,
+		StartedAt:    run.StartedAt,
+		EndedAt:      run.EndedAt,
+		Error:        run.Error,
+		Events:       events,
+
+		RestartOf:      run.RestartOf,
+		CheckpointedAt: run.CheckpointedAt,
+		LeaseExpiresAt: run.LeaseExpiresAt,
+		CompactedAt:    run.CompactedAt,
+	}
+	stats := run.Stats
+	run.StateMu.RUnlock()
+
+	rec.Stats = encodeStats(stats)
+	return json.Marshal(rec)
+}
+
+func DecodeRun(data []byte) (*models.Run, error) {
+	var rec runRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+
+	return rec.model(), nil
+}
+
+// DecodeRunSummary decodes a run without its events and stats, which are
+// skipped rather than decoded.
+func DecodeRunSummary(data []byte) (*models.Run, error) {
+	var rec struct {
+		runRecord
+		Events skipped `json:"events"`
+		Stats  skipped `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+
+	return rec.model(), nil
+}
+
+// skipped is decoded from any JSON value without keeping it.
+type skipped struct{}
+
+func (skipped) UnmarshalJSON([]byte) error { return nil }
+
+func (rec *runRecord) model() *models.Run {
+	return &models.Run{
+		ID:           rec.ID,
+		SetupID:      rec.SetupID,
+		SetupVersion: rec.SetupVersion,
+		SetupName:    rec.SetupName,
+		Status:       rec.Status,
+		RPS:          rec.RPS,
+		Priority:     rec.Priority,
+		Labels:       rec.Labels,
+		Notes:        rec.Notes,
+		QueuedAt:     rec.QueuedAt,
+		StartedAt:    rec.StartedAt,
+		EndedAt:      rec.EndedAt,
+		Error:        rec.Error,
+		Events:       rec.Events,
+		Stats:        decodeStats(rec.Stats),
+
+		RestartOf:      rec.RestartOf,
+		CheckpointedAt: rec.CheckpointedAt,
+		LeaseExpiresAt: rec.LeaseExpiresAt,
+		CompactedAt:    rec.CompactedAt,
+	}
+}
+
+// MaxStoredErrors caps the distinct errors stored with a run. A failing run
+// repeats the same few errors, and every checkpoint would otherwise rewrite
+// each occurrence.
+const MaxStoredErrors = 100
+
+// DistinctErrors returns the first limit distinct errors of errs, in order.
+func DistinctErrors(errs []string, limit int) []string {
+	var out []string
+	seen := make(map[string]bool)
+
+	for _, e := range errs {
+		if len(out) == limit {
+			break
+		}
+		if !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+func encodeStats(s *models.Stats) *statsRecord {
+	if s == nil {
+		return nil
+	}
+
+	rec := &statsRecord{
+		TotalRequests:       atomic.LoadUint64(&s.TotalRequests),
+		SuccessRequests:     atomic.LoadUint64(&s.SuccessRequests),
+		FailedRequests:      atomic.LoadUint64(&s.FailedRequests),
+		CancelledRequests:   atomic.LoadUint64(&s.CancelledRequests),
+		TotalBytesRead:      atomic.LoadUint64(&s.TotalBytesRead),
+		Attempts:            atomic.LoadUint64(&s.Attempts),
+		FirstAttemptSuccess: atomic.LoadUint64(&s.FirstAttemptSuccess),
+		ConnectionErrors:    atomic.LoadUint64(&s.ConnectionErrors),
+	}
+
+	s.StatusMu.RLock()
+	rec.StatusCodes = make(map[int]uint64, len(s.StatusCodes))
+	for code, n := range s.StatusCodes {
+		rec.StatusCodes[code] = atomic.LoadUint64(n)
+	}
+	s.StatusMu.RUnlock()
+
+	s.LatencyMu.Lock()
+	rec.Latencies = s.Latencies.Clone()
+	rec.TotalLatency = s.TotalLatency
+	rec.LatencySummary = s.LatencySummary
+	s.LatencyMu.Unlock()
+
+	s.ConnectLatencyMu.Lock()
+	rec.ConnectionsOpened = atomic.LoadUint64(&s.ConnectionsOpened)
+	rec.TotalConnectLatency = s.TotalConnectLatency
+	rec.Handshakes = atomic.LoadUint64(&s.Handshakes)
+	rec.TotalHandshakeLatency = s.TotalHandshakeLatency
+	s.ConnectLatencyMu.Unlock()
+
+	s.ErrorsMu.RLock()
+	rec.Errors = DistinctErrors(s.Errors, MaxStoredErrors)
+	s.ErrorsMu.RUnlock()
+
+	s.OperationsMu.RLock()
+	rec.Operations = make(map[string]*models.OperationStats, len(s.Operations))
+	for name, op := range s.Operations {
+		c := *op
+		rec.Operations[name] = &c
+	}
+	s.OperationsMu.RUnlock()
+
+	s.SourcesMu.RLock()
+	rec.Sources = make(map[string]*models.SourceStats, len(s.Sources))
+	for ip, src := range s.Sources {
+		c := *src
+		rec.Sources[ip] = &c
+	}
+	s.SourcesMu.RUnlock()
+
+	s.RemoteIPsMu.RLock()
+	rec.RemoteIPs = make(map[string]uint64, len(s.RemoteIPs))
+	for ip, n := range s.RemoteIPs {
+		rec.RemoteIPs[ip] = atomic.LoadUint64(n)
+	}
+	s.RemoteIPsMu.RUnlock()
+
+	s.SamplesMu.RLock()
+	rec.Samples = append([]*models.Sample(nil), s.Samples...)
+	s.SamplesMu.RUnlock()
+
+	return rec
+}
+
+func decodeStats(rec *statsRecord) *models.Stats {
+	if rec == nil {
+		return nil
+	}
+
+	s := &models.Stats{
+		TotalRequests:         rec.TotalRequests,
+		SuccessRequests:       rec.SuccessRequests,
+		FailedRequests:        rec.FailedRequests,
+		CancelledRequests:     rec.CancelledRequests,
+		TotalBytesRead:        rec.TotalBytesRead,
+		Attempts:              rec.Attempts,
+		FirstAttemptSuccess:   rec.FirstAttemptSuccess,
+		StatusCodes:           make(map[int]*uint64, len(rec.StatusCodes)),
+		Latencies:             rec.Latencies,
+		TotalLatency:          rec.TotalLatency,
+		LatencySummary:        rec.LatencySummary,
+		ConnectionsOpened:     rec.ConnectionsOpened,
+		ConnectionErrors:      rec.ConnectionErrors,
+		TotalConnectLatency:   rec.TotalConnectLatency,
+		Handshakes:            rec.Handshakes,
+		TotalHandshakeLatency: rec.TotalHandshakeLatency,
+		Errors:                rec.Errors,
+		Operations:            rec.Operations,
+		Sources:               rec.Sources,
+		RemoteIPs:             make(map[string]*uint64, len(rec.RemoteIPs)),
+		Samples:               rec.Samples,
+	}
+
+	for code, n := range rec.StatusCodes {
+		s.StatusCodes[code] = &n
+	}
+
+	for ip, n := range rec.RemoteIPs {
+		s.RemoteIPs[ip] = &n
+	}
+
+	if s.Latencies == nil {
+		s.Latencies = histogram.New()
+	}
+	if s.Errors == nil {
+		s.Errors = make([]string, 0)
+	}
+	if s.Operations == nil {
+		s.Operations = make(map[string]*models.OperationStats)
+	}
+	if s.Sources == nil {
+		s.Sources = make(map[string]*models.SourceStats)
+	}
+	if s.Samples == nil {
+		s.Samples = make([]*models.Sample, 0)
+	}
+
+	return s
+}
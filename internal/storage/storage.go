@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/labels"
+	"github.com/bdtfs/gnat/internal/models"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+// Repository stores setups, runs and schedules. Implementations return errors
+// wrapping ErrNotFound and ErrAlreadyExists.
+//
+// The runner keeps mutating a run while it is active, so a repository must
+// hand out the pointer it was given for runs that have not finished yet.
+// Finished runs may be returned as fresh copies.
+//
+// Setups are versioned. CreateSetup keeps the setup as its first version.
+// CreateSetupHistory creates a setup with its earlier versions at once:
+// history is in version order and ends with the current version, and nothing
+// is stored if any of it fails. CreateSetupVersion replaces a setup and keeps the new version, failing with
+// ErrAlreadyExists if that version is taken; it keeps the stored status and
+// sets it on setup. UpdateSetup replaces a setup
+// without keeping a version, for changes that do not affect what runs
+// execute. SetSetupStatus changes only the status and update time of the
+// stored setup, so it never undoes a version written in the meantime.
+// DeleteSetup deletes every version.
+//
+// ExpireRun finishes a run that the instance executing it left behind: if the
+// stored run is unfinished and its lease expired before now, finish is applied
+// to it and the finished run is stored and returned. The check and the write are atomic, so when
+// several instances expire the same run only one of them gets true, and a run
+// whose lease was renewed in the meantime is left alone.
+//
+// ModifySchedule applies modify to the stored schedule and stores the result
+// if modify returns true. The read and the write are atomic, so instances
+// sharing the repository can claim a due schedule by checking that it is still
+// due, and record what they did without overwriting each other's changes.
+//
+// List methods return the page of matching items selected by page, and the
+// cursor of the next page, which is empty on the last one. ListRunSummaries
+// lists the runs ListRuns would, but leaves out the events and stats of runs
+// it would have to decode for them.
+type Repository interface {
+	CreateSetup(setup *models.Setup) error
+	CreateSetupHistory(history []*models.Setup) error
+	GetSetup(id string) (*models.Setup, error)
+	ListSetups(filter SetupFilter, page Page) ([]*models.Setup, string, error)
+	UpdateSetup(setup *models.Setup) error
+	SetSetupStatus(id string, status models.SetupStatus, at time.Time) (*models.Setup, error)
+	DeleteSetup(id string) error
+	CreateSetupVersion(setup *models.Setup) error
+	GetSetupVersion(id string, version int) (*models.Setup, error)
+	ListSetupVersions(id string) ([]*models.Setup, error)
+
+	CreateRun(run *models.Run) error
+	GetRun(id string) (*models.Run, error)
+	ListRuns(filter RunFilter, page Page) ([]*models.Run, string, error)
+	ListRunSummaries(filter RunFilter, page Page) ([]*models.Run, string, error)
+	UpdateRun(run *models.Run) error
+	ExpireRun(id string, now time.Time, finish func(run *models.Run)) (*models.Run, bool, error)
+	DeleteRun(id string) error
+
+	CreateSchedule(schedule *models.Schedule) error
+	GetSchedule(id string) (*models.Schedule, error)
+	ListSchedules() ([]*models.Schedule, error)
+	UpdateSchedule(schedule *models.Schedule) error
+	ModifySchedule(id string, modify func(schedule *models.Schedule) bool) (*models.Schedule, bool, error)
+	DeleteSchedule(id string) error
+
+	Close() error
+}
+
+// RunFilter selects runs. Zero fields do not filter.
+type RunFilter struct {
+	SetupID string
+	Status  []models.RunStatus
+	// Name matches runs whose setup name contains it, ignoring case.
+	Name   string
+	Labels labels.Selector
+	// StartedFrom and StartedTo bound the start time, inclusive and
+	// exclusive respectively.
+	StartedFrom time.Time
+	StartedTo   time.Time
+}
+
+func (f RunFilter) Matches(run *models.Run) bool {
+	if f.SetupID != "" && run.SetupID != f.SetupID {
+		return false
+	}
+
+	run.StateMu.RLock()
+	status, startedAt := run.Status, run.StartedAt
+	run.StateMu.RUnlock()
+
+	if len(f.Status) > 0 && !slices.Contains(f.Status, status) {
+		return false
+	}
+
+	if f.Name != "" && !containsFold(run.SetupName, f.Name) {
+		return false
+	}
+
+	if !f.StartedFrom.IsZero() && startedAt.Before(f.StartedFrom) {
+		return false
+	}
+
+	if !f.StartedTo.IsZero() && !startedAt.Before(f.StartedTo) {
+		return false
+	}
+
+	return f.Labels.Matches(run.Labels)
+}
+
+// UnfinishedStatuses are the statuses of runs that the runner may still
+// change.
+var UnfinishedStatuses = []models.RunStatus{
+	models.RunStatusPending, models.RunStatusRunning, models.RunStatusPaused, models.RunStatusCancelling,
+}
+
+// Shared reports whether other instances may use repo at the same time, and
+// so may leave runs behind in it while this one is running. Repositories say
+// so by implementing Shared() bool.
+func Shared(repo Repository) bool {
+	s, ok := repo.(interface{ Shared() bool })
+	return ok && s.Shared()
+}
+
+// Expired reports whether a run is unfinished and its lease expired before
+// now, so that the instance executing it is presumed gone.
+func Expired(run *models.Run, now time.Time) bool {
+	return !Finished(run) && run.LeaseExpiresAt.Before(now)
+}
+
+// FinishedStatuses are the final statuses of runs.
+var FinishedStatuses = []models.RunStatus{
+	models.RunStatusCompleted, models.RunStatusFailed, models.RunStatusCancelled, models.RunStatusInterrupted,
+}
+
+// Finished reports whether a run has reached a final status and will no
+// longer be changed by the runner.
+func Finished(run *models.Run) bool {
+	return !slices.Contains(UnfinishedStatuses, Status(run))
+}
+
+// Status returns the status of a run, which the runner may be changing.
+func Status(run *models.Run) models.RunStatus {
+	run.StateMu.RLock()
+	defer run.StateMu.RUnlock()
+	return run.Status
+}
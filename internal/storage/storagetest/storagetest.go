@@ -0,0 +1,934 @@
+// Package storagetest is a conformance suite for storage.Repository
+// implementations.
+package storagetest
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bdtfs/gnat/internal/histogram"
+	"github.com/bdtfs/gnat/internal/labels"
+	"github.com/bdtfs/gnat/internal/models"
+	"github.com/bdtfs/gnat/internal/storage"
+)
+
+// Factory creates repositories for the suite.
+type Factory struct {
+	// New returns an empty repository. The suite closes it.
+	New func(t *testing.T) storage.Repository
+	// Reopen closes repo and opens the same storage again. It is nil for
+	// backends that do not persist anything.
+	Reopen func(t *testing.T, repo storage.Repository) storage.Repository
+}
+
+// Run runs the conformance suite against the repositories made by f.
+func Run(t *testing.T, f Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, f Factory)
+	}{
+		{"Setups", testSetups},
+		{"SetupVersions", testSetupVersions},
+		{"SetupHistory", testSetupHistory},
+		{"Runs", testRuns},
+		{"RunsBySetup", testRunsBySetup},
+		{"RunFilters", testRunFilters},
+		{"SetupFilters", testSetupFilters},
+		{"SetupPages", testSetupPages},
+		{"RunPages", testRunPages},
+		{"RunSummaries", testRunSummaries},
+		{"LiveRuns", testLiveRuns},
+		{"ExpiredRuns", testExpiredRuns},
+		{"FinishedRunStats", testFinishedRunStats},
+		{"CompactedRuns", testCompactedRuns},
+		{"Schedules", testSchedules},
+		{"ScheduleClaims", testScheduleClaims},
+		{"Persistence", testPersistence},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, f)
+		})
+	}
+}
+
+func open(t *testing.T, f Factory) storage.Repository {
+	t.Helper()
+
+	repo := f.New(t)
+	t.Cleanup(func() { _ = repo.Close() })
+
+	return repo
+}
+
+func newSetup(name string) *models.Setup {
+	setup := models.NewSetup(name, "description", "POST", "http://localhost/"+name, []byte(`{"a":1}`),
+		map[string]string{"X-Test": name}, 100, time.Minute)
+	setup.Retry = &models.RetryPolicy{MaxAttempts: 3, Statuses: []int{503}, Backoff: time.Second}
+	return setup
+}
+
+func newFinishedRun(setupID string) *models.Run {
+	run := models.NewRun(setupID)
+	run.Status = models.RunStatusCompleted
+	run.RPS = 50
+	run.Labels = map[string]string{"env": "staging"}
+	run.EndedAt = run.StartedAt.Add(time.Minute)
+	run.Events = []models.RunEvent{{Type: models.RunEventStarted, Timestamp: run.StartedAt, RPS: 50}}
+
+	hist := histogram.New()
+	for i := 1; i <= 1000; i++ {
+		hist.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	ok := uint64(990)
+	run.Stats = &models.Stats{
+		TotalRequests:   1000,
+		SuccessRequests: 990,
+		FailedRequests:  10,
+		StatusCodes:     map[int]*uint64{200: &ok},
+		Latencies:       hist,
+		TotalLatency:    hist.Sum,
+		Errors:          []string{"timeout"},
+		Operations:      map[string]*models.OperationStats{},
+		Sources:         map[string]*models.SourceStats{},
+		RemoteIPs:       map[string]*uint64{},
+	}
+
+	return run
+}
+
+func wantErr(t *testing.T, err, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Fatalf("got error %v, want %v", err, target)
+	}
+}
+
+func testSetups(t *testing.T, f Factory) {
+	repo := open(t, f)
+	setup := newSetup("a")
+
+	if err := repo.CreateSetup(setup); err != nil {
+		t.Fatalf("CreateSetup: %v", err)
+	}
+	wantErr(t, repo.CreateSetup(setup), storage.ErrAlreadyExists)
+
+	got, err := repo.GetSetup(setup.ID)
+	if err != nil {
+		t.Fatalf("GetSetup: %v", err)
+	}
+	if got.Name != setup.Name || got.URL != setup.URL || string(got.Body) != string(setup.Body) ||
+		got.Headers["X-Test"] != "a" || got.Duration != setup.Duration || got.Retry == nil || got.Retry.MaxAttempts != 3 {
+		t.Fatalf("GetSetup returned %+v, want %+v", got, setup)
+	}
+
+	updated := *setup
+	updated.Name = "b"
+	if err = repo.UpdateSetup(&updated); err != nil {
+		t.Fatalf("UpdateSetup: %v", err)
+	}
+	if got, _ = repo.GetSetup(setup.ID); got.Name != "b" {
+		t.Fatalf("name after update is %q, want %q", got.Name, "b")
+	}
+
+	setups, _, err := repo.ListSetups(storage.SetupFilter{}, storage.Page{})
+	if err != nil || len(setups) != 1 {
+		t.Fatalf("ListSetups returned %d setups, %v", len(setups), err)
+	}
+
+	if err = repo.DeleteSetup(setup.ID); err != nil {
+		t.Fatalf("DeleteSetup: %v", err)
+	}
+	_, err = repo.GetSetup(setup.ID)
+	wantErr(t, err, storage.ErrNotFound)
+	wantErr(t, repo.DeleteSetup(setup.ID), storage.ErrNotFound)
+	wantErr(t, repo.UpdateSetup(setup), storage.ErrNotFound)
+}
+
+func testRuns(t *testing.T, f Factory) {
+	repo := open(t, f)
+	run := newFinishedRun("setup")
+
+	if err := repo.CreateRun(run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	wantErr(t, repo.CreateRun(run), storage.ErrAlreadyExists)
+
+	run.Error = "boom"
+	run.Status = models.RunStatusFailed
+	if err := repo.UpdateRun(run); err != nil {
+		t.Fatalf("UpdateRun: %v", err)
+	}
+
+	got, err := repo.GetRun(run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if got.Status != models.RunStatusFailed || got.Error != "boom" || got.Labels["env"] != "staging" || len(got.Events) != 1 {
+		t.Fatalf("GetRun returned %+v", got)
+	}
+
+	runs, _, err := repo.ListRuns(storage.RunFilter{}, storage.Page{})
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("ListRuns returned %d runs, %v", len(runs), err)
+	}
+
+	if err = repo.DeleteRun(run.ID); err != nil {
+		t.Fatalf("DeleteRun: %v", err)
+	}
+	_, err = repo.GetRun(run.ID)
+	wantErr(t, err, storage.ErrNotFound)
+	wantErr(t, repo.DeleteRun(run.ID), storage.ErrNotFound)
+	wantErr(t, repo.UpdateRun(run), storage.ErrNotFound)
+}
+
+func testRunsBySetup(t *testing.T, f Factory) {
+	repo := open(t, f)
+
+	var want []string
+	for i := range 3 {
+		run := newFinishedRun("a")
+		if i == 2 {
+			run.SetupID = "b"
+		} else {
+			want = append(want, run.ID)
+		}
+		if err := repo.CreateRun(run); err != nil {
+			t.Fatalf("CreateRun: %v", err)
+		}
+	}
+
+	runs, _, err := repo.ListRuns(storage.RunFilter{SetupID: "a"}, storage.Page{})
+	if err != nil {
+		t.Fatalf("ListRuns: %v", err)
+	}
+
+	var got []string
+	for _, run := range runs {
+		got = append(got, run.ID)
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("ListRuns by setup returned %v, want %v", got, want)
+	}
+
+	if err = repo.DeleteRun(want[0]); err != nil {
+		t.Fatalf("DeleteRun: %v", err)
+	}
+	if runs, _, _ = repo.ListRuns(storage.RunFilter{SetupID: "a"}, storage.Page{}); len(runs) != 1 {
+		t.Fatalf("ListRuns by setup returned %d runs after delete, want 1", len(runs))
+	}
+}
+
+func testRunFilters(t *testing.T, f Factory) {
+	repo := open(t, f)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	runs := make([]*models.Run, 4)
+	for i := range runs {
+		runs[i] = newFinishedRun("setup")
+		runs[i].StartedAt = start.Add(time.Duration(i) * time.Hour)
+		runs[i].Labels = map[string]string{"env": []string{"staging", "prod"}[i%2]}
+		if i == 3 {
+			runs[i].Labels["canary"] = ""
+		}
+		if err := repo.CreateRun(runs[i]); err != nil {
+			t.Fatalf("CreateRun: %v", err)
+		}
+	}
+
+	selector := func(expr string) labels.Selector {
+		s, err := labels.Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", expr, err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		filter storage.RunFilter
+		want   []int
+	}{
+		{"equals", storage.RunFilter{Labels: selector("env=prod")}, []int{1, 3}},
+		{"not equals", storage.RunFilter{Labels: selector("env!=prod")}, []int{0, 2}},
+		{"in", storage.RunFilter{Labels: selector("env in (prod,dev)")}, []int{1, 3}},
+		{"not in", storage.RunFilter{Labels: selector("env notin (prod)")}, []int{0, 2}},
+		{"exists", storage.RunFilter{Labels: selector("canary")}, []int{3}},
+		{"not exists", storage.RunFilter{Labels: selector("!canary")}, []int{0, 1, 2}},
+		{"from", storage.RunFilter{StartedFrom: start.Add(2 * time.Hour)}, []int{2, 3}},
+		{"to", storage.RunFilter{StartedTo: start.Add(2 * time.Hour)}, []int{0, 1}},
+		{"combined", storage.RunFilter{SetupID: "setup", Labels: selector("env=prod"), StartedTo: start.Add(2 * time.Hour)}, []int{1}},
+		{"other setup", storage.RunFilter{SetupID: "other"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := repo.ListRuns(tt.filter, storage.Page{})
+			if err != nil {
+				t.Fatalf("ListRuns: %v", err)
+			}
+
+			var gotIDs, wantIDs []string
+			for _, run := range got {
+				gotIDs = append(gotIDs, run.ID)
+			}
+			for _, i := range tt.want {
+				wantIDs = append(wantIDs, runs[i].ID)
+			}
+			slices.Sort(gotIDs)
+			slices.Sort(wantIDs)
+
+			if !slices.Equal(gotIDs, wantIDs) {
+				t.Fatalf("ListRuns returned %v, want %v", gotIDs, wantIDs)
+			}
+		})
+	}
+}
+
+func testSetupVersions(t *testing.T, f Factory) {
+	repo := open(t, f)
+	setup := newSetup("a")
+
+	if err := repo.CreateSetup(setup); err != nil {
+		t.Fatalf("CreateSetup: %v", err)
+	}
+
+	second := *setup
+	second.Version = 2
+	second.RPS = 200
+	if err := repo.CreateSetupVersion(&second); err != nil {
+		t.Fatalf("CreateSetupVersion: %v", err)
+	}
+	wantErr(t, repo.CreateSetupVersion(&second), storage.ErrAlreadyExists)
+
+	missing := *setup
+	missing.ID = "missing"
+	wantErr(t, repo.CreateSetupVersion(&missing), storage.ErrNotFound)
+
+	// Changes without a new version leave the stored versions alone.
+	current := second
+	current.Status = models.SetupStatusInactive
+	if err := repo.UpdateSetup(&current); err != nil {
+		t.Fatalf("UpdateSetup: %v", err)
+	}
+
+	got, err := repo.GetSetup(setup.ID)
+	if err != nil || got.Version != 2 || got.RPS != 200 || got.Status != models.SetupStatusInactive {
+		t.Fatalf("GetSetup returned %+v, %v", got, err)
+	}
+
+	first, err := repo.GetSetupVersion(setup.ID, 1)
+	if err != nil || first.RPS != 100 {
+		t.Fatalf("GetSetupVersion 1 returned %+v, %v", first, err)
+	}
+	_, err = repo.GetSetupVersion(setup.ID, 3)
+	wantErr(t, err, storage.ErrNotFound)
+
+	versions, err := repo.ListSetupVersions(setup.ID)
+	if err != nil {
+		t.Fatalf("ListSetupVersions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 2 ||
+		versions[1].RPS != 200 || versions[1].Status != models.SetupStatusActive {
+		t.Fatalf("ListSetupVersions returned %+v", versions)
+	}
+
+	// A status change and a new version written from stale copies keep each
+	// other's changes.
+	if _, err = repo.SetSetupStatus(setup.ID, models.SetupStatusArchived, time.Now()); err != nil {
+		t.Fatalf("SetSetupStatus: %v", err)
+	}
+
+	third := second
+	third.Version = 3
+	third.RPS = 300
+	if err = repo.CreateSetupVersion(&third); err != nil {
+		t.Fatalf("CreateSetupVersion: %v", err)
+	}
+	if third.Status != models.SetupStatusArchived {
+		t.Fatalf("new version has status %s, want the stored archived", third.Status)
+	}
+
+	got, err = repo.SetSetupStatus(setup.ID, models.SetupStatusActive, time.Now())
+	if err != nil || got.Version != 3 || got.RPS != 300 || got.Status != models.SetupStatusActive {
+		t.Fatalf("SetSetupStatus returned %+v, %v", got, err)
+	}
+	if got, err = repo.GetSetup(setup.ID); err != nil || got.Version != 3 || got.Status != models.SetupStatusActive {
+		t.Fatalf("GetSetup after SetSetupStatus returned %+v, %v", got, err)
+	}
+	_, err = repo.SetSetupStatus("missing", models.SetupStatusActive, time.Now())
+	wantErr(t, err, storage.ErrNotFound)
+
+	if err = repo.DeleteSetup(setup.ID); err != nil {
+		t.Fatalf("DeleteSetup: %v", err)
+	}
+	_, err = repo.ListSetupVersions(setup.ID)
+	wantErr(t, err, storage.ErrNotFound)
+	_, err = repo.GetSetupVersion(setup.ID, 1)
+	wantErr(t, err, storage.ErrNotFound)
+}
+
+func testSetupHistory(t *testing.T, f Factory) {
+	repo := open(t, f)
+
+	history := func(id string, versions ...int) []*models.Setup {
+		var out []*models.Setup
+		for _, version := range versions {
+			v := newSetup("a")
+			v.ID = id
+			v.Version = version
+			v.RPS = 100 * version
+			out = append(out, v)
+		}
+		return out
+	}
+
+	if err := repo.CreateSetupHistory(history("a", 1, 3, 4)); err != nil {
+		t.Fatalf("CreateSetupHistory: %v", err)
+	}
+
+	got, err := repo.GetSetup("a")
+	if err != nil || got.Version != 4 || got.RPS != 400 {
+		t.Fatalf("GetSetup returned %+v, %v", got, err)
+	}
+
+	versions, err := repo.ListSetupVersions("a")
+	if err != nil || len(versions) != 3 || versions[0].Version != 1 || versions[1].RPS != 300 || versions[2].Version != 4 {
+		t.Fatalf("ListSetupVersions returned %+v, %v", versions, err)
+	}
+
+	wantErr(t, repo.CreateSetupHistory(history("a", 5)), storage.ErrAlreadyExists)
+
+	// A history that fails part way stores none of it.
+	wantErr(t, repo.CreateSetupHistory(history("b", 1, 2, 2)), storage.ErrAlreadyExists)
+	_, err = repo.GetSetup("b")
+	wantErr(t, err, storage.ErrNotFound)
+	_, err = repo.GetSetupVersion("b", 1)
+	wantErr(t, err, storage.ErrNotFound)
+}
+
+func testSetupFilters(t *testing.T, f Factory) {
+	repo := open(t, f)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	setups := make([]*models.Setup, 3)
+	for i, name := range []string{"Checkout API", "search", "checkout-web"} {
+		setups[i] = newSetup(name)
+		setups[i].CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		if i == 1 {
+			setups[i].Status = models.SetupStatusInactive
+		}
+		if err := repo.CreateSetup(setups[i]); err != nil {
+			t.Fatalf("CreateSetup: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter storage.SetupFilter
+		want   []int
+	}{
+		{"status", storage.SetupFilter{Status: []models.SetupStatus{models.SetupStatusInactive}}, []int{1}},
+		{"name ignores case", storage.SetupFilter{Name: "CHECKOUT"}, []int{0, 2}},
+		{"from", storage.SetupFilter{CreatedFrom: start.Add(time.Hour)}, []int{1, 2}},
+		{"to", storage.SetupFilter{CreatedTo: start.Add(time.Hour)}, []int{0}},
+		{"combined", storage.SetupFilter{Name: "checkout", CreatedFrom: start.Add(time.Hour)}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := repo.ListSetups(tt.filter, storage.Page{})
+			if err != nil {
+				t.Fatalf("ListSetups: %v", err)
+			}
+
+			var gotIDs, wantIDs []string
+			for _, setup := range got {
+				gotIDs = append(gotIDs, setup.ID)
+			}
+			for _, i := range tt.want {
+				wantIDs = append(wantIDs, setups[i].ID)
+			}
+
+			if !slices.Equal(gotIDs, wantIDs) {
+				t.Fatalf("ListSetups returned %v, want %v", gotIDs, wantIDs)
+			}
+		})
+	}
+}
+
+func testSetupPages(t *testing.T, f Factory) {
+	repo := open(t, f)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Two setups share a name and a creation time, so that the ID breaks
+	// the tie.
+	var setups []*models.Setup
+	for i, name := range []string{"e", "b", "d", "a", "c", "c"} {
+		setup := newSetup(name)
+		setup.CreatedAt = start.Add(time.Duration(min(i, 4)) * time.Minute)
+		if err := repo.CreateSetup(setup); err != nil {
+			t.Fatalf("CreateSetup: %v", err)
+		}
+		setups = append(setups, setup)
+	}
+
+	for _, page := range []storage.Page{
+		{Sort: storage.SortCreatedAt},
+		{Sort: storage.SortCreatedAt, Desc: true},
+		{Sort: storage.SortName},
+		{Sort: storage.SortName, Desc: true},
+	} {
+		want := slices.Clone(setups)
+		slices.SortFunc(want, func(a, b *models.Setup) int {
+			c := cmp.Or(cmp.Compare(storage.SetupKey(a, page.Sort), storage.SetupKey(b, page.Sort)), cmp.Compare(a.ID, b.ID))
+			if page.Desc {
+				return -c
+			}
+			return c
+		})
+
+		page.Limit = 4
+		checkPages(t, page, ids(want), func(page storage.Page) ([]string, string, error) {
+			got, next, err := repo.ListSetups(storage.SetupFilter{}, page)
+			return ids(got), next, err
+		})
+	}
+
+	_, _, err := repo.ListSetups(storage.SetupFilter{}, storage.Page{Cursor: "not a cursor"})
+	wantErr(t, err, storage.ErrInvalidCursor)
+
+	_, next, _ := repo.ListSetups(storage.SetupFilter{}, storage.Page{Limit: 1})
+	_, _, err = repo.ListSetups(storage.SetupFilter{}, storage.Page{Sort: storage.SortName, Cursor: next})
+	wantErr(t, err, storage.ErrInvalidCursor)
+}
+
+func testRunPages(t *testing.T, f Factory) {
+	repo := open(t, f)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var runs []*models.Run
+	for i, status := range []models.RunStatus{
+		models.RunStatusCompleted, models.RunStatusFailed, models.RunStatusCompleted, models.RunStatusCancelled,
+		models.RunStatusFailed,
+	} {
+		run := newFinishedRun("setup")
+		run.SetupName = []string{"Checkout", "search"}[i%2]
+		run.Status = status
+		run.QueuedAt = start.Add(time.Duration(i) * time.Minute)
+		if err := repo.CreateRun(run); err != nil {
+			t.Fatalf("CreateRun: %v", err)
+		}
+		runs = append(runs, run)
+	}
+
+	checkPages(t, storage.Page{Desc: true, Limit: 2}, ids([]*models.Run{runs[4], runs[3], runs[2], runs[1], runs[0]}),
+		func(page storage.Page) ([]string, string, error) {
+			got, next, err := repo.ListRuns(storage.RunFilter{}, page)
+			return ids(got), next, err
+		})
+
+	want := slices.Clone(runs)
+	slices.SortFunc(want, func(a, b *models.Run) int {
+		return cmp.Or(cmp.Compare(a.Status, b.Status), cmp.Compare(a.ID, b.ID))
+	})
+	checkPages(t, storage.Page{Sort: storage.SortStatus, Limit: 2}, ids(want),
+		func(page storage.Page) ([]string, string, error) {
+			got, next, err := repo.ListRuns(storage.RunFilter{}, page)
+			return ids(got), next, err
+		})
+
+	filter := storage.RunFilter{Status: []models.RunStatus{models.RunStatusFailed}, Name: "SEARCH"}
+	checkPages(t, storage.Page{Sort: storage.SortName, Limit: 1}, ids([]*models.Run{runs[1]}),
+		func(page storage.Page) ([]string, string, error) {
+			got, next, err := repo.ListRuns(filter, page)
+			return ids(got), next, err
+		})
+}
+
+func testRunSummaries(t *testing.T, f Factory) {
+	repo := open(t, f)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var runs []*models.Run
+	for i := range 3 {
+		run := newFinishedRun("setup")
+		run.QueuedAt = start.Add(time.Duration(i) * time.Minute)
+		run.EndedAt = run.StartedAt.Add(time.Duration(i+1) * time.Minute).UTC().Truncate(time.Millisecond)
+		if i == 0 {
+			run.CompactedAt = start.Add(time.Hour)
+		}
+		if err := repo.CreateRun(run); err != nil {
+			t.Fatalf("CreateRun: %v", err)
+		}
+		runs = append(runs, run)
+	}
+
+	checkPages(t, storage.Page{Desc: true, Limit: 2}, ids([]*models.Run{runs[2], runs[1], runs[0]}),
+		func(page storage.Page) ([]string, string, error) {
+			got, next, err := repo.ListRunSummaries(storage.RunFilter{SetupID: "setup"}, page)
+			return ids(got), next, err
+		})
+
+	got, _, err := repo.ListRunSummaries(storage.RunFilter{}, storage.Page{})
+	if err != nil {
+		t.Fatalf("ListRunSummaries: %v", err)
+	}
+
+	for _, summary := range got {
+		i := slices.IndexFunc(runs, func(run *models.Run) bool { return run.ID == summary.ID })
+		if i < 0 {
+			t.Fatalf("unexpected run %s", summary.ID)
+		}
+
+		want := runs[i]
+		if summary.SetupID != want.SetupID || summary.Status != want.Status ||
+			!summary.EndedAt.Equal(want.EndedAt) || !summary.CompactedAt.Equal(want.CompactedAt) {
+			t.Fatalf("summary is %+v, want %+v", summary, want)
+		}
+	}
+}
+
+// checkPages follows the cursors from the first page and checks that the
+// pages add up to want, each holding at most page.Limit items.
+func checkPages(t *testing.T, page storage.Page, want []string, list func(storage.Page) ([]string, string, error)) {
+	t.Helper()
+
+	var got []string
+	for range len(want) + 1 {
+		items, next, err := list(page)
+		if err != nil {
+			t.Fatalf("list %+v: %v", page, err)
+		}
+		if len(items) > page.Limit {
+			t.Fatalf("list %+v returned %d items", page, len(items))
+		}
+
+		got = append(got, items...)
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("pages sorted by %s (desc %t) returned %v, want %v", page.Sort, page.Desc, got, want)
+	}
+}
+
+func ids[T interface{ *models.Setup | *models.Run }](items []T) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		switch v := any(item).(type) {
+		case *models.Setup:
+			out[i] = v.ID
+		case *models.Run:
+			out[i] = v.ID
+		}
+	}
+	return out
+}
+
+func testLiveRuns(t *testing.T, f Factory) {
+	repo := open(t, f)
+	run := models.NewRun("setup")
+
+	if err := repo.CreateRun(run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+
+	run.Status = models.RunStatusRunning
+
+	got, err := repo.GetRun(run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if got != run {
+		t.Fatalf("GetRun returned a copy of an unfinished run")
+	}
+
+	runs, _, _ := repo.ListRuns(storage.RunFilter{}, storage.Page{})
+	if len(runs) != 1 || runs[0] != run {
+		t.Fatalf("ListRuns did not return the unfinished run itself")
+	}
+}
+
+func testExpiredRuns(t *testing.T, f Factory) {
+	repo := open(t, f)
+	now := time.Now()
+
+	expired := models.NewRun("setup")
+	expired.Status = models.RunStatusRunning
+	expired.LeaseExpiresAt = now.Add(-time.Minute)
+
+	renewed := models.NewRun("setup")
+	renewed.Status = models.RunStatusRunning
+	renewed.LeaseExpiresAt = now.Add(time.Minute)
+
+	for _, run := range []*models.Run{expired, renewed} {
+		if err := repo.CreateRun(run); err != nil {
+			t.Fatalf("CreateRun: %v", err)
+		}
+	}
+
+	interrupt := func(run *models.Run) {
+		run.Status = models.RunStatusInterrupted
+		run.EndedAt = now
+	}
+
+	got, ok, err := repo.ExpireRun(expired.ID, now, interrupt)
+	if err != nil || !ok {
+		t.Fatalf("ExpireRun of an expired run = %v, %v", ok, err)
+	}
+	if got.Status != models.RunStatusInterrupted {
+		t.Fatalf("expired run status is %s, want interrupted", got.Status)
+	}
+
+	if got, err = repo.GetRun(expired.ID); err != nil || got.Status != models.RunStatusInterrupted {
+		t.Fatalf("expired run was not stored as interrupted: %v", err)
+	}
+
+	// Another instance that saw the same expired lease loses.
+	if _, ok, err = repo.ExpireRun(expired.ID, now, interrupt); err != nil || ok {
+		t.Fatalf("second ExpireRun = %v, %v, want false", ok, err)
+	}
+
+	called := false
+	if _, ok, err = repo.ExpireRun(renewed.ID, now, func(*models.Run) { called = true }); err != nil || ok || called {
+		t.Fatalf("ExpireRun of a renewed run = %v, %v, finish called %v", ok, err, called)
+	}
+
+	if got, err = repo.GetRun(renewed.ID); err != nil || got.Status != models.RunStatusRunning {
+		t.Fatalf("renewed run changed: %v", err)
+	}
+
+	if _, _, err = repo.ExpireRun("missing", now, interrupt); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ExpireRun of a missing run: %v, want ErrNotFound", err)
+	}
+}
+
+func testFinishedRunStats(t *testing.T, f Factory) {
+	repo := open(t, f)
+	run := newFinishedRun("setup")
+
+	if err := repo.CreateRun(run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+
+	got, err := repo.GetRun(run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	checkStats(t, got.Stats, run.Stats)
+}
+
+func testCompactedRuns(t *testing.T, f Factory) {
+	repo := open(t, f)
+	run := newFinishedRun("setup")
+
+	if err := repo.CreateRun(run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+
+	summary := models.NewLatencySummary(run.Stats.Latencies)
+	run.Stats.LatencySummary = summary
+	run.Stats.Latencies = histogram.New()
+	run.CompactedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	if err := repo.UpdateRun(run); err != nil {
+		t.Fatalf("UpdateRun: %v", err)
+	}
+
+	if f.Reopen != nil {
+		repo = f.Reopen(t, repo)
+		t.Cleanup(func() { _ = repo.Close() })
+	}
+
+	got, err := repo.GetRun(run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+
+	if !got.CompactedAt.Equal(run.CompactedAt) {
+		t.Fatalf("compacted at %v, want %v", got.CompactedAt, run.CompactedAt)
+	}
+
+	if got.Stats.LatencySummary == nil || *got.Stats.LatencySummary != *summary {
+		t.Fatalf("latency summary is %+v, want %+v", got.Stats.LatencySummary, summary)
+	}
+
+	if got.Stats.Latencies == nil || got.Stats.Latencies.Total != 0 {
+		t.Fatalf("latency histogram of a compacted run is not empty")
+	}
+}
+
+func checkStats(t *testing.T, got, want *models.Stats) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("stats are missing")
+	}
+
+	if got.TotalRequests != want.TotalRequests || got.SuccessRequests != want.SuccessRequests ||
+		got.FailedRequests != want.FailedRequests || got.TotalLatency != want.TotalLatency {
+		t.Fatalf("stats counters are %+v, want %+v", got, want)
+	}
+
+	if n := got.StatusCodes[200]; n == nil || *n != *want.StatusCodes[200] {
+		t.Fatalf("status code 200 count is wrong")
+	}
+
+	if !slices.Equal(got.Errors, want.Errors) {
+		t.Fatalf("errors are %v, want %v", got.Errors, want.Errors)
+	}
+
+	if got.Latencies == nil || got.Latencies.Total != want.Latencies.Total {
+		t.Fatalf("latency histogram is missing or incomplete")
+	}
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		if g, w := got.Latencies.Quantile(q), want.Latencies.Quantile(q); g != w {
+			t.Fatalf("quantile %v is %v, want %v", q, g, w)
+		}
+	}
+}
+
+func testSchedules(t *testing.T, f Factory) {
+	repo := open(t, f)
+	schedule := models.NewSchedule("nightly", "setup", time.Time{}, "0 2 * * *", "UTC", models.OverlapSkip)
+
+	if err := repo.CreateSchedule(schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+	wantErr(t, repo.CreateSchedule(schedule), storage.ErrAlreadyExists)
+
+	updated := *schedule
+	updated.Enabled = false
+	updated.History = []models.ScheduleEntry{{Time: time.Now(), Outcome: models.ScheduleOutcomeSkipped}}
+	if err := repo.UpdateSchedule(&updated); err != nil {
+		t.Fatalf("UpdateSchedule: %v", err)
+	}
+
+	got, err := repo.GetSchedule(schedule.ID)
+	if err != nil {
+		t.Fatalf("GetSchedule: %v", err)
+	}
+	if got.Enabled || got.Cron != schedule.Cron || len(got.History) != 1 {
+		t.Fatalf("GetSchedule returned %+v", got)
+	}
+
+	schedules, err := repo.ListSchedules()
+	if err != nil || len(schedules) != 1 {
+		t.Fatalf("ListSchedules returned %d schedules, %v", len(schedules), err)
+	}
+
+	if err = repo.DeleteSchedule(schedule.ID); err != nil {
+		t.Fatalf("DeleteSchedule: %v", err)
+	}
+	_, err = repo.GetSchedule(schedule.ID)
+	wantErr(t, err, storage.ErrNotFound)
+	wantErr(t, repo.DeleteSchedule(schedule.ID), storage.ErrNotFound)
+}
+
+func testScheduleClaims(t *testing.T, f Factory) {
+	repo := open(t, f)
+	schedule := models.NewSchedule("nightly", "setup", time.Time{}, "0 2 * * *", "UTC", models.OverlapSkip)
+	schedule.NextRunAt = time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC)
+
+	if err := repo.CreateSchedule(schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+
+	// claim advances the schedule only if it is still due at the time that
+	// was read, like two instances racing for the same run.
+	claim := func(stored *models.Schedule) bool {
+		if !stored.NextRunAt.Equal(schedule.NextRunAt) {
+			return false
+		}
+		stored.NextRunAt = stored.NextRunAt.Add(24 * time.Hour)
+		stored.History = append(stored.History, models.ScheduleEntry{Outcome: models.ScheduleOutcomeStarted})
+		return true
+	}
+
+	got, ok, err := repo.ModifySchedule(schedule.ID, claim)
+	if err != nil || !ok {
+		t.Fatalf("first claim = %v, %v", ok, err)
+	}
+	if want := schedule.NextRunAt.Add(24 * time.Hour); !got.NextRunAt.Equal(want) {
+		t.Fatalf("claimed schedule runs next at %v, want %v", got.NextRunAt, want)
+	}
+
+	if _, ok, err = repo.ModifySchedule(schedule.ID, claim); err != nil || ok {
+		t.Fatalf("second claim = %v, %v, want false", ok, err)
+	}
+
+	if got, err = repo.GetSchedule(schedule.ID); err != nil || len(got.History) != 1 {
+		t.Fatalf("stored schedule has %d history entries, want 1: %v", len(got.History), err)
+	}
+	if len(schedule.History) != 0 {
+		t.Fatalf("ModifySchedule changed the schedule it was given")
+	}
+
+	if _, _, err = repo.ModifySchedule("missing", claim); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ModifySchedule of a missing schedule: %v, want ErrNotFound", err)
+	}
+}
+
+func testPersistence(t *testing.T, f Factory) {
+	if f.Reopen == nil {
+		t.Skip("backend does not persist")
+	}
+
+	repo := f.New(t)
+
+	setup := newSetup("persisted")
+	run := newFinishedRun(setup.ID)
+	run.SetupVersion = setup.Version
+	schedule := models.NewSchedule("hourly", setup.ID, time.Time{}, "@hourly", "", models.OverlapQueue)
+
+	if err := repo.CreateSetup(setup); err != nil {
+		t.Fatalf("CreateSetup: %v", err)
+	}
+	if err := repo.CreateRun(run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	if err := repo.CreateSchedule(schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+
+	repo = f.Reopen(t, repo)
+	t.Cleanup(func() { _ = repo.Close() })
+
+	if _, err := repo.GetSetup(setup.ID); err != nil {
+		t.Fatalf("setup lost on reopen: %v", err)
+	}
+	if _, err := repo.GetSetupVersion(setup.ID, setup.Version); err != nil {
+		t.Fatalf("setup version lost on reopen: %v", err)
+	}
+	if _, err := repo.GetSchedule(schedule.ID); err != nil {
+		t.Fatalf("schedule lost on reopen: %v", err)
+	}
+
+	got, err := repo.GetRun(run.ID)
+	if err != nil {
+		t.Fatalf("run lost on reopen: %v", err)
+	}
+	if got.SetupVersion != setup.Version {
+		t.Fatalf("run setup version is %d after reopen, want %d", got.SetupVersion, setup.Version)
+	}
+	checkStats(t, got.Stats, run.Stats)
+
+	runs, _, err := repo.ListRuns(storage.RunFilter{SetupID: setup.ID}, storage.Page{})
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("ListRuns by setup returned %d runs after reopen, %v", len(runs), err)
+	}
+}
@@ -1,17 +1,29 @@
 package web
 
 import (
+	"bufio"
+	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	maxUploadSize = 32 << 20
+	// maxEventSize is the longest line of a run event stream, which holds the
+	// encoded stats of the run.
+	maxEventSize = 16 << 20
+)
+
 //go:embed templates/* static/*
 var content embed.FS
 
@@ -48,7 +60,12 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /runs", h.listRuns)
 	mux.HandleFunc("GET /runs/active", h.listActiveRuns)
 	mux.HandleFunc("GET /runs/{id}", h.getRunStats)
+	mux.HandleFunc("GET /runs/{id}/events", h.runEvents)
 	mux.HandleFunc("POST /runs/{id}/cancel", h.cancelRun)
+	mux.HandleFunc("POST /runs/{id}/pause", h.pauseRun)
+	mux.HandleFunc("POST /runs/{id}/resume", h.resumeRun)
+	mux.HandleFunc("POST /setups/{id}/activate", h.activateSetup)
+	mux.HandleFunc("POST /setups/{id}/deactivate", h.deactivateSetup)
 	mux.HandleFunc("DELETE /setups/{id}", h.deleteSetup)
 }
 
@@ -85,12 +102,24 @@ func (h *Handler) static(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) listSetups(w http.ResponseWriter, _ *http.Request) {
-	resp, err := http.Get(h.apiBase + "/api/setups")
+// listPage is one page of a list. Cursor is set when the page continues a
+// list that is already shown, and Next when more items follow.
+type listPage struct {
+	Items  []map[string]interface{}
+	Cursor string
+	Next   string
+}
+
+// fetchPage fetches the page of an API list that starts at cursor.
+func (h *Handler) fetchPage(path, cursor string) (*listPage, error) {
+	target := h.apiBase + path
+	if cursor != "" {
+		target += "?cursor=" + url.QueryEscape(cursor)
+	}
+
+	resp, err := http.Get(target)
 	if err != nil {
-		h.logger.Error("failed to fetch setups", "error", err)
-		http.Error(w, "Failed to fetch setups", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -98,25 +127,44 @@ func (h *Handler) listSetups(w http.ResponseWriter, _ *http.Request) {
 		}
 	}()
 
-	var setups []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&setups); err != nil {
-		h.logger.Error("failed to decode setups", "error", err)
-		http.Error(w, "Failed to decode setups", http.StatusInternalServerError)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	page := &listPage{Cursor: cursor, Next: resp.Header.Get("X-Next-Cursor")}
+	if err = json.NewDecoder(resp.Body).Decode(&page.Items); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	return page, nil
+}
+
+func (h *Handler) listSetups(w http.ResponseWriter, r *http.Request) {
+	page, err := h.fetchPage("/api/setups", r.URL.Query().Get("cursor"))
+	if err != nil {
+		h.logger.Error("failed to fetch setups", "error", err)
+		http.Error(w, "Failed to fetch setups", http.StatusInternalServerError)
 		return
 	}
 
-	if err := h.tmpl.ExecuteTemplate(w, "setups-list.html", setups); err != nil {
+	if err := h.tmpl.ExecuteTemplate(w, "setups-list.html", page); err != nil {
 		h.logger.Error("failed to render template", "error", err)
 		http.Error(w, "Failed to render setups", http.StatusInternalServerError)
 	}
 }
 
 func (h *Handler) createSetup(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
 		http.Error(w, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
+	body, err := formBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	payload := map[string]interface{}{
 		"name":        r.FormValue("name"),
 		"description": r.FormValue("description"),
@@ -125,7 +173,10 @@ func (h *Handler) createSetup(w http.ResponseWriter, r *http.Request) {
 		"rps":         parseIntOrDefault(r.FormValue("rps"), 100),
 		"duration":    r.FormValue("duration"),
 		"headers":     map[string]string{},
-		"body":        "",
+	}
+
+	if body != nil {
+		payload["payload"] = body
 	}
 
 	jsonData, _ := json.Marshal(payload)
@@ -186,34 +237,22 @@ func (h *Handler) createRun(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) listRuns(w http.ResponseWriter, _ *http.Request) {
-	resp, err := http.Get(h.apiBase + "/api/runs")
+func (h *Handler) listRuns(w http.ResponseWriter, r *http.Request) {
+	page, err := h.fetchPage("/api/runs", r.URL.Query().Get("cursor"))
 	if err != nil {
 		h.logger.Error("failed to fetch runs", "error", err)
 		http.Error(w, "Failed to fetch runs", http.StatusInternalServerError)
 		return
 	}
-	defer func() {
-		if err = resp.Body.Close(); err != nil {
-			h.logger.Error("failed to close response body", "error", err)
-		}
-	}()
-
-	var runs []map[string]interface{}
-	if err = json.NewDecoder(resp.Body).Decode(&runs); err != nil {
-		h.logger.Error("failed to decode runs", "error", err)
-		http.Error(w, "Failed to decode runs", http.StatusInternalServerError)
-		return
-	}
 
-	if err = h.tmpl.ExecuteTemplate(w, "all-runs-list.html", runs); err != nil {
+	if err = h.tmpl.ExecuteTemplate(w, "all-runs-list.html", page); err != nil {
 		h.logger.Error("failed to render template", "error", err)
 		http.Error(w, "Failed to render runs", http.StatusInternalServerError)
 	}
 }
 
 func (h *Handler) listActiveRuns(w http.ResponseWriter, _ *http.Request) {
-	resp, err := http.Get(h.apiBase + "/api/runs")
+	resp, err := http.Get(h.apiBase + "/api/runs?status=pending,running,paused,cancelling&limit=1000")
 	if err != nil {
 		h.logger.Error("failed to fetch runs", "error", err)
 		http.Error(w, "Failed to fetch runs", http.StatusInternalServerError)
@@ -225,21 +264,13 @@ func (h *Handler) listActiveRuns(w http.ResponseWriter, _ *http.Request) {
 		}
 	}()
 
-	var allRuns []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&allRuns); err != nil {
+	var activeRuns []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&activeRuns); err != nil {
 		h.logger.Error("failed to decode runs", "error", err)
 		http.Error(w, "Failed to decode runs", http.StatusInternalServerError)
 		return
 	}
 
-	var activeRuns []map[string]interface{}
-	for _, run := range allRuns {
-		status, ok := run["status"].(string)
-		if ok && (status == "pending" || status == "running") {
-			activeRuns = append(activeRuns, run)
-		}
-	}
-
 	if err = h.tmpl.ExecuteTemplate(w, "runs-list.html", activeRuns); err != nil {
 		h.logger.Error("failed to render template", "error", err)
 		http.Error(w, "Failed to render active runs", http.StatusInternalServerError)
@@ -274,6 +305,121 @@ func (h *Handler) getRunStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// runEvents relays the event stream of a run to the run details. Stats are
+// sent as the rendered stats section, and the events of the run as "run"
+// events, on which the details reload. The stream starts after the first next
+// events of the run, which the details already show.
+func (h *Handler) runEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if next, err := strconv.Atoi(r.URL.Query().Get("next")); lastID == "" && err == nil && next > 0 {
+		lastID = strconv.Itoa(next - 1)
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, h.apiBase+"/api/runs/"+url.PathEscape(id)+"/events", nil)
+	if err != nil {
+		http.Error(w, "Failed to fetch run events", http.StatusInternalServerError)
+		return
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.logger.Error("failed to fetch run events", "error", err)
+		http.Error(w, "Failed to fetch run events", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Error("failed to close response body", "error", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		// EventSource only gives up on a stream that is not text/event-stream.
+		http.Error(w, "Failed to fetch run events", resp.StatusCode)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxEventSize)
+
+	var eventID, event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "id":
+			eventID = value
+		case "event":
+			event = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "":
+			if line != "" {
+				// A comment.
+				continue
+			}
+			if err := h.relayEvent(w, id, eventID, event, data.Bytes()); err != nil {
+				h.logger.Error("failed to relay run event", "run_id", id, "error", err)
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			eventID, event = "", ""
+			data.Reset()
+		}
+	}
+}
+
+// relayEvent writes an event of the stream of a run as the run details take
+// it.
+func (h *Handler) relayEvent(w io.Writer, runID, eventID, event string, data []byte) error {
+	switch event {
+	case "":
+		return nil
+	case "stats":
+		var stats map[string]interface{}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return fmt.Errorf("decode stats: %w", err)
+		}
+
+		var html bytes.Buffer
+		if err := h.tmpl.ExecuteTemplate(&html, "run-stats", map[string]interface{}{"id": runID, "stats": stats}); err != nil {
+			return fmt.Errorf("render stats: %w", err)
+		}
+
+		_, err := fmt.Fprint(w, "event: stats\n")
+		for _, line := range strings.Split(html.String(), "\n") {
+			if err == nil {
+				_, err = fmt.Fprintf(w, "data: %s\n", line)
+			}
+		}
+		if err == nil {
+			_, err = fmt.Fprint(w, "\n")
+		}
+		return err
+	default:
+		_, err := fmt.Fprintf(w, "id: %s\nevent: run\ndata: %s\n\n", eventID, event)
+		return err
+	}
+}
+
 func (h *Handler) cancelRun(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
@@ -296,7 +442,7 @@ func (h *Handler) cancelRun(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	if resp.StatusCode != http.StatusNoContent {
+	if resp.StatusCode != http.StatusAccepted {
 		body, _ := io.ReadAll(resp.Body)
 		h.logger.Error("cancel failed", "status", resp.StatusCode, "body", string(body))
 		http.Error(w, "Cancel failed", resp.StatusCode)
@@ -307,6 +453,38 @@ func (h *Handler) cancelRun(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) pauseRun(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r.PathValue("id"), "pause")
+}
+
+func (h *Handler) resumeRun(w http.ResponseWriter, r *http.Request) {
+	h.runAction(w, r.PathValue("id"), "resume")
+}
+
+func (h *Handler) runAction(w http.ResponseWriter, id, action string) {
+	resp, err := http.Post(h.apiBase+"/api/runs/"+id+"/"+action, "application/json", nil)
+	if err != nil {
+		h.logger.Error("failed to "+action+" run", "error", err)
+		http.Error(w, "Failed to "+action+" run", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Error("failed to close response body", "error", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		h.logger.Error(action+" failed", "status", resp.StatusCode, "body", string(body))
+		http.Error(w, action+" failed", resp.StatusCode)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "runUpdated")
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) deleteSetup(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
@@ -329,7 +507,8 @@ func (h *Handler) deleteSetup(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	if resp.StatusCode != http.StatusNoContent {
+	// Setups with runs are archived rather than deleted.
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		h.logger.Error("delete failed", "status", resp.StatusCode, "body", string(body))
 		http.Error(w, "Delete failed", resp.StatusCode)
@@ -340,6 +519,106 @@ func (h *Handler) deleteSetup(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) activateSetup(w http.ResponseWriter, r *http.Request) {
+	h.setupAction(w, r.PathValue("id"), "activate")
+}
+
+func (h *Handler) deactivateSetup(w http.ResponseWriter, r *http.Request) {
+	h.setupAction(w, r.PathValue("id"), "deactivate")
+}
+
+func (h *Handler) setupAction(w http.ResponseWriter, id, action string) {
+	resp, err := http.Post(h.apiBase+"/api/setups/"+id+"/"+action, "application/json", nil)
+	if err != nil {
+		h.logger.Error("failed to "+action+" setup", "error", err)
+		http.Error(w, "Failed to "+action+" setup", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Error("failed to close response body", "error", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		h.logger.Error(action+" failed", "status", resp.StatusCode, "body", string(body))
+		http.Error(w, action+" failed", resp.StatusCode)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "setupUpdated")
+	w.WriteHeader(http.StatusOK)
+}
+
+func formBody(r *http.Request) (map[string]interface{}, error) {
+	mode := r.FormValue("body_mode")
+	text := r.FormValue("body_text")
+
+	switch mode {
+	case "":
+		return nil, nil
+	case "text":
+		return map[string]interface{}{"mode": mode, "text": text}, nil
+	case "json":
+		if !json.Valid([]byte(text)) {
+			return nil, fmt.Errorf("body is not valid JSON")
+		}
+		return map[string]interface{}{"mode": mode, "json": json.RawMessage(text)}, nil
+	case "form":
+		return map[string]interface{}{"mode": mode, "fields": parseFields(text)}, nil
+	case "random":
+		return map[string]interface{}{"mode": mode, "size": parseIntOrDefault(r.FormValue("body_size"), 1024)}, nil
+	case "multipart", "file":
+		out := map[string]interface{}{"mode": mode}
+		if mode == "multipart" {
+			out["fields"] = parseFields(text)
+		}
+
+		file, header, err := r.FormFile("body_file")
+		if errors.Is(err, http.ErrMissingFile) && mode == "multipart" {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("file is required")
+		}
+		defer func() { _ = file.Close() }()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+
+		field := strings.TrimSpace(r.FormValue("body_file_field"))
+		if field == "" {
+			field = "file"
+		}
+
+		out["files"] = []map[string]interface{}{{
+			"field":        field,
+			"filename":     header.Filename,
+			"content_type": header.Header.Get("Content-Type"),
+			"data":         data,
+		}}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown body mode %q", mode)
+	}
+}
+
+func parseFields(text string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(line, "=")
+		fields[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return fields
+}
+
 func parseIntOrDefault(s string, def int) int {
 	var val int
 	if err := json.Unmarshal([]byte(s), &val); err != nil {
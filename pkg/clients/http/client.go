@@ -17,6 +17,13 @@ type Config struct {
 	TLSHandshakeTimeout time.Duration
 	ExpectTimeout       time.Duration
 	RequestTimeout      time.Duration
+	DisableKeepAlives   bool
+	MaxRequestsPerConn  int
+	LocalAddrs          []net.IP
+	Resolve             map[string][]net.IP
+	DNSServer           string
+	DNSCacheTTL         time.Duration
+	IPFamily            string
 }
 
 func DefaultConfig() *Config {
@@ -38,6 +45,10 @@ func New() *http.Client {
 }
 
 func WithConfig(cfg *Config) *http.Client {
+	dial := NewDialer(cfg).DialContext
+
+	churn := cfg.DisableKeepAlives || cfg.MaxRequestsPerConn > 0
+
 	t := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
 		MaxIdleConns:          cfg.MaxIdleConns,
@@ -45,22 +56,29 @@ func WithConfig(cfg *Config) *http.Client {
 		MaxConnsPerHost:       cfg.MaxIdleConnsPerHost,
 		IdleConnTimeout:       cfg.IdleConnTimeout,
 		DisableCompression:    cfg.DisableCompression,
-		DisableKeepAlives:     false,
-		ForceAttemptHTTP2:     true,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		ForceAttemptHTTP2:     !churn,
 		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
 		ExpectContinueTimeout: cfg.ExpectTimeout,
-		DialContext: (&net.Dialer{
-			Timeout:   cfg.DialTimeout,
-			KeepAlive: cfg.KeepAlive,
-		}).DialContext,
+		DialContext:           dial,
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: false,
 			MinVersion:         tls.VersionTLS12,
 		},
 	}
 
+	if churn {
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	var rt http.RoundTripper = t
+	if cfg.MaxRequestsPerConn > 0 && !cfg.DisableKeepAlives {
+		t.DialContext = countingDial(dial)
+		rt = &connLimitTransport{base: t, maxRequests: int64(cfg.MaxRequestsPerConn)}
+	}
+
 	return &http.Client{
 		Timeout:   cfg.RequestTimeout,
-		Transport: t,
+		Transport: rt,
 	}
 }
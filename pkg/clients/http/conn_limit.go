@@ -0,0 +1,61 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+type countingConn struct {
+	net.Conn
+	requests atomic.Int64
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func countingDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn}, nil
+	}
+}
+
+type connLimitTransport struct {
+	base        *http.Transport
+	maxRequests int64
+}
+
+func (t *connLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn := info.Conn
+			if tc, ok := conn.(*tls.Conn); ok {
+				conn = tc.NetConn()
+			}
+
+			cc, ok := conn.(*countingConn)
+			if !ok {
+				return
+			}
+
+			if cc.requests.Add(1) >= t.maxRequests {
+				req.Header.Set("Connection", "close")
+			}
+		},
+	}
+
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return t.base.RoundTrip(req)
+}
+
+func (t *connLimitTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
@@ -0,0 +1,157 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+const maxLocalAddrs = 4096
+
+type Dialer struct {
+	base net.Dialer
+	// local4 and local6 are the source addresses of each family, used
+	// round-robin.
+	local4   []net.IP
+	local6   []net.IP
+	next4    atomic.Uint64
+	next6    atomic.Uint64
+	resolver *resolver
+}
+
+func NewDialer(cfg *Config) *Dialer {
+	d := &Dialer{
+		base: net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: cfg.KeepAlive,
+		},
+		resolver: newResolver(cfg),
+	}
+
+	for _, ip := range cfg.LocalAddrs {
+		if ip.To4() != nil {
+			d.local4 = append(d.local4, ip)
+		} else {
+			d.local6 = append(d.local6, ip)
+		}
+	}
+
+	return d
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := d.base
+
+	if d.resolver != nil {
+		resolved, err := d.resolver.resolve(ctx, addr)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+
+		network = d.resolver.network(network)
+		addr = resolved
+	}
+
+	if len(d.local4) > 0 || len(d.local6) > 0 {
+		var ip net.IP
+		var err error
+		if network, ip, err = d.source(network, addr); err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// source returns the next source address of the family of the target, and the
+// network to dial it on. A target given by name is dialed in the family of
+// the source addresses, IPv4 if there are both.
+func (d *Dialer) source(network, addr string) (string, net.IP, error) {
+	var ipv6 bool
+
+	switch {
+	case strings.HasSuffix(network, "4"):
+	case strings.HasSuffix(network, "6"):
+		ipv6 = true
+	default:
+		host, _, _ := net.SplitHostPort(addr)
+		if ip, err := netip.ParseAddr(host); err == nil {
+			ipv6 = !ip.Unmap().Is4()
+		} else {
+			ipv6 = len(d.local4) == 0
+			if ipv6 {
+				network += "6"
+			} else {
+				network += "4"
+			}
+		}
+	}
+
+	local, next := d.local4, &d.next4
+	if ipv6 {
+		local, next = d.local6, &d.next6
+	}
+
+	if len(local) == 0 {
+		return network, nil, fmt.Errorf("no source address of the family of %s", addr)
+	}
+
+	return network, local[(next.Add(1)-1)%uint64(len(local))], nil
+}
+
+// ParseLocalAddrs parses source addresses given as IPs or CIDRs. A CIDR
+// stands for its host addresses: the network address, and the broadcast
+// address of IPv4, are left out unless the prefix has only one or two
+// addresses.
+func ParseLocalAddrs(specs []string) ([]net.IP, error) {
+	var out []net.IP
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+
+		if !strings.Contains(spec, "/") {
+			addr, err := netip.ParseAddr(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid source address %q", spec)
+			}
+			out = append(out, net.IP(addr.AsSlice()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source cidr %q", spec)
+		}
+		prefix = prefix.Masked()
+
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		if hostBits >= 32 || 1<<hostBits > maxLocalAddrs {
+			return nil, fmt.Errorf("source cidr %q has more than %d addresses", spec, maxLocalAddrs)
+		}
+
+		size := 1 << hostBits
+		addr := prefix.Addr()
+		for i := range size {
+			host := hostBits < 2 || (i > 0 && (i < size-1 || !addr.Is4()))
+			if host {
+				out = append(out, net.IP(addr.AsSlice()))
+			}
+			addr = addr.Next()
+		}
+	}
+
+	if len(out) > maxLocalAddrs {
+		return nil, fmt.Errorf("too many source addresses, at most %d are allowed", maxLocalAddrs)
+	}
+
+	return out, nil
+}
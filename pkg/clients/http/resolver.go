@@ -0,0 +1,217 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	IPFamilyAny  = "any"
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+type resolver struct {
+	static   map[string][]net.IP
+	lookup   *net.Resolver
+	family   string
+	cacheTTL time.Duration
+	next     atomic.Uint64
+
+	mu    sync.Mutex
+	cache map[string]cachedIPs
+}
+
+type cachedIPs struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+func newResolver(cfg *Config) *resolver {
+	if len(cfg.Resolve) == 0 && cfg.DNSServer == "" && cfg.DNSCacheTTL <= 0 && !restrictsFamily(cfg.IPFamily) {
+		return nil
+	}
+
+	r := &resolver{
+		static:   cfg.Resolve,
+		lookup:   net.DefaultResolver,
+		family:   cfg.IPFamily,
+		cacheTTL: cfg.DNSCacheTTL,
+		cache:    make(map[string]cachedIPs),
+	}
+
+	if cfg.DNSServer != "" {
+		server := cfg.DNSServer
+		r.lookup = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return r
+}
+
+func (r *resolver) network(network string) string {
+	if network != "tcp" && network != "udp" {
+		return network
+	}
+
+	switch r.family {
+	case IPFamilyIPv4:
+		return network + "4"
+	case IPFamilyIPv6:
+		return network + "6"
+	default:
+		return network
+	}
+}
+
+func (r *resolver) resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	ips, err := r.lookupHost(ctx, host, port)
+	if err != nil {
+		return "", err
+	}
+
+	ip := ips[(r.next.Add(1)-1)%uint64(len(ips))]
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+func (r *resolver) lookupHost(ctx context.Context, host, port string) ([]net.IP, error) {
+	host = strings.ToLower(host)
+
+	if ips, ok := r.static[net.JoinHostPort(host, port)]; ok {
+		return r.filter(host, ips)
+	}
+
+	if ips, ok := r.static[host]; ok {
+		return r.filter(host, ips)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	if r.cacheTTL > 0 {
+		r.mu.Lock()
+		entry, ok := r.cache[host]
+		r.mu.Unlock()
+
+		if ok && time.Now().Before(entry.expires) {
+			return entry.ips, nil
+		}
+	}
+
+	ips, err := r.lookup.LookupIP(ctx, r.lookupNetwork(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err = r.filter(host, ips)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.cacheTTL > 0 {
+		r.mu.Lock()
+		r.cache[host] = cachedIPs{ips: ips, expires: time.Now().Add(r.cacheTTL)}
+		r.mu.Unlock()
+	}
+
+	return ips, nil
+}
+
+func (r *resolver) lookupNetwork() string {
+	switch r.family {
+	case IPFamilyIPv4:
+		return "ip4"
+	case IPFamilyIPv6:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+func (r *resolver) filter(host string, ips []net.IP) ([]net.IP, error) {
+	if !restrictsFamily(r.family) {
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses for host %s", host)
+		}
+		return ips, nil
+	}
+
+	out := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (r.family == IPFamilyIPv4) {
+			out = append(out, ip)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no %s addresses for host %s", r.family, host)
+	}
+
+	return out, nil
+}
+
+func restrictsFamily(family string) bool {
+	return family == IPFamilyIPv4 || family == IPFamilyIPv6
+}
+
+func ParseResolve(entries map[string]string) (map[string][]net.IP, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string][]net.IP, len(entries))
+	for key, value := range entries {
+		host := key
+		if h, port, err := net.SplitHostPort(key); err == nil {
+			if port == "" || h == "" {
+				return nil, fmt.Errorf("invalid resolve key %q", key)
+			}
+			host = net.JoinHostPort(h, port)
+		}
+
+		var ips []net.IP
+		for _, raw := range strings.Split(value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(raw))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid resolve address %q for %s", raw, key)
+			}
+			ips = append(ips, ip)
+		}
+
+		out[strings.ToLower(host)] = ips
+	}
+
+	return out, nil
+}
+
+func ParseDNSServer(server string) (string, error) {
+	if server == "" {
+		return "", nil
+	}
+
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+
+	if net.ParseIP(strings.Trim(server, "[]")) == nil {
+		return "", fmt.Errorf("invalid dns server %q", server)
+	}
+
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53"), nil
+}
@@ -0,0 +1,114 @@
+// Package executor is the registry of the executors that run setups. An
+// executor registers itself from an init function of its package:
+//
+//	func init() {
+//		executor.Register("redis", executor.Definition{
+//			New:      func() executor.Executor { return &redisExecutor{} },
+//			Validate: validateRedisSetup,
+//		})
+//	}
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Executor runs the iterations of a single run. Prepare is called once before
+// the first iteration, Execute is called concurrently from many goroutines and
+// Close is called after the last iteration has returned.
+type Executor interface {
+	Prepare(ctx context.Context, setup *Setup) error
+	Execute(ctx context.Context) *Result
+	Close() error
+}
+
+// Result is the outcome of one iteration.
+type Result struct {
+	StatusCode       int
+	Latency          time.Duration
+	ConnectLatency   time.Duration
+	HandshakeLatency time.Duration
+	ConnError        bool
+	SourceIP         string
+	RemoteIP         string
+	BytesRead        int64
+	Operation        string
+	Attempts         int
+	RetryAfter       time.Duration
+	Error            error
+	Timestamp        time.Time
+	Sample           *Sample
+}
+
+// Definition describes a registered executor. Validate is called when a setup
+// is created and checks everything the executor needs from it, the url
+// included. It may be nil if the executor accepts any setup.
+type Definition struct {
+	New      func() Executor
+	Validate func(setup *Setup) error
+}
+
+var (
+	mu        sync.RWMutex
+	executors = make(map[string]Definition)
+)
+
+func Register(name string, def Definition) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if def.New == nil {
+		panic("executor: " + name + " has no constructor")
+	}
+
+	if _, exists := executors[name]; exists {
+		panic("executor: " + name + " registered twice")
+	}
+
+	executors[name] = def
+}
+
+func Lookup(name string) (Definition, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	def, ok := executors[name]
+	return def, ok
+}
+
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	out := make([]string, 0, len(executors))
+	for name := range executors {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// DecodeConfig decodes the executor_config of a setup into out, rejecting
+// unknown fields.
+func DecodeConfig(setup *Setup, out any) error {
+	raw, err := json.Marshal(setup.ExecutorConfig)
+	if err != nil {
+		return fmt.Errorf("encode executor config: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	if err = dec.Decode(out); err != nil {
+		return fmt.Errorf("invalid executor config: %w", err)
+	}
+
+	return nil
+}
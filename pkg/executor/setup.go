@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type SetupStatus string
+
+const (
+	SetupStatusActive   SetupStatus = "active"
+	SetupStatusInactive SetupStatus = "inactive"
+	// SetupStatusArchived marks a deleted setup that is kept for the history
+	// of its runs. It cannot be changed or run until it is activated again.
+	SetupStatusArchived SetupStatus = "archived"
+)
+
+type ConnectionMode string
+
+const (
+	ConnectionModeReuse      ConnectionMode = "reuse"
+	ConnectionModePerRequest ConnectionMode = "per_request"
+)
+
+type PayloadMode string
+
+const (
+	PayloadModeRaw       PayloadMode = "raw"
+	PayloadModeText      PayloadMode = "text"
+	PayloadModeJSON      PayloadMode = "json"
+	PayloadModeForm      PayloadMode = "form"
+	PayloadModeMultipart PayloadMode = "multipart"
+	PayloadModeFile      PayloadMode = "file"
+	PayloadModeRandom    PayloadMode = "random"
+)
+
+type RetryError string
+
+const (
+	RetryErrorConnection RetryError = "connection"
+	RetryErrorTimeout    RetryError = "timeout"
+	RetryErrorAny        RetryError = "any"
+)
+
+// Setup is a load test as it is stored and handed to Prepare. Executors read
+// the fields they support and ignore the others; their own options are in
+// ExecutorConfig, see DecodeConfig.
+type Setup struct {
+	ID                 string
+	Name               string
+	Description        string
+	Executor           string
+	ExecutorConfig     map[string]interface{}
+	Method             string
+	URL                string
+	Body               []byte
+	Payload            *Payload
+	Headers            map[string]string
+	RPS                int
+	Duration           time.Duration
+	Status             SetupStatus
+	HTTPConfig         map[string]interface{}
+	Expect             string
+	Connection         ConnectionMode
+	MaxRequestsPerConn int
+	SourceAddrs        []string
+	Resolve            map[string]string
+	DNSServer          string
+	DNSCacheTTL        time.Duration
+	IPFamily           string
+	Retry              *RetryPolicy
+	// RestartInterrupted starts a new run in place of an interrupted one.
+	RestartInterrupted bool
+
+	// Version counts the changes to what the setup executes, starting at 1.
+	// Every version is kept, so runs can refer to the one they executed.
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Statuses    []int
+	Errors      []RetryError
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+}
+
+type Payload struct {
+	Mode        PayloadMode
+	ContentType string
+	Text        string
+	JSON        json.RawMessage
+	Fields      map[string]string
+	Files       []PayloadFile
+	Size        int
+}
+
+type PayloadFile struct {
+	Field       string
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type SampleReason string
+
+const (
+	SampleReasonFailure SampleReason = "failure"
+	SampleReasonSlow    SampleReason = "slow"
+	SampleReasonRandom  SampleReason = "random"
+)
+
+// Sample is a copy of one request and its response, kept with the run for
+// inspection. An executor sets it on the Result of the few iterations it
+// samples.
+type Sample struct {
+	Reason        SampleReason
+	Timestamp     time.Time
+	Latency       time.Duration
+	Request       SampleRequest
+	StatusCode    int
+	Headers       map[string][]string
+	Body          []byte
+	BodyTruncated bool
+	Error         string
+}
+
+type SampleRequest struct {
+	Method        string
+	URL           string
+	Headers       map[string][]string
+	Body          []byte
+	BodyTruncated bool
+}
@@ -0,0 +1,78 @@
+// Package gnat runs the gnat backend. A binary with extra executors registers
+// them and calls Main:
+//
+//	package main
+//
+//	import (
+//		"github.com/bdtfs/gnat/pkg/gnat"
+//
+//		_ "example.com/loadtest/redisexecutor" // registers "redis"
+//	)
+//
+//	func main() {
+//		gnat.Main()
+//	}
+package gnat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/bdtfs/gnat/internal/di"
+)
+
+const (
+	successExitCode = 0
+	failExitCode    = 1
+)
+
+// Main runs the backend configured from the environment until it receives
+// SIGINT or SIGTERM, then exits the process. Executors registered before it is
+// called are available to setups.
+func Main() {
+	os.Exit(run())
+}
+
+func run() int {
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "panic: %v\n", r)
+			debug.PrintStack()
+			os.Exit(failExitCode)
+		}
+	}()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	c := di.New(ctx)
+	defer c.Shutdown()
+
+	addr := fmt.Sprintf(":%d", c.GetConfig().Application.Port)
+	printWelcome(addr)
+
+	go c.GetRunner().Start(ctx)
+	go c.GetScheduler().Start(ctx)
+	go c.GetRetention().Start(ctx)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.GetServer().Start(ctx)
+	}()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "server error: %v\n", err)
+			return failExitCode
+		}
+	case <-ctx.Done():
+		fmt.Println("\nShutdown signal received, stopping server...")
+	}
+
+	return successExitCode
+}
@@ -0,0 +1,52 @@
+package gnat
+
+import "fmt"
+
+const (
+	version  = "0.1.0"
+	asciiArt = `
++--------------------------------------------------------------+
+|   ____ _   _    _    _____                                   |
+|  / ___| \ | |  / \  |_   _|   GNAT                           |
+| | |  _|  \| | / _ \   | |     Load Testing                   |
+| | |_| | |\  |/ ___ \  | |     Made Beautiful                 |
+|  \____|_| \_/_/   \_\ |_|                                    |
++--------------------------------------------------------------+`
+)
+
+func printWelcome(addr string) {
+	fmt.Println(asciiArt)
+	fmt.Printf("Version: %s\n", version)
+	fmt.Printf("Server started on: %s\n", addr)
+
+	baseURL := "http://localhost" + addr
+
+	fmt.Println("\nEndpoints:")
+	fmt.Printf("  POST   %s/api/setups             - Create test setup\n", baseURL)
+	fmt.Printf("  GET    %s/api/setups             - List all setups\n", baseURL)
+	fmt.Printf("  GET    %s/api/setups/{id}        - Get setup details\n", baseURL)
+	fmt.Printf("  DELETE %s/api/setups/{id}        - Delete setup\n", baseURL)
+	fmt.Printf("  GET    %s/api/executors          - List executors\n", baseURL)
+	fmt.Printf("  POST   %s/api/schedules          - Create schedule\n", baseURL)
+	fmt.Printf("  GET    %s/api/schedules          - List schedules\n", baseURL)
+	fmt.Printf("  PATCH  %s/api/schedules/{id}     - Enable or disable schedule\n", baseURL)
+	fmt.Printf("  DELETE %s/api/schedules/{id}     - Delete schedule\n", baseURL)
+	fmt.Printf("  POST   %s/api/runs               - Start a run\n", baseURL)
+	fmt.Printf("  GET    %s/api/runs               - List all runs\n", baseURL)
+	fmt.Printf("  GET    %s/api/runs/{id}          - Get run details\n", baseURL)
+	fmt.Printf("  PATCH  %s/api/runs/{id}          - Change run rate\n", baseURL)
+	fmt.Printf("  DELETE %s/api/runs/{id}          - Delete finished run\n", baseURL)
+	fmt.Printf("  GET    %s/api/runs/{id}/stats    - Get run statistics\n", baseURL)
+	fmt.Printf("  GET    %s/api/runs/{id}/samples  - Get sampled requests\n", baseURL)
+	fmt.Printf("  GET    %s/api/runs/{id}/events   - Stream run events\n", baseURL)
+	fmt.Printf("  POST   %s/api/runs/{id}/cancel   - Cancel active run\n", baseURL)
+	fmt.Printf("  POST   %s/api/runs/{id}/pause    - Pause active run\n", baseURL)
+	fmt.Printf("  POST   %s/api/runs/{id}/resume   - Resume paused run\n", baseURL)
+	fmt.Printf("  GET    %s/api/queue              - Get run queue\n", baseURL)
+	fmt.Printf("  GET    %s/api/compare            - Compare two runs\n", baseURL)
+	fmt.Printf("  GET    %s/api/export             - Export setups and schedules\n", baseURL)
+	fmt.Printf("  POST   %s/api/import             - Import an export\n", baseURL)
+	fmt.Printf("  GET    %s/api/openapi.json       - Get the OpenAPI document\n", baseURL)
+	fmt.Println("\nReady to accept requests...")
+	fmt.Println()
+}